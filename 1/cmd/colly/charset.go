@@ -0,0 +1,309 @@
+package colly
+
+import (
+	"bytes"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/saintfish/chardet"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	xunicode "golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+)
+
+// charsetSampleSize is the number of bytes the charset of a body is
+// guessed from
+const charsetSampleSize = 64 * 1024
+
+// metaPrescanSize is the number of bytes searched for a <meta> charset
+// declaration. The HTML standard looks at the first 1024 bytes only,
+// real pages put the declaration after long scripts and styles.
+const metaPrescanSize = 4096
+
+// maxMojibakeShare is the share of the suspicious runes among the
+// non-ASCII runes of a text above which the text is garbled
+const maxMojibakeShare = 0.15
+
+// mojibakeRepairGain is how many times more Russian a repaired text
+// has to look for the repair to be taken
+const mojibakeRepairGain = 2
+
+var boms = []struct {
+	bom      []byte
+	encoding encoding.Encoding
+}{
+	{[]byte{0xef, 0xbb, 0xbf}, xunicode.UTF8},
+	{[]byte{0x00, 0x00, 0xfe, 0xff}, utf32.UTF32(utf32.BigEndian, utf32.IgnoreBOM)},
+	{[]byte{0xff, 0xfe, 0x00, 0x00}, utf32.UTF32(utf32.LittleEndian, utf32.IgnoreBOM)},
+	{[]byte{0xfe, 0xff}, xunicode.UTF16(xunicode.BigEndian, xunicode.IgnoreBOM)},
+	{[]byte{0xff, 0xfe}, xunicode.UTF16(xunicode.LittleEndian, xunicode.IgnoreBOM)},
+}
+
+// cyrillicCharsets are the legacy encodings of Russian text
+// the statistical guess chooses from
+var cyrillicCharsets = []encoding.Encoding{charmap.Windows1251, charmap.KOI8R, charmap.CodePage866}
+
+// mojibakeRepairs are the wrong decodings a garbled text is undone from:
+// the text is encoded back with the first encoding and decoded with the
+// second one, nil means UTF-8.
+var mojibakeRepairs = []struct {
+	decodedAs, encodedIn encoding.Encoding
+}{
+	{charmap.Windows1251, nil},
+	{charmap.Windows1252, nil},
+	{charmap.Windows1251, charmap.KOI8R},
+	{charmap.KOI8R, charmap.Windows1251},
+}
+
+// russianLetterFrequency is the frequency of the letters in Russian text, in percent
+var russianLetterFrequency = map[rune]float64{
+	'о': 10.97, 'е': 8.45, 'а': 8.01, 'и': 7.35, 'н': 6.70, 'т': 6.26, 'с': 5.47,
+	'р': 4.73, 'в': 4.54, 'л': 4.40, 'к': 3.49, 'м': 3.21, 'д': 2.98, 'п': 2.81,
+	'у': 2.62, 'я': 2.01, 'ы': 1.90, 'ь': 1.74, 'г': 1.70, 'з': 1.65, 'б': 1.59,
+	'ч': 1.44, 'й': 1.21, 'х': 0.97, 'ж': 0.94, 'ш': 0.73, 'ю': 0.64, 'ц': 0.48,
+	'щ': 0.36, 'э': 0.32, 'ф': 0.26, 'ъ': 0.04, 'ё': 0.04,
+}
+
+var metaCharset = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-z0-9_:.-]+)`)
+
+// resolveCharset returns the encoding of body and the length of its byte
+// order mark. The byte order mark decides first, then the charset declared
+// by contentType, then the one declared by a <meta> tag of an HTML body.
+// A declaration which does not match the body is skipped: UTF-8 for a body
+// which is not UTF-8, a legacy charset for a body which is. Without a valid
+// declaration a UTF-8 body is UTF-8 and the charset of any other body is
+// guessed if guess is true, nil means it is unknown.
+func resolveCharset(body []byte, contentType string, guess bool) (encoding.Encoding, int) {
+	for _, b := range boms {
+		if bytes.HasPrefix(body, b.bom) {
+			return b.encoding, len(b.bom)
+		}
+	}
+
+	isUTF8 := validUTF8(body)
+	for _, declared := range declaredCharsets(body, contentType) {
+		e, name := charset.Lookup(declared)
+		switch {
+		case e == nil:
+			continue
+		case name == "utf-8" && !isUTF8:
+			continue
+		case name == "utf-8" || isUTF8 && hasNonASCII(body):
+			return xunicode.UTF8, 0
+		}
+		return e, 0
+	}
+
+	if isUTF8 {
+		return xunicode.UTF8, 0
+	}
+	if !guess {
+		return nil, 0
+	}
+
+	return guessCharset(body), 0
+}
+
+// declaredCharsets returns the charset of contentType
+// and the one of a <meta> tag of an HTML body
+func declaredCharsets(body []byte, contentType string) []string {
+	var declared []string
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		declared = append(declared, params["charset"])
+	}
+
+	if contentType == "" || strings.Contains(contentType, "html") {
+		sample := body
+		if len(sample) > metaPrescanSize {
+			sample = sample[:metaPrescanSize]
+		}
+		if m := metaCharset.FindSubmatch(sample); m != nil {
+			declared = append(declared, string(m[1]))
+		}
+	}
+
+	return declared
+}
+
+// validUTF8 reports whether b is valid UTF-8, a rune cut at
+// the end of a truncated body does not count
+func validUTF8(b []byte) bool {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				b = b[:i]
+			}
+			break
+		}
+	}
+
+	return utf8.Valid(b)
+}
+
+func hasNonASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			return true
+		}
+	}
+
+	return false
+}
+
+// guessCharset picks the Cyrillic encoding which gives the most Russian
+// looking text, the charset detected by chardet if none gives Russian text
+func guessCharset(body []byte) encoding.Encoding {
+	sample := body
+	if len(sample) > charsetSampleSize {
+		sample = sample[:charsetSampleSize]
+	}
+
+	var best encoding.Encoding
+	bestScore := 0.0
+	for _, e := range cyrillicCharsets {
+		decoded, err := e.NewDecoder().Bytes(sample)
+		if err != nil {
+			continue
+		}
+		if score := russianScore(string(decoded)); score > bestScore {
+			best, bestScore = e, score
+		}
+	}
+	if best != nil {
+		return best
+	}
+
+	detected, err := chardet.NewTextDetector().DetectBest(body)
+	if err != nil {
+		return nil
+	}
+	e, _ := charset.Lookup(detected.Charset)
+
+	return e
+}
+
+// russianScore rates how much text looks like Russian. The letters add
+// their frequency, the runes which are typical for a wrong decoding
+// subtract. A text without Cyrillic letters scores 0.
+func russianScore(text string) float64 {
+	score := 0.0
+	var previous rune
+	for _, r := range text {
+		if isSuspicious(r, previous) {
+			score -= 5
+		} else if frequency, ok := russianLetterFrequency[unicode.ToLower(r)]; ok {
+			if unicode.IsUpper(r) {
+				// capitals start words and sentences only
+				frequency /= 2
+			}
+			score += frequency
+		}
+		previous = r
+	}
+
+	return score
+}
+
+// isSuspicious reports whether r following previous is typical for text
+// decoded with a wrong charset: a capital Cyrillic letter after a small
+// one, a Cyrillic letter which is not Russian, a box drawing character,
+// the replacement character or a Latin-1 character after another one
+// like the bytes of a UTF-8 sequence decoded one by one.
+func isSuspicious(r rune, previous rune) bool {
+	switch {
+	case r == utf8.RuneError:
+		return true
+	case r >= 0x2500 && r <= 0x259f:
+		return true
+	case r >= 0x80 && r <= 0xbf:
+		return previous >= 0xc2 && previous <= 0xdf
+	case r >= 0x400 && r <= 0x4ff:
+		if _, russian := russianLetterFrequency[unicode.ToLower(r)]; !russian {
+			return true
+		}
+		return unicode.IsUpper(r) && unicode.IsLower(previous) && previous >= 0x400 && previous <= 0x4ff
+	}
+
+	return false
+}
+
+// suspiciousShare returns the share of the suspicious runes
+// among the non-ASCII runes of text
+func suspiciousShare(text string) float64 {
+	var suspicious, nonASCII int
+	var previous rune
+	for _, r := range text {
+		if r >= utf8.RuneSelf {
+			nonASCII++
+			if isSuspicious(r, previous) {
+				suspicious++
+			}
+		}
+		previous = r
+	}
+	if nonASCII == 0 {
+		return 0
+	}
+
+	return float64(suspicious) / float64(nonASCII)
+}
+
+// IsMojibake reports whether text looks like Russian text which has been
+// decoded with a wrong charset: it has many suspicious characters or it
+// looks much more Russian once the wrong decoding is undone.
+func IsMojibake(text string) bool {
+	if suspiciousShare(text) > maxMojibakeShare {
+		return true
+	}
+	_, repaired := RepairMojibake(text)
+
+	return repaired
+}
+
+// RepairMojibake undoes a wrong decoding of text, e.g. of UTF-8 as
+// windows-1251 or of KOI8-R as windows-1251. It returns false if text
+// is not garbled or can not be repaired.
+func RepairMojibake(text string) (string, bool) {
+	if strings.IndexFunc(text, func(r rune) bool { return r >= 0x80 && r <= 0x4ff }) < 0 {
+		// neither Latin-1 nor Cyrillic, no wrong decoding to undo
+		return text, false
+	}
+
+	best, bestScore := text, russianScore(text)
+	needed := mojibakeRepairGain * bestScore
+	if needed < 0 {
+		needed = 0
+	}
+	for _, repair := range mojibakeRepairs {
+		raw, err := repair.decodedAs.NewEncoder().String(text)
+		if err != nil {
+			// text has characters the wrong charset does not have
+			continue
+		}
+
+		var repaired string
+		if repair.encodedIn == nil {
+			if !utf8.ValidString(raw) {
+				continue
+			}
+			repaired = raw
+		} else if repaired, err = repair.encodedIn.NewDecoder().String(raw); err != nil {
+			continue
+		}
+
+		if score := russianScore(repaired); score > needed && score > bestScore {
+			best, bestScore = repaired, score
+		}
+	}
+
+	if best == text || suspiciousShare(best) > maxMojibakeShare {
+		return text, false
+	}
+
+	return best, true
+}
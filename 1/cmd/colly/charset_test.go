@@ -0,0 +1,110 @@
+package colly
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+const russianText = "Съешь же ещё этих мягких французских булок, да выпей чаю. Широкая электрификация южных губерний даст мощный толчок подъёму сельского хозяйства."
+
+func encode(t *testing.T, e *charmap.Charmap, text string) []byte {
+	b, err := e.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func fixedBody(t *testing.T, body []byte, contentType string, detect bool) string {
+	headers := http.Header{}
+	if contentType != "" {
+		headers.Set("Content-Type", contentType)
+	}
+	r := &Response{Body: body, Headers: &headers}
+	if err := r.fixCharset(detect, ""); err != nil {
+		t.Fatal(err)
+	}
+	return string(r.Body)
+}
+
+func TestFixCharset(t *testing.T) {
+	html := "<html><head><title>" + russianText + "</title></head></html>"
+	metaHTML := `<html><head><meta http-equiv="Content-Type" content="text/html; charset=windows-1251"><title>` + russianText + "</title></head></html>"
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		detect      bool
+		want        string
+	}{
+		{"utf-8 bom", append([]byte{0xef, 0xbb, 0xbf}, html...), "text/html; charset=windows-1251", false, html},
+		{"header", encode(t, charmap.Windows1251, html), "text/html; charset=windows-1251", false, html},
+		{"header koi8-r", encode(t, charmap.KOI8R, html), "text/html; charset=koi8-r", false, html},
+		{"meta", encode(t, charmap.Windows1251, metaHTML), "text/html", false, metaHTML},
+		{"wrong utf-8 header", encode(t, charmap.Windows1251, metaHTML), "text/html; charset=utf-8", false, metaHTML},
+		{"wrong legacy header", []byte(html), "text/html; charset=windows-1251", false, html},
+		{"guess windows-1251", encode(t, charmap.Windows1251, html), "text/html", true, html},
+		{"guess koi8-r", encode(t, charmap.KOI8R, html), "", true, html},
+		{"guess cp866", encode(t, charmap.CodePage866, html), "text/html", true, html},
+		{"utf-8", []byte(html), "text/html", true, html},
+	}
+
+	for _, test := range tests {
+		if got := fixedBody(t, test.body, test.contentType, test.detect); got != test.want {
+			t.Errorf("%s: got %q", test.name, got)
+		}
+	}
+}
+
+func TestFixCharsetUnknown(t *testing.T) {
+	body := encode(t, charmap.Windows1251, russianText)
+	if got := fixedBody(t, body, "text/html", false); got != string(body) {
+		t.Errorf("an undeclared charset should not be guessed without DetectCharset, got %q", got)
+	}
+}
+
+func TestFixCharsetRepairsMojibake(t *testing.T) {
+	// UTF-8 text decoded as windows-1251
+	garbled, err := charmap.Windows1251.NewDecoder().String(russianText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fixedBody(t, []byte(garbled), "text/html; charset=utf-8", true); got != russianText {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRepairMojibake(t *testing.T) {
+	utf8As1251, _ := charmap.Windows1251.NewDecoder().String(russianText)
+	koi8As1251, _ := charmap.Windows1251.NewDecoder().Bytes(encode(t, charmap.KOI8R, russianText))
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"utf-8 as windows-1251", utf8As1251},
+		{"koi8-r as windows-1251", string(koi8As1251)},
+	}
+
+	for _, test := range tests {
+		if !IsMojibake(test.text) {
+			t.Errorf("%s: %q should be mojibake", test.name, test.text)
+		}
+		repaired, ok := RepairMojibake(test.text)
+		if !ok || repaired != russianText {
+			t.Errorf("%s: got %q, %v", test.name, repaired, ok)
+		}
+	}
+}
+
+func TestIsMojibakeClean(t *testing.T) {
+	for _, text := range []string{russianText, "Plain ASCII text", "Crème brûlée — Москва", ""} {
+		if IsMojibake(text) {
+			t.Errorf("%q should not be mojibake", text)
+		}
+		if _, ok := RepairMojibake(text); ok {
+			t.Errorf("%q should not be repaired", text)
+		}
+	}
+}
@@ -41,11 +41,12 @@ import (
 	"github.com/antchfx/htmlquery"
 	"github.com/antchfx/xmlquery"
 	"github.com/gocolly/colly/v2/debug"
-	"github.com/gocolly/colly/v2/storage"
 	"github.com/kennygrant/sanitize"
 	whatwgUrl "github.com/nlnwa/whatwg-url/url"
 	"github.com/temoto/robotstxt"
 	"google.golang.org/appengine/urlfetch"
+
+	"colly/storage"
 )
 
 // A CollectorOption sets an option on a Collector.
@@ -92,6 +93,10 @@ type Collector struct {
 	// the target host's robots.txt file.  See http://www.robotstxt.org/ for more
 	// information.
 	IgnoreRobotsTxt bool
+	// HonorCrawlDelay makes the Collector read the robots.txt of every host
+	// for its Crawl-delay even if IgnoreRobotsTxt is true. The delay is kept
+	// by the AdaptiveThrottle, the Collector does nothing without it.
+	HonorCrawlDelay bool
 	// Async turns on asynchronous network communication. Use Collector.Wait() to
 	// be sure all requests have been finished.
 	Async bool
@@ -457,6 +462,14 @@ func Debugger(d debug.Debugger) CollectorOption {
 	}
 }
 
+// HonorCrawlDelay instructs the Collector to honor the Crawl-delay of the
+// target host's robots.txt file even if it ignores its other restrictions.
+func HonorCrawlDelay() CollectorOption {
+	return func(c *Collector) {
+		c.HonorCrawlDelay = true
+	}
+}
+
 // CheckHead performs a HEAD request before every GET to pre-validate the response
 func CheckHead() CollectorOption {
 	return func(c *Collector) {
@@ -464,6 +477,18 @@ func CheckHead() CollectorOption {
 	}
 }
 
+// AdaptiveThrottle makes the Collector adapt the delay between the requests
+// to every host. It honors the Crawl-delay of robots.txt (robots.txt is read
+// only if IgnoreRobotsTxt is false or HonorCrawlDelay is true) and Retry-After headers, backs off
+// exponentially on 429 and 503 responses and speeds up again gradually on
+// healthy responses. No delay exceeds maxDelay. The throttle works on top of
+// the LimitRules, and it is shared with the clones of the Collector.
+func AdaptiveThrottle(maxDelay time.Duration) CollectorOption {
+	return func(c *Collector) {
+		c.backend.throttle = newThrottle(maxDelay)
+	}
+}
+
 // Init initializes the Collector's private variables and sets default
 // configuration for the Collector
 func (c *Collector) Init() {
@@ -715,12 +740,65 @@ func (c *Collector) fetch(u, method string, depth int, requestData io.Reader, ct
 	if err := c.handleOnError(response, err, request, ctx); err != nil {
 		return err
 	}
+	response.Trace = hTrace
+
+	return c.handleResponse(response, request, ctx)
+}
+
+// Replay calls the callbacks of the collector with response, a stored
+// response to a GET request of URL, as if it had just been fetched. Nothing
+// is sent over the network and the filters, robots.txt and revisit checks
+// are not applied. ctx can be nil.
+func (c *Collector) Replay(URL string, depth int, ctx *Context, response *Response) error {
+	parsedWhatwgURL, err := urlParser.Parse(URL)
+	if err != nil {
+		return err
+	}
+	parsedURL, err := url.Parse(parsedWhatwgURL.Href(false))
+	if err != nil {
+		return err
+	}
+	if ctx == nil {
+		ctx = NewContext()
+	}
+	hdr := http.Header{}
+	request := &Request{
+		URL:       parsedURL,
+		Headers:   &hdr,
+		Host:      parsedURL.Host,
+		Ctx:       ctx,
+		Depth:     depth,
+		Method:    "GET",
+		collector: c,
+		ID:        atomic.AddUint32(&c.requestCount, 1),
+	}
+
+	c.handleOnRequest(request)
+	if request.abort {
+		return nil
+	}
+
+	if response.Headers == nil {
+		response.Headers = &http.Header{}
+	}
+	c.handleOnResponseHeaders(&Response{Ctx: ctx, Request: request, StatusCode: response.StatusCode, Headers: response.Headers})
+	if request.abort {
+		return nil
+	}
+	if err := c.handleOnError(response, nil, request, ctx); err != nil {
+		return err
+	}
+
+	return c.handleResponse(response, request, ctx)
+}
+
+// handleResponse calls the callbacks of a successful response
+func (c *Collector) handleResponse(response *Response, request *Request, ctx *Context) error {
 	atomic.AddUint32(&c.responseCount, 1)
 	response.Ctx = ctx
 	response.Request = request
-	response.Trace = hTrace
 
-	err = response.fixCharset(c.DetectCharset, request.ResponseCharacterEncoding)
+	err := response.fixCharset(c.DetectCharset, request.ResponseCharacterEncoding)
 	if err != nil {
 		return err
 	}
@@ -753,7 +831,7 @@ func (c *Collector) requestCheck(parsedURL *url.URL, method string, getBody func
 	if err := c.checkFilters(u, parsedURL.Hostname()); err != nil {
 		return err
 	}
-	if method != "HEAD" && !c.IgnoreRobotsTxt {
+	if method != "HEAD" && (!c.IgnoreRobotsTxt || c.HonorCrawlDelay && c.backend.throttle != nil) {
 		if err := c.checkRobots(parsedURL); err != nil {
 			return err
 		}
@@ -830,18 +908,28 @@ func (c *Collector) checkRobots(u *url.URL) error {
 	if !ok {
 		// no robots file cached
 		resp, err := c.backend.Client.Get(u.Scheme + "://" + u.Host + "/robots.txt")
-		if err != nil {
-			return err
+		if err == nil {
+			defer resp.Body.Close()
+			robot, err = robotstxt.FromResponse(resp)
 		}
-		defer resp.Body.Close()
-
-		robot, err = robotstxt.FromResponse(resp)
-		if err != nil {
+		if err != nil && !c.IgnoreRobotsTxt {
 			return err
+		} else if err != nil {
+			// only the Crawl-delay is read, a host without a readable
+			// robots.txt has none and is not asked again
+			robot = &robotstxt.RobotsData{}
 		}
 		c.lock.Lock()
 		c.robotsMap[u.Host] = robot
 		c.lock.Unlock()
+
+		if group := robot.FindGroup(c.UserAgent); group != nil && c.backend.throttle != nil {
+			c.backend.throttle.SetCrawlDelay(u.Host, group.CrawlDelay)
+		}
+	}
+
+	if c.IgnoreRobotsTxt {
+		return nil
 	}
 
 	uaGroup := robot.FindGroup(c.UserAgent)
@@ -859,6 +947,24 @@ func (c *Collector) checkRobots(u *url.URL) error {
 	return nil
 }
 
+// HostDelay returns the current delay between the requests to host set by
+// AdaptiveThrottle. It is 0 when the throttle is not enabled.
+func (c *Collector) HostDelay(host string) time.Duration {
+	if c.backend.throttle == nil {
+		return 0
+	}
+	return c.backend.throttle.Delay(host)
+}
+
+// HostDelays returns the hosts which AdaptiveThrottle currently delays and
+// their delays. It is empty when the throttle is not enabled.
+func (c *Collector) HostDelays() map[string]time.Duration {
+	if c.backend.throttle == nil {
+		return nil
+	}
+	return c.backend.throttle.Delays()
+}
+
 // String is the text representation of the collector.
 // It contains useful debug information about the collector's internals
 func (c *Collector) String() string {
@@ -1327,6 +1433,7 @@ func (c *Collector) Clone() *Collector {
 		DisallowedDomains:      c.DisallowedDomains,
 		ID:                     atomic.AddUint32(&collectorCounter, 1),
 		IgnoreRobotsTxt:        c.IgnoreRobotsTxt,
+		HonorCrawlDelay:        c.HonorCrawlDelay,
 		MaxBodySize:            c.MaxBodySize,
 		MaxDepth:               c.MaxDepth,
 		MaxRequests:            c.MaxRequests,
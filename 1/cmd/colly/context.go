@@ -18,6 +18,19 @@ import (
 	"sync"
 )
 
+// Keys of the values the crawler puts into the context of every request.
+// The processors of the pages read them, so they are kept here rather than
+// in the crawler.
+const (
+	// OriginalURLCtxKey holds the requested url, before redirects.
+	OriginalURLCtxKey = "crawler.originalUrl"
+	// FetchedAtCtxKey holds the time.Time the response was received.
+	FetchedAtCtxKey = "crawler.fetchedAt"
+	// CanonicalURLCtxKey holds the canonical form of the requested url,
+	// which identifies the page in the link graph.
+	CanonicalURLCtxKey = "crawler.canonicalUrl"
+)
+
 // Context provides a tiny layer for passing data between callbacks
 type Context struct {
 	contextMap map[string]interface{}
@@ -15,6 +15,7 @@ require (
 	github.com/saintfish/chardet v0.0.0-20120816061221-3af4cd4741ca
 	github.com/temoto/robotstxt v1.1.1
 	golang.org/x/net v0.17.0
+	golang.org/x/text v0.13.0
 	google.golang.org/appengine v1.6.6
 	google.golang.org/protobuf v1.33.0 // indirect
 )
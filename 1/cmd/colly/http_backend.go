@@ -37,6 +37,7 @@ type httpBackend struct {
 	LimitRules []*LimitRule
 	Client     *http.Client
 	lock       *sync.RWMutex
+	throttle   *throttle
 }
 
 type checkHeadersFunc func(req *http.Request, statusCode int, header http.Header) bool
@@ -132,17 +133,11 @@ func (h *httpBackend) Cache(request *http.Request, bodySize int, checkHeadersFun
 	if cacheDir == "" || request.Method != "GET" || request.Header.Get("Cache-Control") == "no-cache" {
 		return h.Do(request, bodySize, checkHeadersFunc)
 	}
-	sum := sha1.Sum([]byte(request.URL.String()))
-	hash := hex.EncodeToString(sum[:])
-	dir := path.Join(cacheDir, hash[:2])
-	filename := path.Join(dir, hash)
-	if file, err := os.Open(filename); err == nil {
-		resp := new(Response)
-		err := gob.NewDecoder(file).Decode(resp)
-		file.Close()
+	dir, filename := cachePath(cacheDir, request.URL.String())
+	if resp, err := LoadCachedResponse(cacheDir, request.URL.String()); err == nil {
 		checkHeadersFunc(request, resp.StatusCode, *resp.Headers)
 		if resp.StatusCode < 500 {
-			return resp, err
+			return resp, nil
 		}
 	}
 	resp, err := h.Do(request, bodySize, checkHeadersFunc)
@@ -166,6 +161,34 @@ func (h *httpBackend) Cache(request *http.Request, bodySize int, checkHeadersFun
 	return resp, os.Rename(filename+"~", filename)
 }
 
+// cachePath returns the dir and the file of the cached response of URL
+func cachePath(cacheDir, URL string) (string, string) {
+	sum := sha1.Sum([]byte(URL))
+	hash := hex.EncodeToString(sum[:])
+	dir := path.Join(cacheDir, hash[:2])
+	return dir, path.Join(dir, hash)
+}
+
+// LoadCachedResponse reads the response to a GET request of URL from the
+// cacheDir of a collector. The error satisfies os.IsNotExist when the
+// response is not cached.
+func LoadCachedResponse(cacheDir, URL string) (*Response, error) {
+	_, filename := cachePath(cacheDir, URL)
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	resp := new(Response)
+	if err := gob.NewDecoder(file).Decode(resp); err != nil {
+		return nil, err
+	}
+	if resp.Headers == nil {
+		resp.Headers = &http.Header{}
+	}
+	return resp, nil
+}
+
 func (h *httpBackend) Do(request *http.Request, bodySize int, checkHeadersFunc checkHeadersFunc) (*Response, error) {
 	r := h.GetMatchingRule(request.URL.Host)
 	if r != nil {
@@ -179,6 +202,11 @@ func (h *httpBackend) Do(request *http.Request, bodySize int, checkHeadersFunc c
 			<-r.waitChan
 		}(r)
 	}
+	if h.throttle != nil {
+		if err := h.throttle.Wait(request.Context(), request.URL.Host); err != nil {
+			return nil, err
+		}
+	}
 
 	res, err := h.Client.Do(request)
 	if err != nil {
@@ -186,6 +214,10 @@ func (h *httpBackend) Do(request *http.Request, bodySize int, checkHeadersFunc c
 	}
 	defer res.Body.Close()
 
+	if h.throttle != nil {
+		h.throttle.Update(request.URL.Host, res.StatusCode, res.Header)
+	}
+
 	finalRequest := request
 	if res.Request != nil {
 		finalRequest = res.Request
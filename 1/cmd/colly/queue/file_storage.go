@@ -0,0 +1,280 @@
+package queue
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"colly"
+)
+
+// fileHeaderSize is the size of the persisted offset of the first
+// unacknowledged record stored at the beginning of the queue file
+const fileHeaderSize = 8
+
+// recordHeaderSize is the size of the length prefix of every request record
+const recordHeaderSize = 4
+
+// defaultCompactThreshold is the number of consumed bytes after which
+// the queue file is rewritten without the consumed records
+const defaultCompactThreshold = 32 * 1024 * 1024
+
+// ErrCorruptedQueueFile is returned if the queue file header points
+// outside of the stored records
+var ErrCorruptedQueueFile = errors.New("Corrupted queue file")
+
+// FileQueueStorage is a Storage implementation which keeps the request
+// queue in a single file, so pending requests survive process restarts.
+//
+// The file starts with the offset of the oldest record which has not been
+// acknowledged followed by length-prefixed serialized requests. Requests are
+// only appended, the offset is overwritten in place after every Ack. A request
+// returned by GetAckRequest stays in the file until it is acknowledged, so
+// the requests which were being performed when the process died are returned
+// again by the next run, together with the ones acknowledged after the oldest
+// of them. Acknowledged records are dropped once the queue becomes empty or
+// the acknowledged part of the file grows over CompactThreshold.
+type FileQueueStorage struct {
+	// Path is the location of the queue file. It is created by Init
+	// if it does not exist.
+	Path string
+	// MaxSize defines the capacity of the queue.
+	// New requests are discarded if the queue size reaches MaxSize
+	MaxSize int
+	// CompactThreshold is the number of acknowledged bytes which triggers
+	// the rewrite of the queue file. The default is 32MB.
+	CompactThreshold int64
+	lock             *sync.Mutex
+	file             *os.File
+	readOffset       int64
+	writeOffset      int64
+	size             int
+	// taken are the ids of the returned requests which have not been
+	// acknowledged. The id of a request is the offset of its record plus
+	// dropped, the number of bytes removed from the file by compactions.
+	taken   map[int64]struct{}
+	dropped int64
+}
+
+// Init implements Storage.Init() function.
+// Init opens the queue file and restores the queue state from it.
+// A partially written trailing record is discarded.
+func (q *FileQueueStorage) Init() error {
+	q.lock = &sync.Mutex{}
+	q.taken = make(map[int64]struct{})
+	if q.CompactThreshold <= 0 {
+		q.CompactThreshold = defaultCompactThreshold
+	}
+	f, err := os.OpenFile(q.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	q.file = f
+	if err := q.restore(); err != nil {
+		f.Close()
+		return err
+	}
+	return nil
+}
+
+func (q *FileQueueStorage) restore() error {
+	stat, err := q.file.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() < fileHeaderSize {
+		return q.reset()
+	}
+	var header [fileHeaderSize]byte
+	if _, err := q.file.ReadAt(header[:], 0); err != nil {
+		return err
+	}
+	q.readOffset = int64(binary.LittleEndian.Uint64(header[:]))
+	if q.readOffset < fileHeaderSize || q.readOffset > stat.Size() {
+		return ErrCorruptedQueueFile
+	}
+
+	var lenBuf [recordHeaderSize]byte
+	offset := q.readOffset
+	q.size = 0
+	for offset+recordHeaderSize <= stat.Size() {
+		if _, err := q.file.ReadAt(lenBuf[:], offset); err != nil {
+			return err
+		}
+		next := offset + recordHeaderSize + int64(binary.LittleEndian.Uint32(lenBuf[:]))
+		if next > stat.Size() {
+			break
+		}
+		offset = next
+		q.size++
+	}
+	q.writeOffset = offset
+	if offset != stat.Size() {
+		// drop the record which was being written when the process died
+		if err := q.file.Truncate(offset); err != nil {
+			return err
+		}
+	}
+	if q.size == 0 {
+		return q.reset()
+	}
+	return nil
+}
+
+// reset truncates the queue file to an empty queue
+func (q *FileQueueStorage) reset() error {
+	if err := q.file.Truncate(0); err != nil {
+		return err
+	}
+	q.readOffset = fileHeaderSize
+	q.writeOffset = fileHeaderSize
+	q.size = 0
+	return q.writeFirstOffset(fileHeaderSize)
+}
+
+func (q *FileQueueStorage) writeFirstOffset(offset int64) error {
+	var header [fileHeaderSize]byte
+	binary.LittleEndian.PutUint64(header[:], uint64(offset))
+	_, err := q.file.WriteAt(header[:], 0)
+	return err
+}
+
+// AddRequest implements Storage.AddRequest() function
+func (q *FileQueueStorage) AddRequest(r []byte) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	// Discard URLs if size limit exceeded
+	if q.MaxSize > 0 && q.size >= q.MaxSize {
+		return colly.ErrQueueFull
+	}
+	record := make([]byte, recordHeaderSize+len(r))
+	binary.LittleEndian.PutUint32(record, uint32(len(r)))
+	copy(record[recordHeaderSize:], r)
+	if _, err := q.file.WriteAt(record, q.writeOffset); err != nil {
+		return err
+	}
+	q.writeOffset += int64(len(record))
+	q.size++
+	return nil
+}
+
+// GetRequest implements Storage.GetRequest() function.
+// The request is acknowledged at once.
+func (q *FileQueueStorage) GetRequest() ([]byte, error) {
+	id, r, err := q.GetAckRequest()
+	if err != nil || r == nil {
+		return r, err
+	}
+	return r, q.Ack(id)
+}
+
+// GetAckRequest implements AckStorage.GetAckRequest() function
+func (q *FileQueueStorage) GetAckRequest() (int64, []byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.size == 0 {
+		return 0, nil, nil
+	}
+	var lenBuf [recordHeaderSize]byte
+	if _, err := q.file.ReadAt(lenBuf[:], q.readOffset); err != nil {
+		return 0, nil, err
+	}
+	r := make([]byte, binary.LittleEndian.Uint32(lenBuf[:]))
+	if _, err := q.file.ReadAt(r, q.readOffset+recordHeaderSize); err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	id := q.dropped + q.readOffset
+	q.taken[id] = struct{}{}
+	q.readOffset += recordHeaderSize + int64(len(r))
+	q.size--
+	return id, r, nil
+}
+
+// Ack implements AckStorage.Ack() function
+func (q *FileQueueStorage) Ack(id int64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if _, taken := q.taken[id]; !taken {
+		return nil
+	}
+	delete(q.taken, id)
+	if q.size == 0 && len(q.taken) == 0 {
+		return q.reset()
+	}
+	first := q.firstOffset()
+	if first-fileHeaderSize >= q.CompactThreshold && 2*first >= q.writeOffset {
+		return q.compact(first)
+	}
+	return q.writeFirstOffset(first)
+}
+
+// firstOffset returns the offset of the oldest record
+// which has not been acknowledged
+func (q *FileQueueStorage) firstOffset() int64 {
+	first := q.readOffset
+	for id := range q.taken {
+		if offset := id - q.dropped; offset < first {
+			first = offset
+		}
+	}
+	return first
+}
+
+// compact rewrites the queue file without the records before first.
+// The new file replaces the old one atomically, so an interrupted
+// compaction leaves the previous file intact.
+func (q *FileQueueStorage) compact(first int64) error {
+	tmpPath := q.Path + "~"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var header [fileHeaderSize]byte
+	binary.LittleEndian.PutUint64(header[:], fileHeaderSize)
+	if _, err := tmp.Write(header[:]); err == nil {
+		_, err = io.Copy(tmp, io.NewSectionReader(q.file, first, q.writeOffset-first))
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, q.Path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	q.file.Close()
+	q.file = tmp
+	removed := first - fileHeaderSize
+	q.writeOffset -= removed
+	q.readOffset -= removed
+	q.dropped += removed
+	return nil
+}
+
+// QueueSize implements Storage.QueueSize() function
+func (q *FileQueueStorage) QueueSize() (int, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.size, nil
+}
+
+// Close persists the offset of the first unacknowledged record
+// and closes the queue file
+func (q *FileQueueStorage) Close() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.file == nil {
+		return nil
+	}
+	err := q.writeFirstOffset(q.firstOffset())
+	if cerr := q.file.Close(); err == nil {
+		err = cerr
+	}
+	q.file = nil
+	return err
+}
@@ -0,0 +1,408 @@
+package queue
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"colly"
+)
+
+// PriorityStorage is a Storage which returns the request with the highest
+// priority first. Requests of equal priority are returned in the order they
+// were added. AddRequest adds a request with priority 0.
+type PriorityStorage interface {
+	Storage
+	// AddPriorityRequest adds a serialized request with the given priority
+	AddPriorityRequest([]byte, float64) error
+}
+
+// priorityItem is a request waiting in a priority storage. The in-memory
+// storage keeps the request itself, the file storage keeps its offset.
+type priorityItem struct {
+	priority float64
+	seq      int64
+	request  []byte
+	offset   int64
+	length   int
+}
+
+// priorityHeap is a max-heap of requests by priority, then by seq
+type priorityHeap []*priorityItem
+
+func (h priorityHeap) Len() int { return len(h) }
+
+func (h priorityHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h priorityHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *priorityHeap) Push(x interface{}) { *h = append(*h, x.(*priorityItem)) }
+
+func (h *priorityHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// InMemoryPriorityQueueStorage is a PriorityStorage which holds the requests in memory.
+type InMemoryPriorityQueueStorage struct {
+	// MaxSize defines the capacity of the queue.
+	// New requests are discarded if the queue size reaches MaxSize
+	MaxSize int
+	lock    *sync.Mutex
+	items   priorityHeap
+	seq     int64
+}
+
+// Init implements Storage.Init() function
+func (q *InMemoryPriorityQueueStorage) Init() error {
+	q.lock = &sync.Mutex{}
+	return nil
+}
+
+// AddRequest implements Storage.AddRequest() function
+func (q *InMemoryPriorityQueueStorage) AddRequest(r []byte) error {
+	return q.AddPriorityRequest(r, 0)
+}
+
+// AddPriorityRequest implements PriorityStorage.AddPriorityRequest() function
+func (q *InMemoryPriorityQueueStorage) AddPriorityRequest(r []byte, priority float64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	// Discard URLs if size limit exceeded
+	if q.MaxSize > 0 && len(q.items) >= q.MaxSize {
+		return colly.ErrQueueFull
+	}
+	q.seq++
+	heap.Push(&q.items, &priorityItem{priority: priority, seq: q.seq, request: r})
+	return nil
+}
+
+// GetRequest implements Storage.GetRequest() function
+func (q *InMemoryPriorityQueueStorage) GetRequest() ([]byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.items) == 0 {
+		return nil, nil
+	}
+	return heap.Pop(&q.items).(*priorityItem).request, nil
+}
+
+// QueueSize implements Storage.QueueSize() function
+func (q *InMemoryPriorityQueueStorage) QueueSize() (int, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.items), nil
+}
+
+// kinds of the records of a FilePriorityQueueStorage file
+const (
+	addRecord byte = 1
+	popRecord byte = 2
+)
+
+// addRecordHeaderSize is the size of the kind, the priority and
+// the length of the request which precede every added request
+const addRecordHeaderSize = 1 + 8 + recordHeaderSize
+
+// popRecordSize is the size of the kind and the offset of the taken request
+const popRecordSize = 1 + 8
+
+// FilePriorityQueueStorage is a PriorityStorage implementation which keeps
+// the requests in a single file, so pending requests survive process restarts.
+//
+// The file is a log of records: an added request with its priority, or the
+// offset of an added request which has been taken from the queue and
+// acknowledged. A request returned by GetAckRequest stays in the file until
+// it is acknowledged, so the requests which were being performed when the
+// process died are returned again by the next run. Only the priorities and
+// offsets of the waiting and taken requests are kept in memory. The file is
+// truncated once the queue becomes empty and rewritten without the
+// acknowledged requests once they occupy more than CompactThreshold bytes.
+type FilePriorityQueueStorage struct {
+	// Path is the location of the queue file. It is created by Init
+	// if it does not exist.
+	Path string
+	// MaxSize defines the capacity of the queue.
+	// New requests are discarded if the queue size reaches MaxSize
+	MaxSize int
+	// CompactThreshold is the number of bytes of acknowledged requests which
+	// triggers the rewrite of the queue file. The default is 32MB.
+	CompactThreshold int64
+	lock             *sync.Mutex
+	file             *os.File
+	items            priorityHeap
+	writeOffset      int64
+	liveBytes        int64
+	// taken are the returned requests which have not been acknowledged, by id
+	taken  map[int64]*priorityItem
+	lastId int64
+}
+
+// Init implements Storage.Init() function.
+// Init opens the queue file and restores the queue state from it.
+// A partially written trailing record is discarded.
+func (q *FilePriorityQueueStorage) Init() error {
+	q.lock = &sync.Mutex{}
+	q.taken = make(map[int64]*priorityItem)
+	if q.CompactThreshold <= 0 {
+		q.CompactThreshold = defaultCompactThreshold
+	}
+	f, err := os.OpenFile(q.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	q.file = f
+	if err := q.restore(); err != nil {
+		f.Close()
+		return err
+	}
+	return nil
+}
+
+func (q *FilePriorityQueueStorage) restore() error {
+	stat, err := q.file.Stat()
+	if err != nil {
+		return err
+	}
+	waiting := make(map[int64]*priorityItem)
+	var header [addRecordHeaderSize]byte
+	offset := int64(0)
+	for offset < stat.Size() {
+		if _, err := q.file.ReadAt(header[:1], offset); err != nil {
+			return err
+		}
+		var next int64
+		switch header[0] {
+		case addRecord:
+			if offset+addRecordHeaderSize > stat.Size() {
+				break
+			}
+			if _, err := q.file.ReadAt(header[:], offset); err != nil {
+				return err
+			}
+			item := decodeAddRecordHeader(header[:], offset)
+			next = offset + addRecordHeaderSize + int64(item.length)
+			if next <= stat.Size() {
+				waiting[offset] = item
+			}
+		case popRecord:
+			next = offset + popRecordSize
+			if next <= stat.Size() {
+				if _, err := q.file.ReadAt(header[:popRecordSize], offset); err != nil {
+					return err
+				}
+				delete(waiting, int64(binary.LittleEndian.Uint64(header[1:popRecordSize])))
+			}
+		default:
+			return ErrCorruptedQueueFile
+		}
+		if next == 0 || next > stat.Size() {
+			break
+		}
+		offset = next
+	}
+	q.writeOffset = offset
+	if offset != stat.Size() {
+		// drop the record which was being written when the process died
+		if err := q.file.Truncate(offset); err != nil {
+			return err
+		}
+	}
+
+	q.items = make(priorityHeap, 0, len(waiting))
+	q.liveBytes = 0
+	for _, item := range waiting {
+		q.items = append(q.items, item)
+		q.liveBytes += addRecordHeaderSize + int64(item.length)
+	}
+	heap.Init(&q.items)
+	if len(q.items) == 0 {
+		return q.reset()
+	}
+	return nil
+}
+
+func decodeAddRecordHeader(header []byte, offset int64) *priorityItem {
+	return &priorityItem{
+		priority: math.Float64frombits(binary.LittleEndian.Uint64(header[1:9])),
+		seq:      offset,
+		offset:   offset,
+		length:   int(binary.LittleEndian.Uint32(header[9:addRecordHeaderSize])),
+	}
+}
+
+// reset truncates the queue file to an empty queue
+func (q *FilePriorityQueueStorage) reset() error {
+	q.writeOffset = 0
+	q.liveBytes = 0
+	return q.file.Truncate(0)
+}
+
+// AddRequest implements Storage.AddRequest() function
+func (q *FilePriorityQueueStorage) AddRequest(r []byte) error {
+	return q.AddPriorityRequest(r, 0)
+}
+
+// AddPriorityRequest implements PriorityStorage.AddPriorityRequest() function
+func (q *FilePriorityQueueStorage) AddPriorityRequest(r []byte, priority float64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	// Discard URLs if size limit exceeded
+	if q.MaxSize > 0 && len(q.items) >= q.MaxSize {
+		return colly.ErrQueueFull
+	}
+	record := make([]byte, addRecordHeaderSize+len(r))
+	record[0] = addRecord
+	binary.LittleEndian.PutUint64(record[1:9], math.Float64bits(priority))
+	binary.LittleEndian.PutUint32(record[9:addRecordHeaderSize], uint32(len(r)))
+	copy(record[addRecordHeaderSize:], r)
+	if _, err := q.file.WriteAt(record, q.writeOffset); err != nil {
+		return err
+	}
+	heap.Push(&q.items, &priorityItem{
+		priority: priority,
+		seq:      q.writeOffset,
+		offset:   q.writeOffset,
+		length:   len(r),
+	})
+	q.writeOffset += int64(len(record))
+	q.liveBytes += int64(len(record))
+	return nil
+}
+
+// GetRequest implements Storage.GetRequest() function.
+// The request is acknowledged at once.
+func (q *FilePriorityQueueStorage) GetRequest() ([]byte, error) {
+	id, r, err := q.GetAckRequest()
+	if err != nil || r == nil {
+		return r, err
+	}
+	return r, q.Ack(id)
+}
+
+// GetAckRequest implements AckStorage.GetAckRequest() function
+func (q *FilePriorityQueueStorage) GetAckRequest() (int64, []byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.items) == 0 {
+		return 0, nil, nil
+	}
+	item := q.items[0]
+	r := make([]byte, item.length)
+	if _, err := q.file.ReadAt(r, item.offset+addRecordHeaderSize); err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	heap.Pop(&q.items)
+	q.lastId++
+	q.taken[q.lastId] = item
+	return q.lastId, r, nil
+}
+
+// Ack implements AckStorage.Ack() function
+func (q *FilePriorityQueueStorage) Ack(id int64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	item, taken := q.taken[id]
+	if !taken {
+		return nil
+	}
+	delete(q.taken, id)
+	q.liveBytes -= addRecordHeaderSize + int64(item.length)
+	if len(q.items) == 0 && len(q.taken) == 0 {
+		return q.reset()
+	}
+
+	var record [popRecordSize]byte
+	record[0] = popRecord
+	binary.LittleEndian.PutUint64(record[1:], uint64(item.offset))
+	if _, err := q.file.WriteAt(record[:], q.writeOffset); err != nil {
+		return err
+	}
+	q.writeOffset += popRecordSize
+	if q.writeOffset-q.liveBytes >= q.CompactThreshold && 2*q.liveBytes <= q.writeOffset {
+		return q.compact()
+	}
+	return nil
+}
+
+// compact rewrites the queue file with the waiting and taken requests only.
+// The new file replaces the old one atomically, so an interrupted
+// compaction leaves the previous file intact.
+func (q *FilePriorityQueueStorage) compact() error {
+	// keep the order of addition, which breaks ties between priorities
+	items := make([]*priorityItem, len(q.items), len(q.items)+len(q.taken))
+	copy(items, q.items)
+	for _, item := range q.taken {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].offset < items[j].offset })
+
+	tmpPath := q.Path + "~"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	offsets := make([]int64, len(items))
+	var offset int64
+	for i, item := range items {
+		size := addRecordHeaderSize + int64(item.length)
+		_, err = io.Copy(tmp, io.NewSectionReader(q.file, item.offset, size))
+		if err != nil {
+			break
+		}
+		offsets[i] = offset
+		offset += size
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, q.Path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	q.file.Close()
+	q.file = tmp
+	for i, item := range items {
+		item.offset = offsets[i]
+		item.seq = offsets[i]
+	}
+	// the heap order does not change, seqs keep their relative order
+	q.writeOffset = offset
+	q.liveBytes = offset
+	return nil
+}
+
+// QueueSize implements Storage.QueueSize() function
+func (q *FilePriorityQueueStorage) QueueSize() (int, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.items), nil
+}
+
+// Close closes the queue file
+func (q *FilePriorityQueueStorage) Close() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.file == nil {
+		return nil
+	}
+	err := q.file.Close()
+	q.file = nil
+	return err
+}
@@ -2,15 +2,25 @@ package queue
 
 import (
 	"net/url"
+	"strconv"
 	"sync"
+	"time"
 
 	whatwgUrl "github.com/nlnwa/whatwg-url/url"
 
-	"github.com/gocolly/colly/v2"
+	"colly"
 )
 
 const stop = true
 
+// retryCtxKey marks the requests added with RetryAfter, they are performed
+// even if the collector has visited their urls
+const retryCtxKey = "queue.retry"
+
+// priorityCtxKey holds the priority of a request added to a PriorityStorage,
+// so a request which is put back keeps its place
+const priorityCtxKey = "queue.priority"
+
 var urlParser = whatwgUrl.NewParser(whatwgUrl.WithPercentEncodeSinglePercentSign())
 
 // Storage is the interface of the queue's storage backend
@@ -27,6 +37,19 @@ type Storage interface {
 	QueueSize() (int, error)
 }
 
+// AckStorage is a Storage which keeps the requests it returns until they
+// are acknowledged, so a persistent storage returns the requests which were
+// being performed when the process died again after a restart. The queue
+// acknowledges a request once the collector has performed it.
+type AckStorage interface {
+	Storage
+	// GetAckRequest pops the next request like GetRequest
+	// and returns the id to acknowledge it with
+	GetAckRequest() (int64, []byte, error)
+	// Ack drops the request with the id for good
+	Ack(int64) error
+}
+
 // Queue is a request queue which uses a Collector to consume
 // requests in multiple threads
 type Queue struct {
@@ -34,8 +57,25 @@ type Queue struct {
 	Threads int
 	storage Storage
 	wake    chan struct{}
-	mut     sync.Mutex // guards wake and running
+	mut     sync.Mutex // guards wake, running and delayed
 	running bool
+	// delayed are the requests added with RetryAfter which wait for their time
+	delayed map[*delayedRequest]struct{}
+}
+
+// takenRequest is a request loaded from the storage. A request of an
+// AckStorage is acknowledged with id once it is done.
+type takenRequest struct {
+	request *colly.Request
+	id      int64
+}
+
+// delayedRequest is a serialized request which is added to the storage
+// when its timer fires
+type delayedRequest struct {
+	data     []byte
+	priority float64
+	timer    *time.Timer
 }
 
 // InMemoryQueueStorage is the default implementation of the Storage interface.
@@ -68,6 +108,7 @@ func New(threads int, s Storage) (*Queue, error) {
 		Threads: threads,
 		storage: s,
 		running: true,
+		delayed: make(map[*delayedRequest]struct{}),
 	}, nil
 }
 
@@ -100,28 +141,122 @@ func (q *Queue) AddURL(URL string) error {
 
 // AddRequest adds a new Request to the queue
 func (q *Queue) AddRequest(r *colly.Request) error {
-	q.mut.Lock()
-	waken := q.wake != nil
-	q.mut.Unlock()
-	if !waken {
-		return q.storeRequest(r)
+	d, err := r.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := q.storage.AddRequest(d); err != nil {
+		return err
+	}
+	q.notify()
+	return nil
+}
+
+// AddPriorityRequest adds a new Request to the queue with the given priority.
+// The priority is ignored if the storage is not a PriorityStorage.
+func (q *Queue) AddPriorityRequest(r *colly.Request, priority float64) error {
+	s, ok := q.storage.(PriorityStorage)
+	if !ok {
+		return q.AddRequest(r)
 	}
-	err := q.storeRequest(r)
+	setPriority(r, priority)
+	d, err := r.Marshal()
 	if err != nil {
 		return err
 	}
-	q.wake <- struct{}{}
+	if err := s.AddPriorityRequest(d, priority); err != nil {
+		return err
+	}
+	q.notify()
 	return nil
 }
 
-func (q *Queue) storeRequest(r *colly.Request) error {
+// RetryAfter adds r, a request which has failed, to the queue once delay
+// has passed. No consumer thread waits for it meanwhile, and Run does not
+// return while it waits. A stopped queue adds it at once, so a persistent
+// storage keeps it for the next run. The request is performed even though
+// the collector has visited its url. The priority is ignored if the storage
+// is not a PriorityStorage.
+func (q *Queue) RetryAfter(r *colly.Request, priority float64, delay time.Duration) error {
+	if r.Ctx == nil {
+		r.Ctx = colly.NewContext()
+	}
+	r.Ctx.Put(retryCtxKey, "1")
+	setPriority(r, priority)
 	d, err := r.Marshal()
 	if err != nil {
 		return err
 	}
+
+	q.mut.Lock()
+	if !q.running {
+		q.mut.Unlock()
+		return q.add(d, priority)
+	}
+	item := &delayedRequest{data: d, priority: priority}
+	q.delayed[item] = struct{}{}
+	item.timer = time.AfterFunc(delay, func() { q.release(item) })
+	q.mut.Unlock()
+	return nil
+}
+
+// release adds a delayed request to the storage unless it has been added already
+func (q *Queue) release(item *delayedRequest) {
+	q.mut.Lock()
+	_, waiting := q.delayed[item]
+	delete(q.delayed, item)
+	q.mut.Unlock()
+	if !waiting {
+		return
+	}
+	// the storage may be full, there is no caller to return the error to
+	q.add(item.data, item.priority)
+	q.notify()
+}
+
+// releaseDelayed adds every delayed request to the storage at once
+func (q *Queue) releaseDelayed() {
+	q.mut.Lock()
+	items := q.delayed
+	q.delayed = make(map[*delayedRequest]struct{})
+	q.mut.Unlock()
+	for item := range items {
+		item.timer.Stop()
+		q.add(item.data, item.priority)
+	}
+}
+
+// delayedCount returns the number of the delayed requests
+func (q *Queue) delayedCount() int {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	return len(q.delayed)
+}
+
+func (q *Queue) add(d []byte, priority float64) error {
+	if s, ok := q.storage.(PriorityStorage); ok {
+		return s.AddPriorityRequest(d, priority)
+	}
 	return q.storage.AddRequest(d)
 }
 
+// notify wakes the running loop up after a request has been added
+func (q *Queue) notify() {
+	q.mut.Lock()
+	wake := q.wake
+	q.mut.Unlock()
+	if wake == nil {
+		return
+	}
+	// wake is buffered, so a pending signal is enough for the loop to
+	// notice the new request. Never block here: requests may be added
+	// from collector callbacks while the loop is already shutting down.
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
 // Size returns the size of the queue
 func (q *Queue) Size() (int, error) {
 	return q.storage.QueueSize()
@@ -136,44 +271,60 @@ func (q *Queue) Run(c *colly.Collector) error {
 		q.mut.Unlock()
 		panic("cannot call duplicate Queue.Run")
 	}
-	q.wake = make(chan struct{})
+	q.wake = make(chan struct{}, 1)
 	q.running = true
 	q.mut.Unlock()
 
-	requestc := make(chan *colly.Request)
+	requestc := make(chan *takenRequest)
 	complete, errc := make(chan struct{}), make(chan error, 1)
 	for i := 0; i < q.Threads; i++ {
-		go independentRunner(requestc, complete)
+		go q.independentRunner(requestc, complete)
 	}
 	go q.loop(c, requestc, complete, errc)
 	defer close(requestc)
 	return <-errc
 }
 
-// Stop will stop the running queue
+// Stop will stop the running queue. No new requests are started, Run
+// returns once the requests which are being performed are done.
 func (q *Queue) Stop() {
 	q.mut.Lock()
 	q.running = false
 	q.mut.Unlock()
+	q.notify()
 }
 
-func (q *Queue) loop(c *colly.Collector, requestc chan<- *colly.Request, complete <-chan struct{}, errc chan<- error) {
+func (q *Queue) isRunning() bool {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	return q.running
+}
+
+func (q *Queue) loop(c *colly.Collector, requestc chan<- *takenRequest, complete <-chan struct{}, errc chan<- error) {
 	var active int
 	for {
+		if !q.isRunning() {
+			q.releaseDelayed()
+			waitActive(complete, active)
+			errc <- nil
+			break
+		}
 		size, err := q.storage.QueueSize()
 		if err != nil {
+			waitActive(complete, active)
 			errc <- err
 			break
 		}
-		if size == 0 && active == 0 || !q.running {
+		if size == 0 && active == 0 && q.delayedCount() == 0 {
 			// Terminate when
 			//   1. No active requests
 			//   2. Empty queue
+			//   3. No delayed requests
 			errc <- nil
 			break
 		}
 		sent := requestc
-		var req *colly.Request
+		var req *takenRequest
 		if size > 0 {
 			req, err = q.loadRequest(c)
 			if err != nil {
@@ -194,6 +345,12 @@ func (q *Queue) loop(c *colly.Collector, requestc chan<- *colly.Request, complet
 				if sent == nil {
 					break Sent
 				}
+				if !q.isRunning() {
+					// the loaded request stays in the storage
+					// for the next run
+					q.putBack(req)
+					break Sent
+				}
 			case <-complete:
 				active--
 				if sent == nil && active == 0 {
@@ -204,21 +361,84 @@ func (q *Queue) loop(c *colly.Collector, requestc chan<- *colly.Request, complet
 	}
 }
 
-func independentRunner(requestc <-chan *colly.Request, complete chan<- struct{}) {
-	for req := range requestc {
-		req.Do()
+// waitActive waits until the active requests are done, so their callbacks
+// are not cut off when Run returns
+func waitActive(complete <-chan struct{}, active int) {
+	for ; active > 0; active-- {
+		<-complete
+	}
+}
+
+// putBack returns a request which has been loaded but not performed
+func (q *Queue) putBack(req *takenRequest) {
+	if d, err := req.request.Marshal(); err == nil {
+		q.add(d, requestPriority(req.request))
+	}
+	q.ack(req)
+}
+
+// setPriority keeps priority in the context of r
+func setPriority(r *colly.Request, priority float64) {
+	if r.Ctx == nil {
+		r.Ctx = colly.NewContext()
+	}
+	r.Ctx.Put(priorityCtxKey, strconv.FormatFloat(priority, 'g', -1, 64))
+}
+
+// requestPriority returns the priority r was added with, 0 if it has none
+func requestPriority(r *colly.Request) float64 {
+	if r.Ctx == nil {
+		return 0
+	}
+	priority, _ := strconv.ParseFloat(r.Ctx.Get(priorityCtxKey), 64)
+	return priority
+}
+
+// ack drops a request of an AckStorage which is done
+func (q *Queue) ack(req *takenRequest) {
+	if s, ok := q.storage.(AckStorage); ok {
+		// there is no caller to return the error to, the request
+		// is performed again after a restart at worst
+		s.Ack(req.id)
+	}
+}
+
+func (q *Queue) independentRunner(requestc <-chan *takenRequest, complete chan<- struct{}) {
+	for taken := range requestc {
+		req := taken.request
+		if req.Ctx.Get(retryCtxKey) != "" {
+			req.Ctx.Put(retryCtxKey, "")
+			req.Retry()
+		} else {
+			req.Do()
+		}
+		q.ack(taken)
 		complete <- struct{}{}
 	}
 }
 
-func (q *Queue) loadRequest(c *colly.Collector) (*colly.Request, error) {
-	buf, err := q.storage.GetRequest()
+func (q *Queue) loadRequest(c *colly.Collector) (*takenRequest, error) {
+	var id int64
+	var buf []byte
+	var err error
+	if s, ok := q.storage.(AckStorage); ok {
+		id, buf, err = s.GetAckRequest()
+	} else {
+		buf, err = q.storage.GetRequest()
+	}
 	if err != nil {
 		return nil, err
 	}
+	taken := &takenRequest{id: id}
 	copied := make([]byte, len(buf))
 	copy(copied, buf)
-	return c.UnmarshalRequest(copied)
+	taken.request, err = c.UnmarshalRequest(copied)
+	if err != nil {
+		// a request which can not be read is dropped for good
+		q.ack(taken)
+		return nil, err
+	}
+	return taken, nil
 }
 
 // Init implements Storage.Init() function
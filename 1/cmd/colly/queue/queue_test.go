@@ -1,15 +1,19 @@
 package queue
 
 import (
+	"fmt"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/gocolly/colly/v2"
+	"colly"
 )
 
 func TestQueue(t *testing.T) {
@@ -75,6 +79,473 @@ func TestQueue(t *testing.T) {
 	}
 }
 
+func TestQueueStopWaitsForActiveRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(serverHandler))
+	defer server.Close()
+
+	const total = 20
+	storage := &InMemoryQueueStorage{MaxSize: 100}
+	q, err := New(4, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < total; i++ {
+		q.AddURL(fmt.Sprintf("%s/delay?t=%s&i=%d", server.URL, 50*time.Millisecond, i))
+	}
+
+	var requests, responses uint32
+	var stopOnce sync.Once
+	c := colly.NewCollector(colly.AllowURLRevisit())
+	c.OnRequest(func(req *colly.Request) {
+		atomic.AddUint32(&requests, 1)
+		stopOnce.Do(q.Stop)
+	})
+	c.OnResponse(func(resp *colly.Response) {
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddUint32(&responses, 1)
+	})
+
+	if err := q.Run(c); err != nil {
+		t.Fatal(err)
+	}
+
+	started, done := atomic.LoadUint32(&requests), atomic.LoadUint32(&responses)
+	if started != done {
+		t.Fatalf("Run returned with %d of %d requests still active", started-done, started)
+	}
+	if started >= total {
+		t.Fatalf("the stopped queue has started all %d requests", started)
+	}
+	if size, _ := q.Size(); int(started)+size != total {
+		t.Fatalf("requests lost: %d started, %d left in the queue", started, size)
+	}
+}
+
+func TestQueueRetryAfter(t *testing.T) {
+	var flakyRequests uint32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/flaky" && atomic.AddUint32(&flakyRequests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	q, err := New(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.AddURL(server.URL + "/flaky")
+	for i := 0; i < 5; i++ {
+		q.AddURL(fmt.Sprintf("%s/page?i=%d", server.URL, i))
+	}
+
+	var mu sync.Mutex
+	var order []string
+	c := colly.NewCollector()
+	c.OnResponse(func(resp *colly.Response) {
+		mu.Lock()
+		order = append(order, resp.Request.URL.Path)
+		mu.Unlock()
+	})
+	c.OnError(func(resp *colly.Response, err error) {
+		if err := q.RetryAfter(resp.Request, 0, 100*time.Millisecond); err != nil {
+			t.Error(err)
+		}
+	})
+
+	start := time.Now()
+	if err := q.Run(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(start) < 100*time.Millisecond {
+		t.Fatal("Run returned before the delayed retry")
+	}
+	// the only thread has fetched the other pages during the backoff
+	if len(order) != 6 || order[5] != "/flaky" {
+		t.Fatalf("wrong order of responses: %v", order)
+	}
+}
+
+func TestQueueStopKeepsDelayedRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	storage := &InMemoryQueueStorage{MaxSize: 10}
+	q, err := New(2, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.AddURL(server.URL + "/down")
+
+	c := colly.NewCollector()
+	c.OnError(func(resp *colly.Response, err error) {
+		q.RetryAfter(resp.Request, 0, time.Hour)
+		q.Stop()
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- q.Run(c) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run waits for the delayed request after Stop")
+	}
+
+	if size, _ := q.Size(); size != 1 {
+		t.Fatalf("the delayed request is not kept in the storage, size %d", size)
+	}
+}
+
+func TestFileQueueStorageRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	storage := &FileQueueStorage{Path: path}
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := storage.AddRequest([]byte(fmt.Sprintf("request %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := storage.GetRequest(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a record which was cut by a crash
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{42, 0, 0, 0, 'x'})
+	f.Close()
+
+	storage = &FileQueueStorage{Path: path}
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if size, _ := storage.QueueSize(); size != 6 {
+		t.Fatalf("wrong queue size after restore: %d", size)
+	}
+	for i := 4; i < 10; i++ {
+		r, err := storage.GetRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("request %d", i); string(r) != expected {
+			t.Fatalf("wrong request: expected %q, got %q", expected, r)
+		}
+	}
+	if r, _ := storage.GetRequest(); r != nil {
+		t.Fatalf("queue must be empty, got %q", r)
+	}
+}
+
+func TestFileQueueStorageCompact(t *testing.T) {
+	storage := &FileQueueStorage{Path: filepath.Join(t.TempDir(), "queue"), CompactThreshold: 64}
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	for i := 0; i < 100; i++ {
+		storage.AddRequest([]byte(fmt.Sprintf("request %d", i)))
+	}
+	for i := 0; i < 100; i++ {
+		r, err := storage.GetRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("request %d", i); string(r) != expected {
+			t.Fatalf("wrong request: expected %q, got %q", expected, r)
+		}
+		if i%10 == 0 {
+			storage.AddRequest([]byte(fmt.Sprintf("request %d", 100+i/10)))
+		}
+	}
+	if size, _ := storage.QueueSize(); size != 10 {
+		t.Fatalf("wrong queue size: %d", size)
+	}
+}
+
+func TestAckStorageRestore(t *testing.T) {
+	tests := []struct {
+		name string
+		open func(path string) AckStorage
+		// close closes the file without persisting anything, as a crash does
+		close func(s AckStorage)
+		want  []int
+	}{
+		{"file", func(path string) AckStorage {
+			return &FileQueueStorage{Path: path}
+		}, func(s AckStorage) {
+			s.(*FileQueueStorage).file.Close()
+		}, []int{1, 2, 3, 4}},
+		{"file priority", func(path string) AckStorage {
+			return &FilePriorityQueueStorage{Path: path}
+		}, func(s AckStorage) {
+			s.(*FilePriorityQueueStorage).file.Close()
+		}, []int{1, 3, 4}},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "queue")
+		storage := test.open(path)
+		if err := storage.Init(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			storage.AddRequest([]byte(fmt.Sprintf("request %d", i)))
+		}
+		var ids []int64
+		for i := 0; i < 3; i++ {
+			id, _, err := storage.GetAckRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		// request 1 is still being performed
+		storage.Ack(ids[0])
+		storage.Ack(ids[2])
+		test.close(storage)
+
+		storage = test.open(path)
+		if err := storage.Init(); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for {
+			r, err := storage.GetRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r == nil {
+				break
+			}
+			got = append(got, string(r))
+		}
+		var want []string
+		for _, i := range test.want {
+			want = append(want, fmt.Sprintf("request %d", i))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: got %q after restart, want %q", test.name, got, want)
+		}
+	}
+}
+
+func TestQueueAcksPerformedRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(serverHandler))
+	defer server.Close()
+
+	storage := &FileQueueStorage{Path: filepath.Join(t.TempDir(), "queue")}
+	q, err := New(2, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	for i := 0; i < 10; i++ {
+		q.AddURL(fmt.Sprintf("%s/delay?t=%s&i=%d", server.URL, time.Millisecond, i))
+	}
+
+	var early uint32
+	c := colly.NewCollector(colly.AllowURLRevisit())
+	c.OnResponse(func(resp *colly.Response) {
+		storage.lock.Lock()
+		if len(storage.taken) == 0 {
+			atomic.AddUint32(&early, 1)
+		}
+		storage.lock.Unlock()
+	})
+	if err := q.Run(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if early > 0 {
+		t.Errorf("%d requests are acknowledged before they are done", early)
+	}
+	if len(storage.taken) != 0 {
+		t.Errorf("%d requests are not acknowledged", len(storage.taken))
+	}
+	if stat, err := os.Stat(storage.Path); err != nil || stat.Size() != fileHeaderSize {
+		t.Errorf("the queue file is not emptied: %v", err)
+	}
+}
+
+func TestQueuePutBackKeepsPriority(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage PriorityStorage
+	}{
+		{"in memory", &InMemoryPriorityQueueStorage{}},
+		{"file", &FilePriorityQueueStorage{Path: filepath.Join(t.TempDir(), "queue")}},
+	}
+
+	for _, test := range tests {
+		q, err := New(1, test.storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := colly.NewCollector()
+		for i, priority := range []float64{2, 1} {
+			u, _ := url.Parse(fmt.Sprintf("http://example.com/%d", i))
+			r := &colly.Request{URL: u, Method: "GET"}
+			if err := q.AddPriorityRequest(r, priority); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the first request is loaded when the queue is stopped
+		taken, err := q.loadRequest(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.putBack(taken)
+
+		var got []string
+		for size, _ := q.Size(); size > 0; size, _ = q.Size() {
+			r, err := q.loadRequest(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, r.request.URL.Path)
+			q.ack(r)
+		}
+		if want := []string{"/0", "/1"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: got %v after putting back, want %v", test.name, got, want)
+		}
+	}
+}
+
+func TestInMemoryPriorityQueueStorage(t *testing.T) {
+	storage := &InMemoryPriorityQueueStorage{}
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+	priorities := []float64{0.5, 2, 0.5, 1, 0}
+	for i, priority := range priorities {
+		storage.AddPriorityRequest([]byte(fmt.Sprintf("request %d", i)), priority)
+	}
+	for _, expected := range []string{"request 1", "request 3", "request 0", "request 2", "request 4"} {
+		r, err := storage.GetRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(r) != expected {
+			t.Fatalf("wrong request: expected %q, got %q", expected, r)
+		}
+	}
+	if r, _ := storage.GetRequest(); r != nil {
+		t.Fatalf("queue must be empty, got %q", r)
+	}
+}
+
+func TestFilePriorityQueueStorageRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	storage := &FilePriorityQueueStorage{Path: path}
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := storage.AddPriorityRequest([]byte(fmt.Sprintf("request %d", i)), float64(i%3)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// takes 2, 5, 8 and 1
+	for i := 0; i < 4; i++ {
+		if _, err := storage.GetRequest(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a record which was cut by a crash
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{addRecord, 0, 0, 0})
+	f.Close()
+
+	storage = &FilePriorityQueueStorage{Path: path}
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if size, _ := storage.QueueSize(); size != 6 {
+		t.Fatalf("wrong queue size after restore: %d", size)
+	}
+	for _, i := range []int{4, 7, 0, 3, 6, 9} {
+		r, err := storage.GetRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("request %d", i); string(r) != expected {
+			t.Fatalf("wrong request: expected %q, got %q", expected, r)
+		}
+	}
+	if r, _ := storage.GetRequest(); r != nil {
+		t.Fatalf("queue must be empty, got %q", r)
+	}
+}
+
+func TestFilePriorityQueueStorageCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	storage := &FilePriorityQueueStorage{Path: path, CompactThreshold: 64}
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		storage.AddPriorityRequest([]byte(fmt.Sprintf("request %d", i)), float64(-i))
+	}
+	for i := 0; i < 90; i++ {
+		r, err := storage.GetRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("request %d", i); string(r) != expected {
+			t.Fatalf("wrong request: expected %q, got %q", expected, r)
+		}
+	}
+	storage.AddPriorityRequest([]byte("request 100"), -95.5)
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := os.Stat(path); err != nil || stat.Size() > 1024 {
+		t.Fatalf("queue file should have been compacted: %v %v", stat.Size(), err)
+	}
+
+	storage = &FilePriorityQueueStorage{Path: path}
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	for _, i := range []int{90, 91, 92, 93, 94, 95, 100, 96, 97, 98, 99} {
+		r, err := storage.GetRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("request %d", i); string(r) != expected {
+			t.Fatalf("wrong request: expected %q, got %q", expected, r)
+		}
+	}
+}
+
 func serverHandler(w http.ResponseWriter, req *http.Request) {
 	if !serverRoute(w, req) {
 		shutdown(w)
@@ -0,0 +1,101 @@
+package colly
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCollectorReplay(t *testing.T) {
+	c := NewCollector()
+
+	var requested, title, value string
+	c.OnRequest(func(r *Request) {
+		requested = r.URL.String()
+		r.Ctx.Put("key", "value")
+	})
+	c.OnHTML("title", func(e *HTMLElement) {
+		title = e.Text
+		value = e.Request.Ctx.Get("key")
+	})
+	c.OnError(func(r *Response, err error) {
+		t.Errorf("unexpected error %v", err)
+	})
+
+	headers := http.Header{"Content-Type": []string{"text/html; charset=utf-8"}}
+	err := c.Replay("http://example.com/page", 2, nil, &Response{
+		StatusCode: 200,
+		Headers:    &headers,
+		Body:       []byte("<html><head><title>Stored</title></head></html>"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requested != "http://example.com/page" {
+		t.Errorf("OnRequest should get the replayed url, got %q", requested)
+	}
+	if title != "Stored" {
+		t.Errorf("OnHTML should get the stored body, got title %q", title)
+	}
+	if value != "value" {
+		t.Error("context set in OnRequest should be passed to OnHTML")
+	}
+}
+
+func TestCollectorReplayError(t *testing.T) {
+	c := NewCollector()
+
+	htmlCalled := false
+	c.OnHTML("html", func(e *HTMLElement) {
+		htmlCalled = true
+	})
+	var errorStatus int
+	c.OnError(func(r *Response, err error) {
+		errorStatus = r.StatusCode
+	})
+
+	err := c.Replay("http://example.com/missing", 0, nil, &Response{StatusCode: 404, Body: []byte("<html></html>")})
+	if err == nil {
+		t.Error("replay of a 404 response should fail")
+	}
+	if errorStatus != 404 || htmlCalled {
+		t.Errorf("404 response should go to OnError only, got status %d, OnHTML called %v", errorStatus, htmlCalled)
+	}
+}
+
+func TestLoadCachedResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><title>Cached</title></html>"))
+	}))
+	defer ts.Close()
+
+	cacheDir, err := ioutil.TempDir("", "colly-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	if _, err := LoadCachedResponse(cacheDir, ts.URL+"/"); !os.IsNotExist(err) {
+		t.Errorf("missing response should fail with a not exist error, got %v", err)
+	}
+
+	c := NewCollector(CacheDir(cacheDir))
+	if err := c.Visit(ts.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := LoadCachedResponse(cacheDir, ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || string(resp.Body) != "<html><title>Cached</title></html>" {
+		t.Errorf("unexpected cached response %d %q", resp.StatusCode, resp.Body)
+	}
+	if resp.Headers.Get("Content-Type") != "text/html" {
+		t.Errorf("cached response should keep its headers, got %v", resp.Headers)
+	}
+}
@@ -23,8 +23,8 @@ import (
 	"os"
 	"strings"
 
-	"github.com/saintfish/chardet"
 	"golang.org/x/net/html/charset"
+	xunicode "golang.org/x/text/encoding/unicode"
 )
 
 // Response is the representation of a HTTP response made by a Collector
@@ -85,25 +85,24 @@ func (r *Response) fixCharset(detectCharset bool, defaultEncoding string) error
 		return nil
 	}
 
-	if !strings.Contains(contentType, "charset") {
-		if !detectCharset {
-			return nil
-		}
-		d := chardet.NewTextDetector()
-		r, err := d.DetectBest(r.Body)
+	e, bom := resolveCharset(r.Body, contentType, detectCharset)
+	if e == nil {
+		return nil
+	}
+	body := r.Body[bom:]
+	if e != xunicode.UTF8 {
+		decoded, err := e.NewDecoder().Bytes(body)
 		if err != nil {
 			return err
 		}
-		contentType = "text/plain; charset=" + r.Charset
+		body = decoded
 	}
-	if strings.Contains(contentType, "utf-8") || strings.Contains(contentType, "utf8") {
-		return nil
-	}
-	tmpBody, err := encodeBytes(r.Body, contentType)
-	if err != nil {
-		return err
+	if detectCharset {
+		if repaired, ok := RepairMojibake(string(body)); ok {
+			body = []byte(repaired)
+		}
 	}
-	r.Body = tmpBody
+	r.Body = body
 	return nil
 }
 
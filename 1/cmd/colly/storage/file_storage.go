@@ -0,0 +1,374 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	visitedLogFileName   = "visited.log"
+	visitedIndexFileName = "visited.idx"
+	cookiesFileName      = "cookies.json"
+	requestIDSize        = 8
+)
+
+// defaultCompactThreshold is the default number of request IDs collected
+// in the visited log before it is merged into the index file
+const defaultCompactThreshold = 1 << 18
+
+// FileStorage is a durable storage backend of colly.
+// FileStorage keeps visited request IDs and cookies in Dir, so they
+// are available after the process restarts.
+//
+// Visited request IDs are appended to a log file and kept in memory until
+// CompactThreshold IDs are collected. Then the log is merged into a sorted
+// index file, which is searched on the disk, so the memory usage does not
+// grow with the number of visited requests. A partially written log entry
+// left by a crash is discarded on Init.
+//
+// FileStorage is safe for concurrent use.
+type FileStorage struct {
+	// Dir is the directory of the storage files.
+	// It is created by Init if it does not exist.
+	Dir string
+	// CompactThreshold is the number of request IDs kept in the
+	// visited log before they are merged into the index file.
+	// The default value is 262144.
+	CompactThreshold int
+	// SyncWrites makes every Visited and SetCookies call wait until the
+	// data reaches the disk. Without it a crash of the process loses
+	// nothing, but an operating system crash may lose the latest writes.
+	SyncWrites bool
+
+	lock       *sync.RWMutex
+	recent     map[uint64]struct{}
+	log        *os.File
+	index      *os.File
+	indexCount int64
+	cookies    map[string]string
+}
+
+// Init opens the storage files in Dir and restores the storage state.
+func (s *FileStorage) Init() error {
+	if s.lock == nil {
+		s.lock = &sync.RWMutex{}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.log != nil {
+		return nil
+	}
+	if s.CompactThreshold <= 0 {
+		s.CompactThreshold = defaultCompactThreshold
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	if err := s.openIndex(); err != nil {
+		return err
+	}
+	if err := s.openLog(); err != nil {
+		s.index.Close()
+		return err
+	}
+	if err := s.loadCookies(); err != nil {
+		s.closeFiles()
+		return err
+	}
+	if len(s.recent) >= s.CompactThreshold {
+		return s.compact()
+	}
+	return nil
+}
+
+func (s *FileStorage) openIndex() error {
+	f, err := os.OpenFile(filepath.Join(s.Dir, visitedIndexFileName), os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.index = f
+	s.indexCount = stat.Size() / requestIDSize
+	return nil
+}
+
+func (s *FileStorage) openLog() error {
+	f, err := os.OpenFile(filepath.Join(s.Dir, visitedLogFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	s.recent = make(map[uint64]struct{})
+	r := bufio.NewReader(f)
+	var buf [requestIDSize]byte
+	var size int64
+	for {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			f.Close()
+			return err
+		}
+		s.recent[binary.BigEndian.Uint64(buf[:])] = struct{}{}
+		size += requestIDSize
+	}
+	// drop the entry which was being written when the process died
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	s.log = f
+	return nil
+}
+
+func (s *FileStorage) loadCookies() error {
+	s.cookies = make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(s.Dir, cookiesFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.cookies)
+}
+
+// Visited implements Storage.Visited()
+func (s *FileStorage) Visited(requestID uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.recent[requestID]; ok {
+		return nil
+	}
+	var buf [requestIDSize]byte
+	binary.BigEndian.PutUint64(buf[:], requestID)
+	if _, err := s.log.Write(buf[:]); err != nil {
+		return err
+	}
+	if s.SyncWrites {
+		if err := s.log.Sync(); err != nil {
+			return err
+		}
+	}
+	s.recent[requestID] = struct{}{}
+	if len(s.recent) >= s.CompactThreshold {
+		return s.compact()
+	}
+	return nil
+}
+
+// IsVisited implements Storage.IsVisited()
+func (s *FileStorage) IsVisited(requestID uint64) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if _, ok := s.recent[requestID]; ok {
+		return true, nil
+	}
+	return s.indexContains(requestID)
+}
+
+// indexContains performs a binary search over the sorted index file
+func (s *FileStorage) indexContains(requestID uint64) (bool, error) {
+	var buf [requestIDSize]byte
+	lo, hi := int64(0), s.indexCount
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, err := s.index.ReadAt(buf[:], mid*requestIDSize); err != nil {
+			return false, err
+		}
+		id := binary.BigEndian.Uint64(buf[:])
+		switch {
+		case id == requestID:
+			return true, nil
+		case id < requestID:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// compact merges the visited log into the index file. The new index
+// replaces the old one atomically and the log is truncated only after
+// that, so a crash in between leaves duplicate, but no lost, entries.
+func (s *FileStorage) compact() error {
+	ids := make([]uint64, 0, len(s.recent))
+	for id := range s.recent {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	indexPath := filepath.Join(s.Dir, visitedIndexFileName)
+	tmp, err := os.Create(indexPath + "~")
+	if err != nil {
+		return err
+	}
+	count, err := mergeIndex(tmp, io.NewSectionReader(s.index, 0, s.indexCount*requestIDSize), ids)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(indexPath+"~", indexPath)
+	}
+	if err != nil {
+		os.Remove(indexPath + "~")
+		return err
+	}
+
+	s.index.Close()
+	if s.index, err = os.Open(indexPath); err != nil {
+		return err
+	}
+	s.indexCount = count
+	if err := s.log.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.recent = make(map[uint64]struct{})
+	return nil
+}
+
+// mergeIndex writes the union of the sorted index and the sorted ids to w
+// and returns the number of written ids
+func mergeIndex(w io.Writer, index io.Reader, ids []uint64) (int64, error) {
+	r := bufio.NewReader(index)
+	bw := bufio.NewWriter(w)
+	var count int64
+	var buf [requestIDSize]byte
+	last, hasLast := uint64(0), false
+	write := func(id uint64) error {
+		if hasLast && id == last {
+			return nil
+		}
+		binary.BigEndian.PutUint64(buf[:], id)
+		if _, err := bw.Write(buf[:]); err != nil {
+			return err
+		}
+		last, hasLast = id, true
+		count++
+		return nil
+	}
+
+	var rbuf [requestIDSize]byte
+	next := func() (uint64, bool, error) {
+		if _, err := io.ReadFull(r, rbuf[:]); err != nil {
+			if err == io.EOF {
+				return 0, false, nil
+			}
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(rbuf[:]), true, nil
+	}
+
+	cur, ok, err := next()
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		for ok && cur < id {
+			if err := write(cur); err != nil {
+				return 0, err
+			}
+			if cur, ok, err = next(); err != nil {
+				return 0, err
+			}
+		}
+		if err := write(id); err != nil {
+			return 0, err
+		}
+	}
+	for ok {
+		if err := write(cur); err != nil {
+			return 0, err
+		}
+		if cur, ok, err = next(); err != nil {
+			return 0, err
+		}
+	}
+	return count, bw.Flush()
+}
+
+// Cookies implements Storage.Cookies()
+func (s *FileStorage) Cookies(u *url.URL) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.cookies[u.Host]
+}
+
+// SetCookies implements Storage.SetCookies()
+func (s *FileStorage) SetCookies(u *url.URL, cookies string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cookies[u.Host] = cookies
+	// Storage.SetCookies can not report errors, a failed write leaves
+	// the previous cookies file in place
+	s.saveCookies()
+}
+
+func (s *FileStorage) saveCookies() error {
+	data, err := json.Marshal(s.cookies)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.Dir, cookiesFileName)
+	f, err := os.Create(path + "~")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil && s.SyncWrites {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + "~")
+		return err
+	}
+	return os.Rename(path+"~", path)
+}
+
+// Close implements Storage.Close()
+func (s *FileStorage) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.log == nil {
+		return nil
+	}
+	return s.closeFiles()
+}
+
+func (s *FileStorage) closeFiles() error {
+	err := s.log.Close()
+	if cerr := s.index.Close(); err == nil {
+		err = cerr
+	}
+	s.log = nil
+	s.index = nil
+	return err
+}
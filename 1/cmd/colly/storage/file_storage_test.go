@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestFileStorageVisited(t *testing.T) {
+	dir := t.TempDir()
+	s := &FileStorage{Dir: dir, CompactThreshold: 100}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// odd ids only, with some of them merged into the index file
+	for id := uint64(1); id < 1000; id += 2 {
+		if err := s.Visited(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = &FileStorage{Dir: dir, CompactThreshold: 100}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for id := uint64(0); id < 1000; id++ {
+		visited, err := s.IsVisited(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if visited != (id%2 == 1) {
+			t.Fatalf("wrong visited state of %d: %v", id, visited)
+		}
+	}
+}
+
+func TestFileStorageCookies(t *testing.T) {
+	dir := t.TempDir()
+	u, _ := url.Parse("http://example.com/page")
+	s := &FileStorage{Dir: dir}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s.SetCookies(u, "session=42")
+	s.Close()
+
+	s = &FileStorage{Dir: dir}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if cookies := s.Cookies(u); cookies != "session=42" {
+		t.Fatalf("cookies are not restored: %q", cookies)
+	}
+}
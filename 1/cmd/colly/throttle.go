@@ -0,0 +1,175 @@
+package colly
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// initialBackoff is the delay set after the first 429 or 503 response of a host
+	initialBackoff = time.Second
+	// backoffRecovery is the factor the backoff delay is multiplied by
+	// after every healthy response
+	backoffRecovery = 0.75
+	// minBackoff is the delay below which the backoff is dropped
+	minBackoff = 50 * time.Millisecond
+)
+
+// throttle keeps an adaptive delay between the requests to every host.
+// The delay of a host is the larger one of its robots.txt Crawl-delay and
+// a backoff delay. The backoff doubles on every 429 Too Many Requests and
+// 503 Service Unavailable response and shrinks slowly on healthy responses.
+// A Retry-After header postpones the next request to the host regardless
+// of the delay. No delay exceeds maxDelay.
+type throttle struct {
+	maxDelay time.Duration
+	lock     sync.Mutex
+	hosts    map[string]*hostThrottle
+}
+
+type hostThrottle struct {
+	crawlDelay time.Duration
+	backoff    time.Duration
+	// next is the earliest start of the next request
+	next time.Time
+}
+
+func newThrottle(maxDelay time.Duration) *throttle {
+	return &throttle{
+		maxDelay: maxDelay,
+		hosts:    make(map[string]*hostThrottle),
+	}
+}
+
+func (t *throttle) host(host string) *hostThrottle {
+	h, ok := t.hosts[host]
+	if !ok {
+		h = &hostThrottle{}
+		t.hosts[host] = h
+	}
+	return h
+}
+
+func (h *hostThrottle) delay() time.Duration {
+	if h.crawlDelay > h.backoff {
+		return h.crawlDelay
+	}
+	return h.backoff
+}
+
+// Delay returns the current delay between requests to host
+func (t *throttle) Delay(host string) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if h, ok := t.hosts[host]; ok {
+		return h.delay()
+	}
+	return 0
+}
+
+// Delays returns the hosts with a delay between their requests and the delays
+func (t *throttle) Delays() map[string]time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delays := make(map[string]time.Duration)
+	for host, h := range t.hosts {
+		if delay := h.delay(); delay > 0 {
+			delays[host] = delay
+		}
+	}
+	return delays
+}
+
+// SetCrawlDelay sets the Crawl-delay of host from its robots.txt
+func (t *throttle) SetCrawlDelay(host string, delay time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if delay > t.maxDelay {
+		delay = t.maxDelay
+	}
+	t.host(host).crawlDelay = delay
+}
+
+// Wait reserves the next request slot of host and blocks until it
+// starts or ctx is done
+func (t *throttle) Wait(ctx context.Context, host string) error {
+	t.lock.Lock()
+	h := t.host(host)
+	now := time.Now()
+	start := h.next
+	if start.Before(now) {
+		start = now
+	}
+	h.next = start.Add(h.delay())
+	t.lock.Unlock()
+
+	wait := start.Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Update adapts the delay of host to a response
+func (t *throttle) Update(host string, statusCode int, headers http.Header) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	h := t.host(host)
+	now := time.Now()
+
+	if statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable {
+		if h.backoff < initialBackoff {
+			h.backoff = initialBackoff
+		} else {
+			h.backoff *= 2
+		}
+		if h.backoff > t.maxDelay {
+			h.backoff = t.maxDelay
+		}
+		if next := now.Add(h.backoff); next.After(h.next) {
+			h.next = next
+		}
+	} else if statusCode < 500 && h.backoff > 0 {
+		h.backoff = time.Duration(float64(h.backoff) * backoffRecovery)
+		if h.backoff < minBackoff {
+			h.backoff = 0
+		}
+	}
+
+	if retryAfter, ok := parseRetryAfter(headers.Get("Retry-After"), now); ok {
+		if retryAfter > t.maxDelay {
+			retryAfter = t.maxDelay
+		}
+		if next := now.Add(retryAfter); next.After(h.next) {
+			h.next = next
+		}
+	}
+}
+
+// parseRetryAfter parses the delay-seconds and the HTTP-date
+// forms of the Retry-After header
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now), date.After(now)
+	}
+	return 0, false
+}
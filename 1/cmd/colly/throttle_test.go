@@ -0,0 +1,173 @@
+package colly
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThrottleBackoff(t *testing.T) {
+	th := newThrottle(5 * time.Second)
+	host := "example.com"
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, delay := range expected {
+		th.Update(host, http.StatusTooManyRequests, http.Header{})
+		if d := th.Delay(host); d != delay {
+			t.Errorf("delay after %d responses with status 429 should be %v, got %v", i+1, delay, d)
+		}
+	}
+
+	th.Update(host, http.StatusOK, http.Header{})
+	if d := th.Delay(host); d >= 5*time.Second || d == 0 {
+		t.Errorf("delay should decrease gradually after a healthy response, got %v", d)
+	}
+
+	for i := 0; i < 100; i++ {
+		th.Update(host, http.StatusOK, http.Header{})
+	}
+	if d := th.Delay(host); d != 0 {
+		t.Errorf("delay should drop to 0 after healthy responses, got %v", d)
+	}
+}
+
+func TestThrottleRetryAfter(t *testing.T) {
+	th := newThrottle(10 * time.Second)
+	host := "example.com"
+
+	th.Update(host, http.StatusOK, http.Header{"Retry-After": []string{"3"}})
+	wait := th.hosts[host].next.Sub(time.Now())
+	if wait < 2*time.Second || wait > 3*time.Second {
+		t.Errorf("next request should wait about 3s, got %v", wait)
+	}
+	if d := th.Delay(host); d != 0 {
+		t.Errorf("Retry-After should not change the delay, got %v", d)
+	}
+
+	th.Update(host, http.StatusServiceUnavailable, http.Header{"Retry-After": []string{"3600"}})
+	wait = th.hosts[host].next.Sub(time.Now())
+	if wait > 10*time.Second {
+		t.Errorf("Retry-After should be limited by the max delay, got %v", wait)
+	}
+}
+
+func TestThrottleDelays(t *testing.T) {
+	th := newThrottle(time.Minute)
+	th.SetCrawlDelay("slow.example.com", 5*time.Second)
+	th.Update("busy.example.com", http.StatusTooManyRequests, http.Header{})
+	th.Update("fast.example.com", http.StatusOK, http.Header{})
+
+	delays := th.Delays()
+	expected := map[string]time.Duration{"slow.example.com": 5 * time.Second, "busy.example.com": time.Second}
+	if len(delays) != len(expected) {
+		t.Errorf("expected the delays %v, got %v", expected, delays)
+	}
+	for host, delay := range expected {
+		if delays[host] != delay {
+			t.Errorf("delay of %s should be %v, got %v", host, delay, delays[host])
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{"Wed, 01 Jan 2020 12:00:30 GMT", 30 * time.Second, true},
+		{"Wed, 01 Jan 2020 11:00:00 GMT", 0, false},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+
+	for _, test := range tests {
+		delay, ok := parseRetryAfter(test.value, now)
+		if delay != test.delay && test.ok || ok != test.ok {
+			t.Errorf("Retry-After %q: expected %v %v, got %v %v", test.value, test.delay, test.ok, delay, ok)
+		}
+	}
+}
+
+func TestCollectorCrawlDelay(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\nCrawl-delay: 5\n"))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html></html>"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	c := NewCollector(AdaptiveThrottle(time.Minute))
+	c.IgnoreRobotsTxt = false
+	if err := c.Visit(ts.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(ts.URL)
+	if d := c.HostDelay(u.Host); d != 5*time.Second {
+		t.Errorf("delay should be the Crawl-delay of robots.txt, got %v", d)
+	}
+
+	if delays := c.HostDelays(); delays[u.Host] != 5*time.Second {
+		t.Errorf("host delays should contain the Crawl-delay, got %v", delays)
+	}
+
+	if d := NewCollector().HostDelay(u.Host); d != 0 {
+		t.Errorf("delay should be 0 without AdaptiveThrottle, got %v", d)
+	}
+}
+
+func TestCollectorHonorCrawlDelay(t *testing.T) {
+	var robotsRequests int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&robotsRequests, 1)
+		w.Write([]byte("User-agent: *\nDisallow: /\nCrawl-delay: 5\n"))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html></html>"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	tests := []struct {
+		name            string
+		ignoreRobotsTxt bool
+		honorCrawlDelay bool
+		blocked         bool
+		delay           time.Duration
+		robotsRequests  int32
+	}{
+		{"robots.txt", false, false, true, 5 * time.Second, 1},
+		{"crawl delay only", true, true, false, 5 * time.Second, 1},
+		{"nothing", true, false, false, 0, 0},
+	}
+
+	for _, test := range tests {
+		atomic.StoreInt32(&robotsRequests, 0)
+		c := NewCollector(AdaptiveThrottle(time.Minute))
+		c.IgnoreRobotsTxt = test.ignoreRobotsTxt
+		c.HonorCrawlDelay = test.honorCrawlDelay
+
+		err := c.Visit(ts.URL + "/")
+		if blocked := err == ErrRobotsTxtBlocked; blocked != test.blocked {
+			t.Errorf("%s: Visit() = %v", test.name, err)
+		}
+		if d := c.HostDelay(u.Host); d != test.delay {
+			t.Errorf("%s: delay %v, want %v", test.name, d, test.delay)
+		}
+		if n := atomic.LoadInt32(&robotsRequests); n != test.robotsRequests {
+			t.Errorf("%s: robots.txt requested %d times", test.name, n)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package crawler
+
+import (
+	"errors"
+	"log"
+	neturl "net/url"
+	"strings"
+
+	"colly"
+)
+
+// DefaultTrackingParams are the query parameters removed from canonical urls.
+// A trailing * matches any parameter with the given prefix.
+var DefaultTrackingParams = []string{"utm_*", "gclid", "yclid", "fbclid", "_openstat", "mc_cid", "mc_eid"}
+
+var errNotHttpUrl = errors.New("not an http(s) url")
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// TrackingParams replaces DefaultTrackingParams with params.
+func TrackingParams(params ...string) Option {
+	return func(crawler *WebCrawler) {
+		crawler.canonicalizer = canonicalizer{trackingParams: params}
+	}
+}
+
+// canonicalizer maps the different spellings of a page url to one form,
+// which is used to decide whether the page has already been enqueued.
+type canonicalizer struct {
+	trackingParams []string
+}
+
+// Canonicalize lowercases scheme and host, drops default ports, fragments,
+// tracking query parameters and trailing slashes, and sorts the query.
+// Only absolute http(s) urls are accepted.
+func (c canonicalizer) Canonicalize(raw url) (url, error) {
+	u, err := neturl.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if _, isHttp := defaultPorts[u.Scheme]; !isHttp || u.Host == "" {
+		return "", errNotHttpUrl
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		host += ":" + port
+	}
+	u.Host = host
+	u.User = nil
+
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if len(u.Path) > 1 {
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = strings.TrimRight(u.RawPath, "/")
+	}
+	if u.Path == "" {
+		u.Path = "/"
+		u.RawPath = ""
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		for param := range query {
+			if c.isTrackingParam(param) {
+				delete(query, param)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+// requestURL returns href as it is requested. The canonical form only
+// identifies the page: a server may serve different resources at /dir and
+// /dir/ or redirect one to the other, so only the fragment, which is never
+// sent, is dropped.
+func requestURL(href url) url {
+	u, err := neturl.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return href
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	return u.String()
+}
+
+func (c canonicalizer) isTrackingParam(param string) bool {
+	param = strings.ToLower(param)
+
+	for _, pattern := range c.trackingParams {
+		if prefix, isPrefix := strings.CutSuffix(pattern, "*"); isPrefix {
+			if strings.HasPrefix(param, prefix) {
+				return true
+			}
+		} else if param == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isCanonicalCopy marks the canonical form of the page url as seen, so
+// redirect targets are not crawled again. If the page declares a different
+// canonical url with <link rel=canonical> the declared url is marked too, and
+// the page is reported as a copy when that url has already been enqueued.
+func (crawler *WebCrawler) isCanonicalCopy(f *frontier, e *colly.HTMLElement) bool {
+	pageUrl, err := crawler.canonicalizer.Canonicalize(e.Request.URL.String())
+	if err != nil {
+		return false
+	}
+	if _, err := f.MarkSeen(pageUrl); err != nil {
+		log.Println(err)
+	}
+
+	declared := e.ChildAttr(`link[rel~="canonical"]`, "href")
+	if declared == "" {
+		return false
+	}
+
+	canonical, err := crawler.canonicalizer.Canonicalize(e.Request.AbsoluteURL(declared))
+	if err != nil || canonical == pageUrl {
+		return false
+	}
+
+	added, err := f.MarkSeen(canonical)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	return !added
+}
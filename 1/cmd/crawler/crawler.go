@@ -5,16 +5,31 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"strings"
-	"sync"
+	"net/http"
+	neturl "net/url"
+	"path/filepath"
+	"strconv"
 	"sync/atomic"
 	"time"
 
 	"colly"
+	"crawler/warc"
 )
 
 type url = string
 
+// Defaults of the options which change how pages are requested.
+const (
+	DefaultUserAgent      = "Mozilla/5.0 (Linux; Android 6.0; Nexus 5 Build/MRA58N) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/134.0.0.0 Mobile Safari/537.36"
+	DefaultRequestTimeout = 30 * time.Second
+	DefaultDelay          = time.Second
+	DefaultRandomDelay    = time.Second
+)
+
+// maxHostDelay limits how much a host can slow the crawl down
+// with Crawl-delay, Retry-After and error responses
+const maxHostDelay = time.Minute
+
 type HtmlBodyProccessor interface {
 	Process(e colly.HTMLElement) error
 	Complete() error
@@ -24,10 +39,85 @@ type WebCrawler struct {
 	isCrawling        int32
 	workersCount      int
 	responseProcessor HtmlBodyProccessor
-	visitedUrls       sync.Map
+	stateDir          string
+	canonicalizer     canonicalizer
+	scope             Scope
+	targetDocuments   int64
+	linkGraphPath     string
+	retryPolicy       RetryPolicy
+	userAgent         string
+	requestTimeout    time.Duration
+	hostParallelism   int
+	delay             time.Duration
+	randomDelay       time.Duration
+	warcDir           string
+	warcMaxFileSize   int64
+	cacheDir          string
+	relevance         *relevanceScorer
+	scoreLogPath      string
+	robots            RobotsDirectives
+	trapRules         TrapRules
+	trapReportPath    string
+
+	requeueDeadLetters bool
+	recrawl            bool
+
+	metricsAddr      string
+	progressInterval time.Duration
+
+	useSitemaps           bool
+	sitemapsModifiedSince time.Time
 }
 
-func New(htmlBodyProccessor HtmlBodyProccessor, workersCount int) (*WebCrawler, error) {
+// Option configures optional WebCrawler features.
+type Option func(*WebCrawler)
+
+// StateDir makes the crawl resumable: the frontier and the set of already
+// enqueued urls are persisted to dir, and a crawl started with the same dir
+// continues where the previous one stopped.
+func StateDir(dir string) Option {
+	return func(crawler *WebCrawler) {
+		crawler.stateDir = dir
+	}
+}
+
+// UserAgent replaces DefaultUserAgent with userAgent.
+func UserAgent(userAgent string) Option {
+	return func(crawler *WebCrawler) {
+		crawler.userAgent = userAgent
+	}
+}
+
+// RequestTimeout replaces DefaultRequestTimeout with timeout.
+func RequestTimeout(timeout time.Duration) Option {
+	return func(crawler *WebCrawler) {
+		crawler.requestTimeout = timeout
+	}
+}
+
+// RateLimit sets how many requests are sent to a host at once and the delay
+// between them, which is extended by a random part up to randomDelay.
+// 0 parallelism means the number of workers. Hosts can slow the crawl down
+// further with Crawl-delay and Retry-After.
+func RateLimit(parallelism int, delay time.Duration, randomDelay time.Duration) Option {
+	return func(crawler *WebCrawler) {
+		crawler.hostParallelism = parallelism
+		crawler.delay = delay
+		crawler.randomDelay = randomDelay
+	}
+}
+
+// WARC makes the crawler archive every request and response in gzip
+// compressed WARC files in dir. A new file is started when the current one
+// exceeds maxFileSize bytes, 0 means warc.DefaultMaxFileSize.
+func WARC(dir string, maxFileSize int64) Option {
+	return func(crawler *WebCrawler) {
+		crawler.warcDir = dir
+		crawler.warcMaxFileSize = maxFileSize
+	}
+}
+
+func New(htmlBodyProccessor HtmlBodyProccessor, workersCount int, options ...Option) (*WebCrawler, error) {
 	if workersCount <= 0 {
 		return nil, errors.New("wrong parameter value")
 	} else if htmlBodyProccessor == nil {
@@ -38,7 +128,18 @@ func New(htmlBodyProccessor HtmlBodyProccessor, workersCount int) (*WebCrawler,
 		isCrawling:        0,
 		workersCount:      workersCount,
 		responseProcessor: htmlBodyProccessor,
-		visitedUrls:       sync.Map{},
+		canonicalizer:     canonicalizer{trackingParams: DefaultTrackingParams},
+		retryPolicy:       DefaultRetryPolicy,
+		userAgent:         DefaultUserAgent,
+		requestTimeout:    DefaultRequestTimeout,
+		delay:             DefaultDelay,
+		randomDelay:       DefaultRandomDelay,
+		robots:            DefaultRobotsDirectives,
+		trapRules:         DefaultTrapRules,
+	}
+
+	for _, option := range options {
+		option(&crawler)
 	}
 
 	return &crawler, nil
@@ -51,63 +152,395 @@ func (crawler *WebCrawler) setStopFlag() {
 	}
 }
 
+func (crawler *WebCrawler) startCrawling() error {
+	addr := &crawler.isCrawling
+	crawlStarted := atomic.CompareAndSwapInt32(addr, atomic.LoadInt32(addr), 1)
+	if !crawlStarted {
+		return errors.New("crawler has been aldready stared")
+	}
+
+	return nil
+}
+
+// putRequestKeys puts the urls of r into its context, see colly.OriginalURLCtxKey
+func (crawler *WebCrawler) putRequestKeys(r *colly.Request) {
+	if r.Ctx.Get(colly.OriginalURLCtxKey) != "" {
+		return
+	}
+
+	crawler.putURLKeys(r.Ctx, r.URL.String())
+}
+
+// putURLKeys puts the requested url original and its canonical form into ctx
+func (crawler *WebCrawler) putURLKeys(ctx *colly.Context, original url) {
+	ctx.Put(colly.OriginalURLCtxKey, original)
+	if canonical, err := crawler.canonicalizer.Canonicalize(original); err == nil {
+		ctx.Put(colly.CanonicalURLCtxKey, canonical)
+	}
+}
+
+// process passes a page to the processor unless it is a copy of
+// a canonical page which has been seen or a noindex page
+func (crawler *WebCrawler) process(f *frontier, h *colly.HTMLElement) {
+	if crawler.robots.NoIndex && pageRobots(h.Request).noIndex {
+		return
+	}
+	if crawler.isCanonicalCopy(f, h) {
+		return
+	}
+
+	err := crawler.responseProcessor.Process(*h)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 func (crawler *WebCrawler) Crawl(ctx context.Context, entryUrls []url) error {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	addr := &crawler.isCrawling
-	crawlStarted := atomic.CompareAndSwapInt32(addr, atomic.LoadInt32(addr), 1)
-	if !crawlStarted {
-		return errors.New("crawler has been aldready stared")
+	if err := crawler.startCrawling(); err != nil {
+		return err
 	}
 	defer crawler.setStopFlag()
 
+	frontier, err := openFrontier(crawler.stateDir, crawler.workersCount, crawler.relevance != nil)
+	if err != nil {
+		return err
+	}
+	defer frontier.Close()
+
 	c := colly.NewCollector(
 		colly.StdlibContext(ctx),
-		colly.MaxDepth(1),
-		colly.UserAgent("Mozilla/5.0 (Linux; Android 6.0; Nexus 5 Build/MRA58N) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/134.0.0.0 Mobile Safari/537.36"),
-		colly.Async(true),
+		colly.UserAgent(crawler.userAgent),
+		colly.AdaptiveThrottle(maxHostDelay),
+		colly.TraceHTTP(),
+		colly.CacheDir(crawler.cacheDir),
+		// legacy Cyrillic pages often declare no charset or a wrong one
+		colly.DetectCharset(),
 	)
-	c.SetRequestTimeout(30 * time.Second)
+	c.SetRequestTimeout(crawler.requestTimeout)
+	if crawler.warcDir != "" {
+		archive, err := warc.NewWriter(crawler.warcDir, "crawl", crawler.warcMaxFileSize)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+
+		c.WithTransport(warc.NewTransport(nil, archive))
+	}
+	// the Crawl-delay of robots.txt is honored together with its Disallow
+	// rules, or on its own
+	c.IgnoreRobotsTxt = !crawler.robots.RobotsTxt
+	c.HonorCrawlDelay = crawler.robots.CrawlDelay
 
-	c.Limit(&colly.LimitRule{
-		Parallelism: crawler.workersCount,
-		RandomDelay: time.Duration(time.Duration.Seconds(1)),
-		Delay:       time.Duration(time.Duration.Seconds(1)),
+	parallelism := crawler.hostParallelism
+	if parallelism <= 0 {
+		parallelism = crawler.workersCount
+	}
+	err = c.Limit(&colly.LimitRule{
+		DomainGlob:  "*",
+		Parallelism: parallelism,
+		RandomDelay: crawler.randomDelay,
+		Delay:       crawler.delay,
 	})
+	if err != nil {
+		return err
+	}
+
+	seeds := make([]url, 0, len(entryUrls))
+	for _, url := range entryUrls {
+		canonical, err := crawler.canonicalizer.Canonicalize(url)
+		if err != nil {
+			return fmt.Errorf("entry url %s: %w", url, err)
+		}
+		seeds = append(seeds, canonical)
+	}
+
+	if crawler.recrawl {
+		if crawler.stateDir == "" {
+			return errors.New("recrawl requires a state dir")
+		}
+		if err := frontier.ForgetSeen(); err != nil {
+			return err
+		}
+	}
+
+	scope := newScopeChecker(crawler.scope, seeds)
+	scope.countSeen(frontier)
+	traps := newTrapDetector(crawler.trapRules)
+	traps.countSeen(frontier)
+	if crawler.trapReportPath != "" {
+		defer func() {
+			if err := traps.WriteReport(crawler.trapReportPath); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	fetched := newValidators()
+	var failed *deadLetters
+	if crawler.stateDir != "" {
+		if fetched, err = openValidators(filepath.Join(crawler.stateDir, validatorsFileName)); err != nil {
+			return err
+		}
+		defer fetched.Close()
+
+		deadLettersPath := filepath.Join(crawler.stateDir, deadLettersFileName)
+
+		if crawler.requeueDeadLetters {
+			requeued, err := requeueDeadLetters(frontier, deadLettersPath)
+			if err != nil {
+				return err
+			}
+			log.Printf("%d failed requests requeued\n", requeued)
+		}
+
+		if failed, err = openDeadLetters(deadLettersPath); err != nil {
+			return err
+		}
+		defer failed.Close()
+	} else if crawler.requeueDeadLetters {
+		return errors.New("failed requests can be requeued only with a state dir")
+	}
+
+	stats := newMetrics(frontier, crawler.responseProcessor, traps)
+	stats.hostDelays = c.HostDelays
+	if crawler.metricsAddr != "" {
+		server := serveMetrics(crawler.metricsAddr, stats)
+		defer server.Close()
+	}
+
+	var links *linkGraph
+	if crawler.linkGraphPath != "" {
+		if links, err = openLinkGraph(crawler.linkGraphPath); err != nil {
+			return err
+		}
+		defer links.Close()
+	}
+
+	var scores *scoreLog
+	if crawler.scoreLogPath != "" && crawler.relevance != nil {
+		if scores, err = openScoreLog(crawler.scoreLogPath); err != nil {
+			return err
+		}
+		defer scores.Close()
+	}
+
+	// push enqueues href if it is in the scope and returns its canonical form,
+	// which is empty if href can not be crawled at all. The links found on
+	// pages are checked for traps.
+	push := func(href url, score linkScore, found bool) url {
+		depth := score.depth
+		canonical, err := crawler.canonicalizer.Canonicalize(href)
+		if err != nil {
+			return ""
+		}
+
+		u, err := neturl.Parse(canonical)
+		if err != nil || frontier.Seen(canonical) || !scope.Allows(u, depth) || !scope.Reserve(u.Host) {
+			return canonical
+		}
+		if found && !traps.Admit(u) {
+			scope.Release(u.Host)
+			return canonical
+		}
+
+		added, err := frontier.Push(canonical, requestURL(href), depth, score.score)
+		if err != nil {
+			log.Println(err)
+		}
+		if !added {
+			scope.Release(u.Host)
+			if found {
+				traps.Release(u)
+			}
+		} else if scores != nil {
+			if err := scores.Add(canonical, score); err != nil {
+				log.Println(err)
+			}
+		}
+
+		return canonical
+	}
+
+	c.OnRequest(func(r *colly.Request) {
+		crawler.putRequestKeys(r)
+
+		if page, found := fetched.Get(r.URL.String()); found {
+			setConditionalHeaders(r, page)
+		}
+	})
+
+	c.OnResponse(func(r *colly.Response) {
+		r.Ctx.Put(colly.FetchedAtCtxKey, time.Now())
+		stats.ObserveResponse(r)
+
+		if err := fetched.Update(r); err != nil {
+			log.Println(err)
+		}
+	})
+
+	// the callbacks of a page run in the order they are added,
+	// so the links and the page itself are handled after these
+	c.OnHTML("html", func(h *colly.HTMLElement) {
+		putRobotsDirectives(h, stats)
+	})
+	if crawler.relevance != nil {
+		c.OnHTML("html", crawler.putPageRelevance)
+	}
 
 	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
-		href := e.Attr("href")
-		if isLocalLink := strings.HasPrefix(href, "/"); isLocalLink {
-			href = fmt.Sprintf("%s://%s%s", e.Request.URL.Scheme, e.Request.Host, href)
+		if isRelNoFollow(e) {
+			stats.noFollowLinks.Add(1)
+			if crawler.robots.RelNoFollow {
+				return
+			}
+		}
+		if crawler.robots.NoFollow && pageRobots(e.Request).noFollow {
+			return
 		}
 
-		if isHttpRef := strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://"); isHttpRef {
-			if _, visited := crawler.visitedUrls.LoadOrStore(href, struct{}{}); !visited {
-				c.Visit(href)
+		// AbsoluteURL resolves relative links against <base href> when the page has one
+		to := push(e.Request.AbsoluteURL(e.Attr("href")), crawler.scoreLink(e, e.Request.Depth+1), true)
+
+		if from := e.Request.Ctx.Get(colly.CanonicalURLCtxKey); links != nil && from != "" && to != "" {
+			if err := links.Add(from, to); err != nil {
+				log.Println(err)
 			}
 		}
 	})
 
 	c.OnHTML("html", func(h *colly.HTMLElement) {
-		err := crawler.responseProcessor.Process(*h)
-		if err != nil {
+		crawler.process(frontier, h)
+	})
+
+	requeue := func(r *colly.Request) {
+		if err := frontier.Requeue(r); err != nil {
 			log.Println(err)
 		}
-	})
+	}
 
 	c.OnError(func(r *colly.Response, err error) {
-		log.Println(err)
+		if ctx.Err() != nil {
+			// the request was interrupted by cancellation, keep it for the next run
+			requeue(r.Request)
+			return
+		}
+
+		tracker, tracksChanges := crawler.responseProcessor.(ChangeTracker)
+		if r.StatusCode == http.StatusNotModified {
+			stats.notModified.Add(1)
+			if tracksChanges {
+				tracker.Unchanged(r.Request.URL.String())
+			}
+			return
+		}
+		if isGone(r.StatusCode) {
+			if _, found := fetched.Get(r.Request.URL.String()); found {
+				stats.gone.Add(1)
+				if err := fetched.Remove(r.Request.URL.String()); err != nil {
+					log.Println(err)
+				}
+				if tracksChanges {
+					tracker.Gone(r.Request.URL.String())
+				}
+			}
+		}
+
+		stats.ObserveError(r, err)
+
+		policy := crawler.retryPolicy
+		if !policy.IsRetryable(r, err) {
+			log.Println(r.Request.URL, err)
+			return
+		}
+
+		attempt := attempts(r.Request)
+		if attempt >= policy.MaxAttempts {
+			log.Printf("%s: %v, giving up after %d attempts\n", r.Request.URL, err, attempt)
+			stats.deadLetters.Add(1)
+			if failed != nil {
+				if err := failed.Add(r, err); err != nil {
+					log.Println(err)
+				}
+			}
+			return
+		}
+
+		log.Printf("%s: %v, retry %d of %d\n", r.Request.URL, err, attempt, policy.MaxAttempts-1)
+		r.Request.Ctx.Put(attemptCtxKey, strconv.Itoa(attempt+1))
+		stats.retries.Add(1)
+
+		// the worker is free to fetch other pages during the backoff,
+		// a failed retry comes back to this handler
+		if err := frontier.RetryAfter(r.Request, policy.Backoff(attempt)); err != nil {
+			log.Println(err)
+		}
 	})
 
-	for _, url := range entryUrls {
-		c.Visit(url)
+	for i, seed := range seeds {
+		if _, err := frontier.Push(seed, requestURL(entryUrls[i]), 0, seedScore); err != nil {
+			return err
+		}
+	}
+
+	if crawler.recrawl {
+		fetched.Range(func(page validator) {
+			push(page.Url, fixedScore(page.Depth, seedScore), false)
+		})
+	}
+
+	if crawler.useSitemaps {
+		crawler.discoverSitemaps(c, seeds, func(href url) {
+			push(href, fixedScore(0, 0), false)
+		})
+	}
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go crawler.stopWhenDone(ctx, frontier, stopped)
+	if crawler.progressInterval > 0 {
+		go reportProgress(stats, crawler.progressInterval, stopped)
 	}
 
-	c.Wait()
+	if err := frontier.Run(c); err != nil {
+		log.Println(err)
+	}
 	crawler.responseProcessor.Complete()
+	log.Println(stats.Progress())
 	log.Println("Crawling completed.")
 
 	return nil
 }
+
+// stopWhenDone stops the frontier when ctx is cancelled or
+// the target number of documents has been accepted.
+func (crawler *WebCrawler) stopWhenDone(ctx context.Context, frontier *frontier, stopped <-chan struct{}) {
+	counter, countsAccepted := crawler.responseProcessor.(AcceptedCounter)
+	var tick <-chan time.Time
+	if crawler.targetDocuments > 0 && countsAccepted {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		tick = ticker.C
+	} else if crawler.targetDocuments > 0 {
+		log.Println("processor does not count accepted documents, target is ignored")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			frontier.Stop()
+			return
+		case <-tick:
+			if counter.Accepted() >= crawler.targetDocuments {
+				log.Printf("%d documents accepted, stopping.\n", crawler.targetDocuments)
+				frontier.Stop()
+				return
+			}
+		case <-stopped:
+			return
+		}
+	}
+}
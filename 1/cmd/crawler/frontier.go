@@ -0,0 +1,202 @@
+package crawler
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"colly"
+	"colly/queue"
+)
+
+const (
+	queueFileName         = "frontier.queue"
+	priorityQueueFileName = "frontier.pqueue"
+	visitedFileName       = "visited.txt"
+	markedFileName        = "marked.txt"
+)
+
+// fileStorage is a queue storage which keeps the queue in a file
+type fileStorage interface {
+	queue.Storage
+	Close() error
+}
+
+// frontier holds the urls which are waiting to be crawled together with
+// every url that has ever been enqueued. With an empty stateDir both live
+// in memory only, otherwise they are restored from and persisted to stateDir.
+// A prioritized frontier returns the url with the highest score first,
+// otherwise the oldest one.
+type frontier struct {
+	queue       *queue.Queue
+	storage     fileStorage
+	seen        *urlSet
+	prioritized bool
+}
+
+func openFrontier(stateDir string, workersCount int, prioritized bool) (*frontier, error) {
+	if stateDir == "" {
+		var storage queue.Storage
+		if prioritized {
+			storage = &queue.InMemoryPriorityQueueStorage{}
+		}
+		q, err := queue.New(workersCount, storage)
+		if err != nil {
+			return nil, err
+		}
+
+		return &frontier{queue: q, seen: newUrlSet(), prioritized: prioritized}, nil
+	}
+
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return nil, err
+	}
+
+	seen, err := openUrlSet(filepath.Join(stateDir, visitedFileName), filepath.Join(stateDir, markedFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	// the queue file of the other kind of frontier is left by a previous run
+	queuePath := filepath.Join(stateDir, queueFileName)
+	priorityQueuePath := filepath.Join(stateDir, priorityQueueFileName)
+	var storage, other fileStorage = &queue.FileQueueStorage{Path: queuePath}, &queue.FilePriorityQueueStorage{Path: priorityQueuePath}
+	otherPath := priorityQueuePath
+	if prioritized {
+		storage, other, otherPath = other, storage, queuePath
+	}
+
+	q, err := queue.New(workersCount, storage)
+	if err != nil {
+		seen.Close()
+		return nil, err
+	}
+
+	f := &frontier{queue: q, storage: storage, seen: seen, prioritized: prioritized}
+	if err := f.adopt(other, otherPath); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// adopt moves the requests left at path by a run with the other kind of
+// frontier into the queue and removes the file. A prioritized frontier
+// gets them with score 0.
+func (f *frontier) adopt(other fileStorage, path string) error {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err := other.Init(); err != nil {
+		return err
+	}
+	moved := 0
+	for {
+		r, err := other.GetRequest()
+		if err == nil && r != nil {
+			err = f.storage.AddRequest(r)
+		}
+		if err != nil {
+			other.Close()
+			return err
+		} else if r == nil {
+			break
+		}
+		moved++
+	}
+	if moved > 0 {
+		log.Printf("%d requests moved from %s\n", moved, path)
+	}
+
+	if err := other.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// Push enqueues href found depth links away from the entry urls unless its
+// canonical form has been enqueued before, in this or in a previous run.
+// It returns true if the page is new. A prioritized frontier keeps the url
+// with score.
+func (f *frontier) Push(canonical url, href url, depth int, score float64) (bool, error) {
+	u, err := neturl.Parse(href)
+	if err != nil {
+		return false, err
+	}
+
+	added, err := f.seen.Add(canonical)
+	if err != nil || !added {
+		return false, err
+	}
+
+	r := &colly.Request{
+		URL:    u,
+		Method: "GET",
+		Depth:  depth,
+	}
+	if !f.prioritized {
+		return true, f.queue.AddRequest(r)
+	}
+
+	r.Ctx = colly.NewContext()
+	r.Ctx.Put(scoreCtxKey, strconv.FormatFloat(score, 'g', -1, 64))
+
+	return true, f.queue.AddPriorityRequest(r, score)
+}
+
+// Seen reports whether href has been enqueued before.
+func (f *frontier) Seen(href url) bool {
+	return f.seen.Contains(href)
+}
+
+// MarkSeen records href as seen without putting it into the queue, so it
+// is not enqueued later. It returns true if href has not been seen before.
+func (f *frontier) MarkSeen(href url) (bool, error) {
+	return f.seen.Mark(href)
+}
+
+// ForgetSeen starts a new pass over the web: every url can be enqueued
+// again. The urls which are already in the queue stay there.
+func (f *frontier) ForgetSeen() error {
+	return f.seen.Clear()
+}
+
+// Requeue puts back a request which was taken from the queue but could not
+// be completed, e.g. because the crawl was cancelled.
+func (f *frontier) Requeue(r *colly.Request) error {
+	return f.queue.AddPriorityRequest(r, requestScore(r))
+}
+
+// RetryAfter puts a failed request back into the queue once delay has
+// passed. No worker waits for it meanwhile.
+func (f *frontier) RetryAfter(r *colly.Request, delay time.Duration) error {
+	return f.queue.RetryAfter(r, requestScore(r), delay)
+}
+
+func (f *frontier) Run(c *colly.Collector) error {
+	return f.queue.Run(c)
+}
+
+func (f *frontier) Stop() {
+	f.queue.Stop()
+}
+
+func (f *frontier) Close() error {
+	err := f.seen.Close()
+
+	if f.storage != nil {
+		if cerr := f.storage.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,86 @@
+package crawler
+
+import (
+	"testing"
+
+	"colly"
+)
+
+// popURLs takes all the requests out of the storage of f and returns their urls
+func popURLs(t *testing.T, f *frontier) []string {
+	c := colly.NewCollector()
+	var urls []string
+	for {
+		data, err := f.storage.GetRequest()
+		if err != nil {
+			t.Fatal(err)
+		} else if data == nil {
+			return urls
+		}
+		r, err := c.UnmarshalRequest(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, r.URL.String())
+	}
+}
+
+func TestFrontierResume(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name        string
+		prioritized bool
+		push        []url
+		added       []bool
+		queued      int
+	}{
+		{"first run", false, []url{"http://example.com/a", "http://example.com/b", "http://example.com/a"}, []bool{true, true, false}, 2},
+		{"resumed run", false, []url{"http://example.com/b", "http://example.com/c"}, []bool{false, true}, 3},
+		{"resumed best-first run", true, []url{"http://example.com/c", "http://example.com/d"}, []bool{false, true}, 4},
+	}
+
+	for _, test := range tests {
+		f, err := openFrontier(dir, 1, test.prioritized)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, u := range test.push {
+			added, err := f.Push(u, u, 1, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if added != test.added[i] {
+				t.Errorf("%s: Push(%s) = %v", test.name, u, added)
+			}
+		}
+		if size, _ := f.queue.Size(); size != test.queued {
+			t.Errorf("%s: %d queued requests, want %d", test.name, size, test.queued)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFrontierForgetSeen(t *testing.T) {
+	f, err := openFrontier(t.TempDir(), 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	u := "http://example.com/a"
+	if added, _ := f.MarkSeen(u); !added {
+		t.Fatal("a new url is not marked")
+	}
+	if added, _ := f.Push(u, u, 0, 0); added {
+		t.Error("a marked url is enqueued")
+	}
+	if err := f.ForgetSeen(); err != nil {
+		t.Fatal(err)
+	}
+	if added, _ := f.Push(u, u, 0, 0); !added {
+		t.Error("a forgotten url is not enqueued")
+	}
+}
@@ -0,0 +1,54 @@
+package crawler
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+// LinkGraph makes the crawler append every link it finds to the file at path,
+// one "from\tto" line per link. Both urls are canonical, the source is the
+// same url that is stored under colly.CanonicalURLCtxKey of the page request.
+// Links to pages outside of the crawl are recorded as well.
+func LinkGraph(path string) Option {
+	return func(crawler *WebCrawler) {
+		crawler.linkGraphPath = path
+	}
+}
+
+// linkGraph is the file the found links are written to
+type linkGraph struct {
+	lock   sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func openLinkGraph(path string) (*linkGraph, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &linkGraph{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (g *linkGraph) Add(from url, to url) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	_, err := g.writer.WriteString(from + "\t" + to + "\n")
+
+	return err
+}
+
+func (g *linkGraph) Close() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	err := g.writer.Flush()
+	if cerr := g.file.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
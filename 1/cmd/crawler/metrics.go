@@ -0,0 +1,301 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"colly"
+)
+
+// latencyBuckets are the upper bounds of the latency histogram buckets, in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// ProcessorStats is implemented by processors which count what happens to
+// the pages they get, e.g. how many were accepted and why the others were
+// rejected. The counters are reported with the crawl metrics.
+type ProcessorStats interface {
+	Stats() map[string]int64
+}
+
+// MetricsAddr makes the crawler serve its metrics in the Prometheus text
+// format at http://addr/metrics while it crawls.
+func MetricsAddr(addr string) Option {
+	return func(crawler *WebCrawler) {
+		crawler.metricsAddr = addr
+	}
+}
+
+// Progress makes the crawler log a one-line summary of its metrics
+// every interval.
+func Progress(interval time.Duration) Option {
+	return func(crawler *WebCrawler) {
+		crawler.progressInterval = interval
+	}
+}
+
+// metrics are the counters of a single crawl
+type metrics struct {
+	started         time.Time
+	pagesFetched    atomic.Int64
+	bytesDownloaded atomic.Int64
+	retries         atomic.Int64
+	deadLetters     atomic.Int64
+	notModified     atomic.Int64
+	gone            atomic.Int64
+	noIndexPages    atomic.Int64
+	noFollowPages   atomic.Int64
+	noFollowLinks   atomic.Int64
+
+	lock   sync.Mutex
+	errors map[string]int64
+
+	connectDuration   *histogram
+	firstByteDuration *histogram
+
+	queueDepth     func() int
+	trapped        func() map[string]int64
+	processorStats func() map[string]int64
+	hostDelays     func() map[string]time.Duration
+}
+
+func newMetrics(frontier *frontier, processor HtmlBodyProccessor, traps *trapDetector) *metrics {
+	m := &metrics{
+		started:           time.Now(),
+		errors:            make(map[string]int64),
+		connectDuration:   newHistogram(latencyBuckets),
+		firstByteDuration: newHistogram(latencyBuckets),
+		queueDepth: func() int {
+			size, _ := frontier.queue.Size()
+			return size
+		},
+		trapped:        traps.Trapped,
+		processorStats: func() map[string]int64 { return nil },
+		hostDelays:     func() map[string]time.Duration { return nil },
+	}
+
+	if stats, ok := processor.(ProcessorStats); ok {
+		m.processorStats = stats.Stats
+	}
+
+	return m
+}
+
+func (m *metrics) ObserveResponse(r *colly.Response) {
+	m.pagesFetched.Add(1)
+	m.bytesDownloaded.Add(int64(len(r.Body)))
+
+	if r.Trace == nil {
+		return
+	}
+	// connections reused from the pool have no connect duration
+	if r.Trace.ConnectDuration > 0 {
+		m.connectDuration.Observe(r.Trace.ConnectDuration.Seconds())
+	}
+	m.firstByteDuration.Observe(r.Trace.FirstByteDuration.Seconds())
+}
+
+func (m *metrics) ObserveError(r *colly.Response, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.errors[errorClass(r, err)]++
+}
+
+// errorClass groups fetch errors for the metrics
+func errorClass(r *colly.Response, err error) string {
+	switch {
+	case r.StatusCode >= 500:
+		return "http_5xx"
+	case r.StatusCode >= 400:
+		return "http_4xx"
+	case r.StatusCode >= 300:
+		return "http_3xx"
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		return "cancelled"
+	case IsTransientError(err):
+		return "network"
+	default:
+		return "other"
+	}
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format
+func (m *metrics) WritePrometheus(w io.Writer) {
+	writeMetric(w, "crawler_pages_fetched_total", "counter", "Responses received.", m.pagesFetched.Load())
+	writeMetric(w, "crawler_bytes_downloaded_total", "counter", "Bytes of response bodies received.", m.bytesDownloaded.Load())
+	writeMetric(w, "crawler_retries_total", "counter", "Failed requests which were retried.", m.retries.Load())
+	writeMetric(w, "crawler_dead_letters_total", "counter", "Failed requests which were given up.", m.deadLetters.Load())
+	writeMetric(w, "crawler_not_modified_total", "counter", "Pages which have not changed since the previous crawl.", m.notModified.Load())
+	writeMetric(w, "crawler_gone_total", "counter", "Pages of previous crawls which no longer exist.", m.gone.Load())
+	writeMetric(w, "crawler_noindex_pages_total", "counter", "Pages with a noindex robots directive.", m.noIndexPages.Load())
+	writeMetric(w, "crawler_nofollow_pages_total", "counter", "Pages with a nofollow robots directive.", m.noFollowPages.Load())
+	writeMetric(w, "crawler_nofollow_links_total", "counter", "Links with rel=\"nofollow\".", m.noFollowLinks.Load())
+	writeMetric(w, "crawler_queue_depth", "gauge", "Requests waiting in the frontier.", m.queueDepth())
+
+	m.lock.Lock()
+	errorCounts := make(map[string]int64, len(m.errors))
+	for class, count := range m.errors {
+		errorCounts[class] = count
+	}
+	m.lock.Unlock()
+	writeLabeledMetric(w, "crawler_fetch_errors_total", "counter", "Failed requests by error class.", "class", errorCounts)
+	writeLabeledMetric(w, "crawler_trapped_urls_total", "counter", "Found links skipped as crawler traps by rule.", "rule", m.trapped())
+
+	writeLabeledMetric(w, "crawler_documents_total", "counter", "Processed pages by result.", "result", m.processorStats())
+	writeHostDelays(w, m.hostDelays())
+
+	m.connectDuration.Write(w, "crawler_connect_duration_seconds", "Time to establish new connections.")
+	m.firstByteDuration.Write(w, "crawler_first_byte_duration_seconds", "Time from request start to the first response byte.")
+}
+
+// Progress returns a one-line summary of the metrics
+func (m *metrics) Progress() string {
+	elapsed := time.Since(m.started)
+	fetched := m.pagesFetched.Load()
+
+	m.lock.Lock()
+	var errorsCount int64
+	for _, count := range m.errors {
+		errorsCount += count
+	}
+	m.lock.Unlock()
+
+	var trappedCount int64
+	for _, count := range m.trapped() {
+		trappedCount += count
+	}
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "fetched %d (%.1f/s), %.1f MiB, not modified %d, gone %d, errors %d, retries %d, queue %d, noindex %d, nofollow %d, nofollow links %d, trapped %d",
+		fetched, float64(fetched)/elapsed.Seconds(), float64(m.bytesDownloaded.Load())/(1<<20),
+		m.notModified.Load(), m.gone.Load(), errorsCount, m.retries.Load(), m.queueDepth(),
+		m.noIndexPages.Load(), m.noFollowPages.Load(), m.noFollowLinks.Load(), trappedCount)
+
+	stats := m.processorStats()
+	for _, key := range sortedKeys(stats) {
+		fmt.Fprintf(sb, ", %s %d", key, stats[key])
+	}
+
+	return sb.String()
+}
+
+// serveMetrics starts the metrics endpoint. The returned server is closed
+// by the caller.
+func serveMetrics(addr string, m *metrics) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.WritePrometheus(w)
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println(err)
+		}
+	}()
+
+	return server
+}
+
+// reportProgress logs the progress every interval until stopped is closed
+func reportProgress(m *metrics, interval time.Duration, stopped <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			log.Println(m.Progress())
+		case <-stopped:
+			return
+		}
+	}
+}
+
+type histogram struct {
+	lock   sync.Mutex
+	bounds []float64
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds))}
+}
+
+func (h *histogram) Observe(value float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	i := sort.SearchFloat64s(h.bounds, value)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+}
+
+// Write writes the histogram with cumulative buckets
+func (h *histogram) Write(w io.Writer, name string, help string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+}
+
+func writeMetric[T int | int64](w io.Writer, name string, kind string, help string, value T) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+func writeLabeledMetric(w io.Writer, name string, kind string, help string, label string, values map[string]int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, key, values[key])
+	}
+}
+
+// writeHostDelays writes the current delay between the requests to every
+// host which is slowed down by its Crawl-delay, Retry-After or errors
+func writeHostDelays(w io.Writer, delays map[string]time.Duration) {
+	const name = "crawler_host_delay_seconds"
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, "Current delay between the requests to a host.", name)
+
+	hosts := make([]string, 0, len(delays))
+	for host := range delays {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		fmt.Fprintf(w, "%s{host=%q} %g\n", name, host, delays[host].Seconds())
+	}
+}
+
+func sortedKeys(values map[string]int64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,128 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"colly"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		status int
+		err    error
+		want   string
+	}{
+		{503, errors.New("Service Unavailable"), "http_5xx"},
+		{404, errors.New("Not Found"), "http_4xx"},
+		{304, errors.New("Not Modified"), "http_3xx"},
+		{0, fmt.Errorf("get: %w", context.DeadlineExceeded), "cancelled"},
+		{0, context.Canceled, "cancelled"},
+		{0, fmt.Errorf("dial: %w", syscall.ECONNREFUSED), "network"},
+		{0, errors.New("unsupported protocol scheme"), "other"},
+	}
+
+	for _, test := range tests {
+		if got := errorClass(&colly.Response{StatusCode: test.status}, test.err); got != test.want {
+			t.Errorf("errorClass(%d, %v) = %s, want %s", test.status, test.err, got, test.want)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{0.1, 1})
+	for _, value := range []float64{0.05, 0.1, 0.5, 2} {
+		h.Observe(value)
+	}
+
+	var b strings.Builder
+	h.Write(&b, "latency_seconds", "Latency.")
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 2.65
+latency_seconds_count 4
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	m := &metrics{
+		started:           time.Now(),
+		errors:            map[string]int64{"network": 2, "http_5xx": 1},
+		connectDuration:   newHistogram(latencyBuckets),
+		firstByteDuration: newHistogram(latencyBuckets),
+		queueDepth:        func() int { return 7 },
+		trapped:           func() map[string]int64 { return map[string]int64{"depth": 3} },
+		processorStats:    func() map[string]int64 { return map[string]int64{"saved": 5, "duplicate": 1} },
+		hostDelays:        func() map[string]time.Duration { return nil },
+	}
+	m.ObserveResponse(&colly.Response{Body: []byte("12345")})
+	m.ObserveResponse(&colly.Response{Body: []byte("678")})
+	m.retries.Add(4)
+
+	var b strings.Builder
+	m.WritePrometheus(&b)
+	got := b.String()
+
+	for _, line := range []string{
+		"crawler_pages_fetched_total 2",
+		"crawler_bytes_downloaded_total 8",
+		"crawler_retries_total 4",
+		"crawler_queue_depth 7",
+		`crawler_fetch_errors_total{class="http_5xx"} 1`,
+		`crawler_fetch_errors_total{class="network"} 2`,
+		`crawler_trapped_urls_total{rule="depth"} 3`,
+		`crawler_documents_total{result="duplicate"} 1`,
+		`crawler_documents_total{result="saved"} 5`,
+		"crawler_connect_duration_seconds_count 0",
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("no %q line", line)
+		}
+	}
+
+	progress := m.Progress()
+	for _, part := range []string{"fetched 2 ", "errors 3", "retries 4", "queue 7", "trapped 3", "duplicate 1, saved 5"} {
+		if !strings.Contains(progress, part) {
+			t.Errorf("no %q in the progress %q", part, progress)
+		}
+	}
+}
+
+func TestWriteHostDelays(t *testing.T) {
+	tests := []struct {
+		name   string
+		delays map[string]time.Duration
+		want   []string
+	}{
+		{"none", nil, nil},
+		{"sorted hosts", map[string]time.Duration{"b.example.com": 1500 * time.Millisecond, "a.example.com": 5 * time.Second}, []string{
+			`crawler_host_delay_seconds{host="a.example.com"} 5`,
+			`crawler_host_delay_seconds{host="b.example.com"} 1.5`,
+		}},
+	}
+
+	for _, test := range tests {
+		var b strings.Builder
+		writeHostDelays(&b, test.delays)
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		if lines[1] != "# TYPE crawler_host_delay_seconds gauge" {
+			t.Errorf("%s: unexpected type line %q", test.name, lines[1])
+		}
+		samples := lines[2:]
+		if strings.Join(samples, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: got %q, want %q", test.name, samples, test.want)
+		}
+	}
+}
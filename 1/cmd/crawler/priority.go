@@ -0,0 +1,239 @@
+package crawler
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"colly"
+)
+
+// Parts of the score of a found link. A link is scored by the similarity of
+// its anchor text and of the text around it to the keywords, and by the
+// similarity of the page it was found on. The sum decays with depth.
+const (
+	anchorWeight  = 0.5
+	contextWeight = 0.2
+	parentWeight  = 0.3
+	depthDecay    = 0.8
+)
+
+// seedScore is the score of the entry urls and of the pages of a recrawl.
+// Found links score below it, so these are fetched first.
+const seedScore = 1.0
+
+// contextRunes is the number of runes taken on each side of a link
+// as its surrounding text
+const contextRunes = 100
+
+// stemRunes is the length words are cut to before they are compared.
+// Together with the removal of the trailing vowels it is a crude stemming
+// of the Russian endings.
+const stemRunes = 5
+
+// Keys of the values the best-first crawl puts into the context of requests.
+const (
+	// scoreCtxKey holds the score of the request as a string,
+	// so a requeued request keeps its place.
+	scoreCtxKey = "crawler.score"
+	// pageRelevanceCtxKey holds the float64 similarity of the page to the keywords.
+	pageRelevanceCtxKey = "crawler.pageRelevance"
+)
+
+// BestFirst makes the crawler always fetch the found link with the highest
+// score instead of the oldest one. Links are scored by how similar their
+// anchor text, the text around them and the page they were found on are to
+// keywords, and lose score with depth. Per-host rate limits still apply.
+func BestFirst(keywords ...string) Option {
+	return func(crawler *WebCrawler) {
+		crawler.relevance = newRelevanceScorer(keywords)
+	}
+}
+
+// ScoreLog makes a best-first crawler append the score of every enqueued
+// link to the file at path, one "url\tdepth\tanchor\tcontext\tparent\tscore"
+// line per link.
+func ScoreLog(path string) Option {
+	return func(crawler *WebCrawler) {
+		crawler.scoreLogPath = path
+	}
+}
+
+// relevanceScorer compares texts to the keywords of a best-first crawl
+type relevanceScorer struct {
+	keywords map[string]struct{}
+}
+
+func newRelevanceScorer(keywords []string) *relevanceScorer {
+	s := &relevanceScorer{keywords: make(map[string]struct{})}
+	for _, keyword := range keywords {
+		// a phrase counts as its separate words
+		for _, word := range words(keyword) {
+			s.keywords[stem(word)] = struct{}{}
+		}
+	}
+
+	return s
+}
+
+// Similarity returns the share of the keywords which occur in text
+func (s *relevanceScorer) Similarity(text string) float64 {
+	if len(s.keywords) == 0 {
+		return 0
+	}
+
+	found := make(map[string]struct{})
+	for _, word := range words(text) {
+		word = stem(word)
+		if _, ok := s.keywords[word]; ok {
+			found[word] = struct{}{}
+			if len(found) == len(s.keywords) {
+				break
+			}
+		}
+	}
+
+	return float64(len(found)) / float64(len(s.keywords))
+}
+
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func stem(word string) string {
+	runes := []rune(strings.ReplaceAll(word, "ё", "е"))
+	if len(runes) > stemRunes {
+		runes = runes[:stemRunes]
+	}
+	for len(runes) > 3 && strings.ContainsRune("аеиоуыэюяйь", runes[len(runes)-1]) {
+		runes = runes[:len(runes)-1]
+	}
+
+	return string(runes)
+}
+
+// linkScore is the score of a url together with its parts
+type linkScore struct {
+	anchor  float64
+	context float64
+	parent  float64
+	depth   int
+	score   float64
+}
+
+func newLinkScore(anchor, context, parent float64, depth int) linkScore {
+	score := (anchorWeight*anchor + contextWeight*context + parentWeight*parent) * math.Pow(depthDecay, float64(depth))
+
+	return linkScore{anchor: anchor, context: context, parent: parent, depth: depth, score: score}
+}
+
+// fixedScore is the score of a url which has not been found on a page
+func fixedScore(depth int, score float64) linkScore {
+	return linkScore{depth: depth, score: score}
+}
+
+// putPageRelevance computes the similarity of the page to the keywords
+// for the links found on it
+func (crawler *WebCrawler) putPageRelevance(h *colly.HTMLElement) {
+	text := h.ChildText("title") + " " + h.DOM.Find("body").Text()
+	h.Request.Ctx.Put(pageRelevanceCtxKey, crawler.relevance.Similarity(text))
+}
+
+// scoreLink scores the link e found depth links away from the entry urls.
+// Without best-first every link scores 0.
+func (crawler *WebCrawler) scoreLink(e *colly.HTMLElement, depth int) linkScore {
+	if crawler.relevance == nil {
+		return fixedScore(depth, 0)
+	}
+
+	anchor := e.Text + " " + e.Attr("title")
+	parent, _ := e.Request.Ctx.GetAny(pageRelevanceCtxKey).(float64)
+
+	return newLinkScore(
+		crawler.relevance.Similarity(anchor),
+		crawler.relevance.Similarity(surroundingText(e)),
+		parent,
+		depth,
+	)
+}
+
+// surroundingText returns the text of the parent element of e
+// up to contextRunes around the text of e
+func surroundingText(e *colly.HTMLElement) string {
+	text := strings.Join(strings.Fields(e.DOM.Parent().Text()), " ")
+	anchor := strings.Join(strings.Fields(e.Text), " ")
+
+	i := strings.Index(text, anchor)
+	if i < 0 {
+		i, anchor = 0, ""
+	}
+
+	before, after := []rune(text[:i]), []rune(text[i+len(anchor):])
+	if len(before) > contextRunes {
+		before = before[len(before)-contextRunes:]
+	}
+	if len(after) > contextRunes {
+		after = after[:contextRunes]
+	}
+
+	return string(before) + " " + string(after)
+}
+
+// requestScore returns the score a request was enqueued with, 0 if it has none
+func requestScore(r *colly.Request) float64 {
+	if r.Ctx == nil {
+		return 0
+	}
+	score, _ := strconv.ParseFloat(r.Ctx.Get(scoreCtxKey), 64)
+
+	return score
+}
+
+// scoreLog is the file the scores of the enqueued links are written to
+type scoreLog struct {
+	lock   sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func openScoreLog(path string) (*scoreLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &scoreLog{file: file, writer: bufio.NewWriter(file)}
+	if stat, err := file.Stat(); err == nil && stat.Size() == 0 {
+		l.writer.WriteString("url\tdepth\tanchor\tcontext\tparent\tscore\n")
+	}
+
+	return l, nil
+}
+
+func (l *scoreLog) Add(u url, s linkScore) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	_, err := fmt.Fprintf(l.writer, "%s\t%d\t%.3f\t%.3f\t%.3f\t%.4f\n", u, s.depth, s.anchor, s.context, s.parent, s.score)
+
+	return err
+}
+
+func (l *scoreLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	err := l.writer.Flush()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,98 @@
+package crawler
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"поезда", "поезд"},
+		{"поездом", "поезд"},
+		{"ёлки", "елк"},
+		{"мир", "мир"},
+		{"train", "train"},
+		{"trains", "train"},
+	}
+
+	for _, test := range tests {
+		if got := stem(test.word); got != test.want {
+			t.Errorf("stem(%q) = %q, want %q", test.word, got, test.want)
+		}
+	}
+}
+
+func TestRelevanceSimilarity(t *testing.T) {
+	s := newRelevanceScorer([]string{"железная дорога", "поезд"})
+
+	tests := []struct {
+		text string
+		want float64
+	}{
+		{"Расписание поездов", 1.0 / 3},
+		{"Поезда и железные дороги России", 1},
+		{"ЖЕЛЕЗНАЯ дорога, дорога, дорога", 2.0 / 3},
+		{"Погода на завтра", 0},
+		{"", 0},
+	}
+
+	for _, test := range tests {
+		if got := s.Similarity(test.text); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("Similarity(%q) = %v, want %v", test.text, got, test.want)
+		}
+	}
+
+	if got := newRelevanceScorer(nil).Similarity("поезд"); got != 0 {
+		t.Errorf("similarity without keywords is %v", got)
+	}
+}
+
+func TestLinkScore(t *testing.T) {
+	tests := []struct {
+		name                    string
+		anchor, context, parent float64
+		depth                   int
+		want                    float64
+	}{
+		{"all relevant", 1, 1, 1, 0, 1},
+		{"anchor", 1, 0, 0, 0, anchorWeight},
+		{"context", 0, 1, 0, 0, contextWeight},
+		{"parent", 0, 0, 1, 0, parentWeight},
+		{"deeper", 1, 1, 1, 2, depthDecay * depthDecay},
+	}
+
+	for _, test := range tests {
+		if got := newLinkScore(test.anchor, test.context, test.parent, test.depth).score; math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: score %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBestFirstFrontier(t *testing.T) {
+	f, err := openFrontier(t.TempDir(), 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scores := map[url]float64{
+		"http://example.com/low":  0.1,
+		"http://example.com/seed": seedScore,
+		"http://example.com/high": 0.9,
+		"http://example.com/none": 0,
+	}
+	for _, u := range []url{"http://example.com/low", "http://example.com/seed", "http://example.com/high", "http://example.com/none"} {
+		if _, err := f.Push(u, u, 1, scores[u]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"http://example.com/seed", "http://example.com/high", "http://example.com/low", "http://example.com/none"}
+	if got := popURLs(t, f); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
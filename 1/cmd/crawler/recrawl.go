@@ -0,0 +1,201 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+
+	"colly"
+)
+
+const validatorsFileName = "validators.jsonl"
+
+// ChangeTracker is implemented by processors which keep the documents of
+// previous crawls. The crawler tells them about pages which have not changed
+// since they were fetched last time and about pages which no longer exist.
+// Changed pages are passed to Process as usual.
+type ChangeTracker interface {
+	Unchanged(u url)
+	Gone(u url)
+}
+
+// Recrawl makes the crawl fetch again every page fetched by previous runs.
+// Pages which have not changed are not downloaded: the crawler sends the
+// ETag and Last-Modified validators of the previous response and the server
+// answers with 304 Not Modified. It requires StateDir.
+func Recrawl() Option {
+	return func(crawler *WebCrawler) {
+		crawler.recrawl = true
+	}
+}
+
+// validator holds what is needed to ask for a page only if it has changed
+type validator struct {
+	Url          url    `json:"url"`
+	Depth        int    `json:"depth"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Gone marks a page which no longer exists
+	Gone bool `json:"gone,omitempty"`
+}
+
+// validators are the validators of every fetched page. Every change is
+// appended to a file as a line and the last line of a url wins,
+// the file is compacted when it is opened.
+type validators struct {
+	lock  sync.Mutex
+	pages map[url]validator
+	file  *os.File
+}
+
+func newValidators() *validators {
+	return &validators{pages: make(map[url]validator)}
+}
+
+func openValidators(path string) (*validators, error) {
+	v := newValidators()
+
+	file, err := os.Open(path)
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var page validator
+			// a line cut by a crash is skipped
+			if err := json.Unmarshal(scanner.Bytes(), &page); err != nil {
+				continue
+			}
+
+			if page.Gone {
+				delete(v.pages, page.Url)
+			} else {
+				v.pages[page.Url] = page
+			}
+		}
+		file.Close()
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	v.file, err = os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, page := range v.pages {
+		if err := v.write(page); err != nil {
+			v.file.Close()
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+func (v *validators) write(page validator) error {
+	if v.file == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+	_, err = v.file.Write(append(line, '\n'))
+
+	return err
+}
+
+// Get returns the validator of u
+func (v *validators) Get(u url) (validator, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	page, found := v.pages[u]
+
+	return page, found
+}
+
+// Update stores the validators of a response
+func (v *validators) Update(r *colly.Response) error {
+	page := validator{
+		Url:          r.Request.URL.String(),
+		Depth:        r.Request.Depth,
+		ETag:         r.Headers.Get("ETag"),
+		LastModified: r.Headers.Get("Last-Modified"),
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if old, found := v.pages[page.Url]; found && old == page {
+		return nil
+	}
+	v.pages[page.Url] = page
+
+	return v.write(page)
+}
+
+// Remove forgets the page u which no longer exists
+func (v *validators) Remove(u url) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if _, found := v.pages[u]; !found {
+		return nil
+	}
+	delete(v.pages, u)
+
+	return v.write(validator{Url: u, Gone: true})
+}
+
+// Range calls f for every stored page
+func (v *validators) Range(f func(page validator)) {
+	v.lock.Lock()
+	pages := make([]validator, 0, len(v.pages))
+	for _, page := range v.pages {
+		pages = append(pages, page)
+	}
+	v.lock.Unlock()
+
+	for _, page := range pages {
+		f(page)
+	}
+}
+
+func (v *validators) Close() error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.file == nil {
+		return nil
+	}
+
+	err := v.file.Close()
+	v.file = nil
+
+	return err
+}
+
+// setConditionalHeaders makes r ask for the page only if it has
+// changed since the response the validator was taken from
+func setConditionalHeaders(r *colly.Request, page validator) {
+	if page.ETag != "" {
+		r.Headers.Set("If-None-Match", page.ETag)
+	}
+	if page.LastModified != "" {
+		r.Headers.Set("If-Modified-Since", page.LastModified)
+	}
+}
+
+// isGone reports whether status tells that the page no longer exists
+func isGone(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusGone
+}
@@ -0,0 +1,174 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"colly"
+)
+
+func TestValidatorsReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), validatorsFileName)
+	v, err := openValidators(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, page := range []struct{ url, etag string }{
+		{"http://example.com/a", `"1"`},
+		{"http://example.com/b", `"1"`},
+		{"http://example.com/a", `"2"`},
+	} {
+		r := newTestPage(t, http.Header{"Etag": {page.etag}}, "<html></html>").Response
+		r.Request.URL, _ = r.Request.URL.Parse(page.url)
+		if err := v.Update(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := v.Remove("http://example.com/b"); err != nil {
+		t.Fatal(err)
+	}
+	v.Close()
+
+	v, err = openValidators(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+
+	if page, found := v.Get("http://example.com/a"); !found || page.ETag != `"2"` {
+		t.Errorf("got %+v, want the last validator of a", page)
+	}
+	if _, found := v.Get("http://example.com/b"); found {
+		t.Error("a removed page is kept")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); lines++ {
+	}
+	if lines != 1 {
+		t.Errorf("the file has %d lines after it is compacted, want 1", lines)
+	}
+}
+
+func TestSetConditionalHeaders(t *testing.T) {
+	tests := []struct {
+		name              string
+		page              validator
+		ifNoneMatch       string
+		ifModifiedSinceOk bool
+	}{
+		{"etag", validator{ETag: `"abc"`}, `"abc"`, false},
+		{"last modified", validator{LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}, "", true},
+		{"none", validator{}, "", false},
+	}
+
+	for _, test := range tests {
+		r := &colly.Request{Headers: &http.Header{}}
+		setConditionalHeaders(r, test.page)
+		if got := r.Headers.Get("If-None-Match"); got != test.ifNoneMatch {
+			t.Errorf("%s: If-None-Match %q", test.name, got)
+		}
+		if got := r.Headers.Get("If-Modified-Since") != ""; got != test.ifModifiedSinceOk {
+			t.Errorf("%s: If-Modified-Since %q", test.name, r.Headers.Get("If-Modified-Since"))
+		}
+	}
+}
+
+// changeEvents records what the crawler tells a ChangeTracker
+type changeEvents struct {
+	lock      sync.Mutex
+	processed []string
+	unchanged []string
+	gone      []string
+}
+
+func (e *changeEvents) Process(h colly.HTMLElement) error {
+	e.add(&e.processed, h.Request.URL.String())
+	return nil
+}
+
+func (e *changeEvents) Complete() error { return nil }
+
+func (e *changeEvents) Unchanged(u url) { e.add(&e.unchanged, u) }
+
+func (e *changeEvents) Gone(u url) { e.add(&e.gone, u) }
+
+func (e *changeEvents) add(events *[]string, u string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	*events = append(*events, u)
+	slices.Sort(*events)
+}
+
+func TestRecrawl(t *testing.T) {
+	var lock sync.Mutex
+	bStatus := http.StatusOK
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"a1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"a1"`)
+		w.Write([]byte("<html><body>a</body></html>"))
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		status := bStatus
+		lock.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte("<html><body>b</body></html>"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	a, b := ts.URL+"/a", ts.URL+"/b"
+
+	tests := []struct {
+		name      string
+		bStatus   int
+		processed []string
+		unchanged []string
+		gone      []string
+	}{
+		{"first crawl", http.StatusOK, []string{a, b}, nil, nil},
+		{"b is gone", http.StatusNotFound, nil, []string{a}, []string{b}},
+		{"b is back", http.StatusOK, []string{b}, []string{a}, nil},
+	}
+
+	stateDir := t.TempDir()
+	for _, test := range tests {
+		lock.Lock()
+		bStatus = test.bStatus
+		lock.Unlock()
+
+		events := &changeEvents{}
+		crawler, err := New(events, 2, StateDir(stateDir), Recrawl(), RateLimit(2, 0, 0), Retries(RetryPolicy{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = crawler.Crawl(ctx, []url{a, b})
+		cancel()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if !slices.Equal(events.processed, test.processed) || !slices.Equal(events.unchanged, test.unchanged) || !slices.Equal(events.gone, test.gone) {
+			t.Errorf("%s: processed %v, unchanged %v, gone %v", test.name, events.processed, events.unchanged, events.gone)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"colly"
+	"crawler/warc"
+)
+
+// StoredResponse is a response saved by a previous crawl. Url is the url
+// the response came from, OriginalUrl the one requested before the
+// redirects, empty when it is unknown.
+type StoredResponse struct {
+	Url         url
+	OriginalUrl url
+	FetchedAt   time.Time
+	Response    *colly.Response
+}
+
+// CacheDir makes the crawler keep the responses to GET requests in dir,
+// as colly gob files, and answer the requests which are already there from
+// dir. Recrawls should not use it, the cache has no expiration.
+func CacheDir(dir string) Option {
+	return func(crawler *WebCrawler) {
+		crawler.cacheDir = dir
+	}
+}
+
+// WARCResponses returns the response records of the WARC files at paths
+// in the order they were written.
+func WARCResponses(paths ...string) iter.Seq2[StoredResponse, error] {
+	return func(yield func(StoredResponse, error) bool) {
+		for _, path := range paths {
+			if !readWARCResponses(path, yield) {
+				return
+			}
+		}
+	}
+}
+
+// readWARCResponses yields the responses of the file at path,
+// it returns false when yield asks to stop
+func readWARCResponses(path string, yield func(StoredResponse, error) bool) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return yield(StoredResponse{}, err)
+	}
+	defer file.Close()
+
+	reader, err := warc.NewReader(file)
+	if err != nil {
+		return yield(StoredResponse{}, fmt.Errorf("%s: %w", path, err))
+	}
+
+	// originals are the requested urls by the ids of the responses
+	originals := make(map[string]url)
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return true
+		} else if err != nil {
+			// the rest of a broken file can not be found
+			return yield(StoredResponse{}, fmt.Errorf("%s: %w", path, err))
+		}
+
+		if record.Type() == warc.TypeRequest {
+			originals[record.ConcurrentTo()] = record.OriginalURI()
+		}
+		if record.Type() != warc.TypeResponse {
+			continue
+		}
+		original := originals[record.Id()]
+		delete(originals, record.Id())
+
+		resp, body, err := record.HTTPResponse()
+		if err != nil {
+			if !yield(StoredResponse{}, fmt.Errorf("%s %s: %w", path, record.TargetURI(), err)) {
+				return false
+			}
+			continue
+		}
+
+		stored := StoredResponse{
+			Url:         record.TargetURI(),
+			OriginalUrl: original,
+			FetchedAt:   record.Date(),
+			Response: &colly.Response{
+				StatusCode: resp.StatusCode,
+				Body:       body,
+				Headers:    &resp.Header,
+			},
+		}
+		if !yield(stored, nil) {
+			return false
+		}
+	}
+}
+
+// CachedResponses returns the responses to urls from a colly cache dir.
+// The urls which are not in the cache are skipped.
+func CachedResponses(cacheDir string, urls []url) iter.Seq2[StoredResponse, error] {
+	return func(yield func(StoredResponse, error) bool) {
+		for _, u := range urls {
+			resp, err := colly.LoadCachedResponse(cacheDir, u)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				err = fmt.Errorf("%s: %w", u, err)
+			}
+
+			stored := StoredResponse{Url: u, OriginalUrl: u, Response: resp}
+			if err == nil {
+				// the cache keeps no fetch time, the Date header is the closest one
+				stored.FetchedAt, _ = http.ParseTime(resp.Headers.Get("Date"))
+			}
+			if !yield(stored, err) {
+				return
+			}
+		}
+	}
+}
+
+// Replay passes stored responses to the processor the same way Crawl passes
+// the fetched ones, without any network access. Responses which are not
+// successful html pages are skipped and no links are followed.
+func (crawler *WebCrawler) Replay(ctx context.Context, responses iter.Seq2[StoredResponse, error]) error {
+	if err := crawler.startCrawling(); err != nil {
+		return err
+	}
+	defer crawler.setStopFlag()
+
+	// the frontier only tells which canonical pages have been seen
+	seen, err := openFrontier("", 1, false)
+	if err != nil {
+		return err
+	}
+	defer seen.Close()
+
+	c := colly.NewCollector(colly.StdlibContext(ctx), colly.DetectCharset())
+	c.OnRequest(crawler.putRequestKeys)
+	c.OnHTML("html", func(h *colly.HTMLElement) {
+		putRobotsDirectives(h, nil)
+		crawler.process(seen, h)
+	})
+
+	var replayed, failed int
+	for stored, err := range responses {
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Println(err)
+			failed++
+			continue
+		}
+
+		requestCtx := colly.NewContext()
+		requestCtx.Put(colly.FetchedAtCtxKey, stored.FetchedAt)
+		if stored.OriginalUrl != "" {
+			// the page keeps the id and the url of the live crawl, which
+			// come from the url requested before the redirects
+			crawler.putURLKeys(requestCtx, stored.OriginalUrl)
+		}
+		// failed responses come back as errors, they are just skipped
+		if err := c.Replay(stored.Url, 0, requestCtx, stored.Response); err == nil {
+			replayed++
+		}
+	}
+
+	crawler.responseProcessor.Complete()
+	log.Printf("Replay completed: %d responses replayed, %d unreadable.\n", replayed, failed)
+
+	return ctx.Err()
+}
@@ -0,0 +1,80 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"colly"
+	"crawler/warc"
+)
+
+// documentUrls records the url keys of the processed pages
+type documentUrls struct {
+	originals  []string
+	canonicals []string
+}
+
+func (d *documentUrls) Process(e colly.HTMLElement) error {
+	d.originals = append(d.originals, e.Request.Ctx.Get(colly.OriginalURLCtxKey))
+	d.canonicals = append(d.canonicals, e.Request.Ctx.Get(colly.CanonicalURLCtxKey))
+	return nil
+}
+
+func (d *documentUrls) Complete() error {
+	return nil
+}
+
+func TestReplayKeepsOriginalURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusFound)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>new</body></html>"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	writer, err := warc.NewWriter(dir, "crawl", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := colly.NewCollector()
+	c.WithTransport(warc.NewTransport(nil, writer))
+	live := &documentUrls{}
+	crawler := &WebCrawler{canonicalizer: canonicalizer{trackingParams: DefaultTrackingParams}}
+	c.OnRequest(crawler.putRequestKeys)
+	c.OnHTML("html", func(h *colly.HTMLElement) {
+		live.Process(*h)
+	})
+	if err := c.Visit(ts.URL + "/old/?utm_source=x"); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	replayed := &documentUrls{}
+	replayer, err := New(replayed, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"+warc.FileExtension))
+	if err := replayer.Replay(context.Background(), WARCResponses(paths...)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(live.originals) != 1 || len(replayed.originals) != 1 {
+		t.Fatalf("expected one page, got %v live and %v replayed", live.originals, replayed.originals)
+	}
+	if replayed.originals[0] != live.originals[0] || replayed.canonicals[0] != live.canonicals[0] {
+		t.Errorf("replay got %s %s, the live crawl %s %s", replayed.originals[0], replayed.canonicals[0],
+			live.originals[0], live.canonicals[0])
+	}
+	if live.canonicals[0] != ts.URL+"/old" {
+		t.Errorf("unexpected canonical url %s", live.canonicals[0])
+	}
+}
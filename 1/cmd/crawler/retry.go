@@ -0,0 +1,233 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"colly"
+)
+
+const (
+	deadLettersFileName = "dead_letters.jsonl"
+	attemptCtxKey       = "crawler.attempt"
+)
+
+// RetryPolicy tells which failed requests are tried again and when.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, including the first one.
+	// Values below 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles with
+	// every following retry up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter is the fraction of the delay which is randomized, in [0, 1].
+	Jitter float64
+	// RetryableStatusCodes are the response statuses which are retried.
+	RetryableStatusCodes []int
+	// IsRetryableError reports whether a request which failed without a
+	// response is retried. IsTransientError is used when it is nil.
+	IsRetryableError func(err error) bool
+}
+
+// DefaultRetryPolicy retries timeouts, dropped connections
+// and the usual transient statuses twice.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   2 * time.Second,
+	MaxDelay:    30 * time.Second,
+	Jitter:      0.5,
+	RetryableStatusCodes: []int{
+		http.StatusRequestTimeout,
+		http.StatusTooEarly,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+}
+
+// Retries replaces DefaultRetryPolicy with policy.
+func Retries(policy RetryPolicy) Option {
+	return func(crawler *WebCrawler) {
+		crawler.retryPolicy = policy
+	}
+}
+
+// RequeueDeadLetters makes the crawl start with the requests which were
+// given up by previous runs. It requires StateDir.
+func RequeueDeadLetters() Option {
+	return func(crawler *WebCrawler) {
+		crawler.requeueDeadLetters = true
+	}
+}
+
+// IsTransientError reports whether err is a timeout or a dropped connection.
+func IsTransientError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// IsRetryable reports whether the request of r, which failed with err, is retried.
+func (p RetryPolicy) IsRetryable(r *colly.Response, err error) bool {
+	if r.StatusCode != 0 {
+		for _, code := range p.RetryableStatusCodes {
+			if r.StatusCode == code {
+				return true
+			}
+		}
+		return false
+	}
+
+	if p.IsRetryableError != nil {
+		return p.IsRetryableError(err)
+	}
+
+	return IsTransientError(err)
+}
+
+// Backoff returns the delay before the given retry, counted from 1.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 {
+		delay = min(delay, p.MaxDelay)
+	}
+
+	jitter := time.Duration(float64(delay) * p.Jitter * rand.Float64())
+
+	return delay - time.Duration(float64(delay)*p.Jitter/2) + jitter
+}
+
+// attempts returns the number of times the request of r has been sent
+func attempts(r *colly.Request) int {
+	attempt, err := strconv.Atoi(r.Ctx.Get(attemptCtxKey))
+	if err != nil {
+		return 1
+	}
+
+	return attempt
+}
+
+// deadLetter is a request which failed with all of its attempts
+type deadLetter struct {
+	Url      url       `json:"url"`
+	Depth    int       `json:"depth"`
+	Attempts int       `json:"attempts"`
+	Status   int       `json:"status,omitempty"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// deadLetters is the file the given up requests are appended to
+type deadLetters struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+func openDeadLetters(path string) (*deadLetters, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deadLetters{file: file}, nil
+}
+
+func (d *deadLetters) Add(r *colly.Response, err error) error {
+	line, jsonErr := json.Marshal(deadLetter{
+		Url:      r.Request.URL.String(),
+		Depth:    r.Request.Depth,
+		Attempts: attempts(r.Request),
+		Status:   r.StatusCode,
+		Error:    err.Error(),
+		FailedAt: time.Now(),
+	})
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	_, writeErr := d.file.Write(append(line, '\n'))
+
+	return writeErr
+}
+
+func (d *deadLetters) Close() error {
+	return d.file.Close()
+}
+
+// requeueDeadLetters puts the requests from the dead letters file at path
+// back into the frontier and removes the file. It returns the number of
+// requeued requests.
+func requeueDeadLetters(f *frontier, path string) (int, error) {
+	letters, err := readDeadLetters(path)
+	if err != nil || len(letters) == 0 {
+		return 0, err
+	}
+
+	for _, letter := range letters {
+		u, err := neturl.Parse(letter.Url)
+		if err != nil {
+			continue
+		}
+
+		err = f.Requeue(&colly.Request{URL: u, Method: "GET", Depth: letter.Depth})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(letters), os.Remove(path)
+}
+
+func readDeadLetters(path string) ([]deadLetter, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var letters []deadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var letter deadLetter
+		// a line cut by a crash is skipped
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err == nil {
+			letters = append(letters, letter)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return letters, nil
+}
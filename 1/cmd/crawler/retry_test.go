@@ -0,0 +1,119 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"colly"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got := policy.Backoff(test.retry); got != test.want {
+			t.Errorf("Backoff(%d) = %v, want %v", test.retry, got, test.want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for range 100 {
+		if got := policy.Backoff(3); got < 3*time.Second || got > 5*time.Second {
+			t.Fatalf("Backoff(3) with jitter 0.5 = %v, want it in [3s, 5s]", got)
+		}
+	}
+}
+
+func TestRetryPolicyIsRetryable(t *testing.T) {
+	timeout := &net.OpError{Op: "dial", Err: context.DeadlineExceeded}
+	custom := errors.New("custom")
+
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		status int
+		err    error
+		want   bool
+	}{
+		{"503", DefaultRetryPolicy, http.StatusServiceUnavailable, errors.New("Service Unavailable"), true},
+		{"429", DefaultRetryPolicy, http.StatusTooManyRequests, errors.New("Too Many Requests"), true},
+		{"404", DefaultRetryPolicy, http.StatusNotFound, errors.New("Not Found"), false},
+		{"timeout", DefaultRetryPolicy, 0, timeout, true},
+		{"reset", DefaultRetryPolicy, 0, fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"unexpected eof", DefaultRetryPolicy, 0, io.ErrUnexpectedEOF, true},
+		{"dns", DefaultRetryPolicy, 0, &net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{"custom error", RetryPolicy{IsRetryableError: func(err error) bool { return err == custom }}, 0, custom, true},
+		{"custom timeout", RetryPolicy{IsRetryableError: func(err error) bool { return err == custom }}, 0, timeout, false},
+	}
+
+	for _, test := range tests {
+		r := &colly.Response{StatusCode: test.status}
+		if got := test.policy.IsRetryable(r, test.err); got != test.want {
+			t.Errorf("%s: IsRetryable = %v", test.name, got)
+		}
+	}
+}
+
+func TestDeadLettersRequeue(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, deadLettersFileName)
+	letters, err := openDeadLetters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []string{"http://example.com/a", "http://example.com/b"} {
+		parsed, _ := neturl.Parse(u)
+		ctx := colly.NewContext()
+		ctx.Put(attemptCtxKey, "3")
+		r := &colly.Response{StatusCode: http.StatusBadGateway, Request: &colly.Request{URL: parsed, Depth: 2, Ctx: ctx}}
+		if err := letters.Add(r, errors.New("Bad Gateway")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	letters.Close()
+
+	read, err := readDeadLetters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 2 || read[0].Attempts != 3 || read[0].Depth != 2 || read[0].Status != http.StatusBadGateway {
+		t.Fatalf("unexpected dead letters %+v", read)
+	}
+
+	f, err := openFrontier(filepath.Join(dir, "frontier"), 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n, err := requeueDeadLetters(f, path)
+	if err != nil || n != 2 {
+		t.Fatalf("requeued %d: %v", n, err)
+	}
+	if size, _ := f.queue.Size(); size != 2 {
+		t.Errorf("queue size %d after requeueing", size)
+	}
+	if read, _ := readDeadLetters(path); len(read) != 0 {
+		t.Errorf("dead letters are kept after requeueing: %v", read)
+	}
+}
@@ -0,0 +1,124 @@
+package crawler
+
+import (
+	"net/http"
+	"strings"
+
+	"colly"
+)
+
+// robotsCtxKey holds the robotsDirectives of the page
+const robotsCtxKey = "crawler.robots"
+
+// RobotsDirectives selects which of the robots directives of the pages
+// are honored. The pages and links which carry them are counted in the
+// metrics either way, so a crawl which ignores them shows how many
+// pages they would affect.
+type RobotsDirectives struct {
+	// NoIndex skips the processing of the pages with a noindex
+	// meta robots tag or X-Robots-Tag header.
+	NoIndex bool
+	// NoFollow skips the links of the pages with a nofollow
+	// meta robots tag or X-Robots-Tag header.
+	NoFollow bool
+	// RelNoFollow skips the links with rel="nofollow".
+	RelNoFollow bool
+	// RobotsTxt honors the Disallow rules and the Crawl-delay of the
+	// robots.txt of every host. The crawler has always ignored robots.txt,
+	// so it is off by default.
+	RobotsTxt bool
+	// CrawlDelay honors the Crawl-delay of the robots.txt of every host
+	// even if its Disallow rules are ignored.
+	CrawlDelay bool
+}
+
+// DefaultRobotsDirectives honors every directive of the pages and the
+// Crawl-delay of robots.txt, and ignores the Disallow rules of robots.txt.
+var DefaultRobotsDirectives = RobotsDirectives{NoIndex: true, NoFollow: true, RelNoFollow: true, CrawlDelay: true}
+
+// HonorRobots replaces DefaultRobotsDirectives with directives.
+func HonorRobots(directives RobotsDirectives) Option {
+	return func(crawler *WebCrawler) {
+		crawler.robots = directives
+	}
+}
+
+// robotsDirectives are the directives a page carries
+type robotsDirectives struct {
+	noIndex  bool
+	noFollow bool
+}
+
+// parse adds the comma separated directives of value to d
+func (d *robotsDirectives) parse(value string) {
+	for _, directive := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "noindex":
+			d.noIndex = true
+		case "nofollow":
+			d.noFollow = true
+		case "none":
+			d.noIndex, d.noFollow = true, true
+		}
+	}
+}
+
+// pageRobotsDirectives reads the directives of the X-Robots-Tag headers and
+// of the meta robots tags of the page h. The directives addressed to
+// a specific crawler, like "googlebot: noindex", are not for this one.
+func pageRobotsDirectives(headers *http.Header, h *colly.HTMLElement) robotsDirectives {
+	var d robotsDirectives
+	if headers != nil {
+		for _, value := range headers.Values("X-Robots-Tag") {
+			// "unavailable_after: date" looks the same as an agent,
+			// but it is not one of the directives which are honored
+			if agent, _, found := strings.Cut(value, ":"); found && !strings.Contains(agent, ",") {
+				continue
+			}
+			d.parse(value)
+		}
+	}
+
+	h.ForEach("meta[name]", func(_ int, meta *colly.HTMLElement) {
+		if strings.EqualFold(strings.TrimSpace(meta.Attr("name")), "robots") {
+			d.parse(meta.Attr("content"))
+		}
+	})
+
+	return d
+}
+
+// putRobotsDirectives puts the directives of the page h into its context
+// and counts them. stats may be nil.
+func putRobotsDirectives(h *colly.HTMLElement, stats *metrics) {
+	d := pageRobotsDirectives(h.Response.Headers, h)
+	h.Request.Ctx.Put(robotsCtxKey, d)
+
+	if stats == nil {
+		return
+	}
+	if d.noIndex {
+		stats.noIndexPages.Add(1)
+	}
+	if d.noFollow {
+		stats.noFollowPages.Add(1)
+	}
+}
+
+// pageRobots returns the directives put into the context of the request
+func pageRobots(r *colly.Request) robotsDirectives {
+	d, _ := r.Ctx.GetAny(robotsCtxKey).(robotsDirectives)
+
+	return d
+}
+
+// isRelNoFollow tells whether the rel attribute of the link e has nofollow
+func isRelNoFollow(e *colly.HTMLElement) bool {
+	for _, rel := range strings.Fields(e.Attr("rel")) {
+		if strings.EqualFold(rel, "nofollow") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,155 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"colly"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// newTestPage returns the html element of a page with the headers
+func newTestPage(t *testing.T, headers http.Header, body string) *colly.HTMLElement {
+	u, _ := neturl.Parse("http://example.com/")
+	ctx := colly.NewContext()
+	response := &colly.Response{
+		StatusCode: http.StatusOK,
+		Body:       []byte(body),
+		Headers:    &headers,
+		Ctx:        ctx,
+		Request:    &colly.Request{URL: u, Ctx: ctx, Method: "GET"},
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	selection := doc.Find("html")
+
+	return colly.NewHTMLElementFromSelectionNode(response, selection, selection.Nodes[0], 0)
+}
+
+func TestPageRobotsDirectives(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   []string
+		meta     string
+		noIndex  bool
+		noFollow bool
+	}{
+		{"none", nil, "", false, false},
+		{"meta noindex", nil, `<meta name="robots" content="noindex">`, true, false},
+		{"meta case", nil, `<meta name=" Robots " content="NoFollow, NOARCHIVE">`, false, true},
+		{"meta none", nil, `<meta name="robots" content="none">`, true, true},
+		{"other meta", nil, `<meta name="description" content="noindex">`, false, false},
+		{"header", []string{"noindex, nofollow"}, "", true, true},
+		{"headers", []string{"noindex", "nofollow"}, "", true, true},
+		{"other crawler", []string{"googlebot: noindex"}, "", false, false},
+		{"unavailable_after", []string{"noindex, unavailable_after: 25 Jun 2010 15:00:00 PST"}, "", true, false},
+		{"header and meta", []string{"nofollow"}, `<meta name="robots" content="noindex">`, true, true},
+	}
+
+	for _, test := range tests {
+		headers := http.Header{}
+		for _, value := range test.header {
+			headers.Add("X-Robots-Tag", value)
+		}
+		page := newTestPage(t, headers, "<html><head>"+test.meta+"</head><body></body></html>")
+
+		d := pageRobotsDirectives(&headers, page)
+		if d.noIndex != test.noIndex || d.noFollow != test.noFollow {
+			t.Errorf("%s: got %+v", test.name, d)
+		}
+	}
+}
+
+func TestPutRobotsDirectives(t *testing.T) {
+	stats := &metrics{}
+	page := newTestPage(t, http.Header{"X-Robots-Tag": {"none"}}, "<html><body></body></html>")
+
+	putRobotsDirectives(page, stats)
+
+	if d := pageRobots(page.Request); !d.noIndex || !d.noFollow {
+		t.Errorf("the request context has %+v", d)
+	}
+	if stats.noIndexPages.Load() != 1 || stats.noFollowPages.Load() != 1 {
+		t.Error("the directives are not counted")
+	}
+}
+
+func TestIsRelNoFollow(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"", false},
+		{"nofollow", true},
+		{"noopener NoFollow", true},
+		{"nofollower", false},
+	}
+
+	for _, test := range tests {
+		page := newTestPage(t, http.Header{}, `<html><body><a href="/" rel="`+test.rel+`">link</a></body></html>`)
+		var got bool
+		page.ForEach("a", func(_ int, a *colly.HTMLElement) {
+			got = isRelNoFollow(a)
+		})
+		if got != test.want {
+			t.Errorf("isRelNoFollow(rel=%q) = %v", test.rel, got)
+		}
+	}
+}
+
+func TestCrawlReadsCrawlDelay(t *testing.T) {
+	var robotsRequests atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		robotsRequests.Add(1)
+		w.Write([]byte("User-agent: *\nDisallow: /\nCrawl-delay: 1\n"))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>page</body></html>"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		directives     RobotsDirectives
+		processed      int
+		robotsRequests int32
+	}{
+		{"defaults", DefaultRobotsDirectives, 1, 1},
+		{"robots.txt", RobotsDirectives{RobotsTxt: true}, 0, 1},
+		{"nothing", RobotsDirectives{}, 1, 0},
+	}
+
+	for _, test := range tests {
+		robotsRequests.Store(0)
+		events := &changeEvents{}
+		crawler, err := New(events, 1, HonorRobots(test.directives), RateLimit(0, 0, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = crawler.Crawl(ctx, []url{ts.URL + "/"})
+		cancel()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if len(events.processed) != test.processed {
+			t.Errorf("%s: processed %v", test.name, events.processed)
+		}
+		if n := robotsRequests.Load(); n != test.robotsRequests {
+			t.Errorf("%s: robots.txt requested %d times", test.name, n)
+		}
+	}
+}
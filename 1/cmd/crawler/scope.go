@@ -0,0 +1,155 @@
+package crawler
+
+import (
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Scope limits the part of the web which is crawled.
+// The zero Scope follows every http(s) link.
+type Scope struct {
+	// MaxDepth is the maximum number of links between an entry url and
+	// a crawled page. 0 means no limit.
+	MaxDepth int
+	// SameHost restricts the crawl to the hosts of the entry urls.
+	SameHost bool
+	// AllowedDomains restricts the crawl to the listed domains and their subdomains.
+	AllowedDomains []string
+	// Include, when not empty, requires a url to match at least one expression.
+	Include []*regexp.Regexp
+	// Exclude drops the urls which match any expression.
+	// Exclude is evaluated before Include.
+	Exclude []*regexp.Regexp
+	// MaxPagesPerHost limits the number of pages enqueued from every host.
+	// 0 means no limit.
+	MaxPagesPerHost int
+}
+
+// WithScope restricts the crawl to scope.
+func WithScope(scope Scope) Option {
+	return func(crawler *WebCrawler) {
+		crawler.scope = scope
+	}
+}
+
+// TargetDocuments stops the crawl once the processor has accepted n documents.
+// The processor has to implement AcceptedCounter.
+func TargetDocuments(n int64) Option {
+	return func(crawler *WebCrawler) {
+		crawler.targetDocuments = n
+	}
+}
+
+// AcceptedCounter is implemented by processors which report how many
+// of the processed pages have been accepted as documents.
+type AcceptedCounter interface {
+	Accepted() int64
+}
+
+// scopeChecker applies a Scope to the urls found during a crawl.
+type scopeChecker struct {
+	scope      Scope
+	entryHosts map[string]struct{}
+
+	lock         sync.Mutex
+	pagesPerHost map[string]int
+}
+
+func newScopeChecker(scope Scope, entryUrls []url) *scopeChecker {
+	checker := &scopeChecker{
+		scope:        scope,
+		entryHosts:   make(map[string]struct{}, len(entryUrls)),
+		pagesPerHost: make(map[string]int),
+	}
+
+	for _, u := range entryUrls {
+		if parsed, err := neturl.Parse(u); err == nil {
+			checker.entryHosts[strings.ToLower(parsed.Host)] = struct{}{}
+		}
+	}
+
+	return checker
+}
+
+// Allows reports whether u found depth links away from the entry urls
+// should be crawled. Budgets are not checked.
+func (s *scopeChecker) Allows(u *neturl.URL, depth int) bool {
+	if s.scope.MaxDepth > 0 && depth > s.scope.MaxDepth {
+		return false
+	}
+
+	if s.scope.SameHost {
+		if _, isEntryHost := s.entryHosts[u.Host]; !isEntryHost {
+			return false
+		}
+	}
+
+	if len(s.scope.AllowedDomains) > 0 && !isInDomains(u.Hostname(), s.scope.AllowedDomains) {
+		return false
+	}
+
+	href := u.String()
+	for _, exclude := range s.scope.Exclude {
+		if exclude.MatchString(href) {
+			return false
+		}
+	}
+
+	if len(s.scope.Include) == 0 {
+		return true
+	}
+	for _, include := range s.scope.Include {
+		if include.MatchString(href) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reserve takes one page of the host budget. The page has to be
+// returned with Release if it is not enqueued after all.
+func (s *scopeChecker) Reserve(host string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.scope.MaxPagesPerHost > 0 && s.pagesPerHost[host] >= s.scope.MaxPagesPerHost {
+		return false
+	}
+	s.pagesPerHost[host]++
+
+	return true
+}
+
+func (s *scopeChecker) Release(host string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pagesPerHost[host]--
+}
+
+// countSeen charges the host budgets with the urls enqueued by previous runs.
+// The urls which have only been marked as seen were not reserved.
+func (s *scopeChecker) countSeen(f *frontier) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	f.seen.RangeEnqueued(func(u url) {
+		if parsed, err := neturl.Parse(u); err == nil {
+			s.pagesPerHost[parsed.Host]++
+		}
+	})
+}
+
+func isInDomains(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
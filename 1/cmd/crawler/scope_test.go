@@ -0,0 +1,110 @@
+package crawler
+
+import (
+	neturl "net/url"
+	"regexp"
+	"testing"
+)
+
+func TestScopeAllows(t *testing.T) {
+	entryUrls := []url{"http://example.com/", "https://Blog.example.org/start"}
+
+	tests := []struct {
+		name  string
+		scope Scope
+		url   string
+		depth int
+		want  bool
+	}{
+		{"zero scope", Scope{}, "http://other.net/a", 100, true},
+		{"depth", Scope{MaxDepth: 2}, "http://example.com/a", 2, true},
+		{"too deep", Scope{MaxDepth: 2}, "http://example.com/a", 3, false},
+		{"same host", Scope{SameHost: true}, "http://example.com/a", 1, true},
+		{"same host, lowercased", Scope{SameHost: true}, "https://blog.example.org/a", 1, true},
+		{"other host", Scope{SameHost: true}, "http://www.example.com/a", 1, false},
+		{"domain", Scope{AllowedDomains: []string{"example.com"}}, "http://example.com/a", 1, true},
+		{"subdomain", Scope{AllowedDomains: []string{".example.com"}}, "http://www.example.com/a", 1, true},
+		{"domain suffix", Scope{AllowedDomains: []string{"example.com"}}, "http://badexample.com/a", 1, false},
+		{"include", Scope{Include: []*regexp.Regexp{regexp.MustCompile("/wiki/")}}, "http://example.com/wiki/a", 1, true},
+		{"not included", Scope{Include: []*regexp.Regexp{regexp.MustCompile("/wiki/")}}, "http://example.com/blog/a", 1, false},
+		{"excluded", Scope{Exclude: []*regexp.Regexp{regexp.MustCompile("action=")}}, "http://example.com/a?action=edit", 1, false},
+		{"exclude before include", Scope{
+			Include: []*regexp.Regexp{regexp.MustCompile("/wiki/")},
+			Exclude: []*regexp.Regexp{regexp.MustCompile("Special:")},
+		}, "http://example.com/wiki/Special:Random", 1, false},
+	}
+
+	for _, test := range tests {
+		u, err := neturl.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checker := newScopeChecker(test.scope, entryUrls)
+		if got := checker.Allows(u, test.depth); got != test.want {
+			t.Errorf("%s: Allows(%s, %d) = %v", test.name, test.url, test.depth, got)
+		}
+	}
+}
+
+func TestScopeHostBudget(t *testing.T) {
+	checker := newScopeChecker(Scope{MaxPagesPerHost: 2}, nil)
+
+	tests := []struct {
+		name    string
+		host    string
+		release bool
+		want    bool
+	}{
+		{"first", "example.com", false, true},
+		{"second", "example.com", false, true},
+		{"over budget", "example.com", false, false},
+		{"other host", "example.org", false, true},
+		{"released", "example.com", true, true},
+	}
+
+	for _, test := range tests {
+		if test.release {
+			checker.Release(test.host)
+		}
+		if got := checker.Reserve(test.host); got != test.want {
+			t.Errorf("%s: Reserve(%s) = %v", test.name, test.host, got)
+		}
+	}
+}
+
+func TestScopeCountsSeenUrls(t *testing.T) {
+	dir := t.TempDir()
+	f, err := openFrontier(dir, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []url{"http://example.com/a", "http://example.com/b"} {
+		if _, err := f.Push(u, u, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// redirect targets and declared canonicals are not reserved
+	for _, u := range []url{"http://example.com/c", "http://example.com/d"} {
+		if _, err := f.MarkSeen(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = openFrontier(dir, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if !f.Seen("http://example.com/c") {
+		t.Error("a marked url is not seen after a restart")
+	}
+
+	checker := newScopeChecker(Scope{MaxPagesPerHost: 3}, nil)
+	checker.countSeen(f)
+	if !checker.Reserve("example.com") || checker.Reserve("example.com") {
+		t.Error("the enqueued urls of the previous runs are not charged to the host budget alone")
+	}
+}
@@ -0,0 +1,129 @@
+package crawler
+
+import (
+	"log"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"colly"
+
+	"github.com/temoto/robotstxt"
+)
+
+// lastmodLayouts are the W3C Datetime variants allowed in sitemap lastmod
+var lastmodLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// Sitemaps makes the crawler discover pages from sitemaps before following
+// links. For every seed host the Sitemap directives of /robots.txt are read,
+// /sitemap.xml is used when there are none. Sitemap indexes and gzipped
+// sitemaps are followed. Urls with a lastmod before modifiedSince are
+// skipped, a zero modifiedSince takes every url.
+func Sitemaps(modifiedSince time.Time) Option {
+	return func(crawler *WebCrawler) {
+		crawler.useSitemaps = true
+		crawler.sitemapsModifiedSince = modifiedSince
+	}
+}
+
+// discoverSitemaps synchronously walks the sitemaps of the seed hosts and
+// passes every found page url to push.
+func (crawler *WebCrawler) discoverSitemaps(c *colly.Collector, entryUrls []url, push func(url)) {
+	sc := c.Clone()
+
+	var sitemaps []url
+	sc.OnResponse(func(r *colly.Response) {
+		if r.Request.URL.Path != "/robots.txt" {
+			return
+		}
+
+		robots, err := robotstxt.FromStatusAndBytes(r.StatusCode, r.Body)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		sitemaps = append(sitemaps, robots.Sitemaps...)
+	})
+
+	sc.OnXML("//sitemapindex/sitemap", func(e *colly.XMLElement) {
+		if !crawler.isModifiedSince(e.ChildText("lastmod")) {
+			return
+		}
+
+		if loc := e.Request.AbsoluteURL(strings.TrimSpace(e.ChildText("loc"))); loc != "" {
+			sc.Visit(loc)
+		}
+	})
+
+	sc.OnXML("//urlset/url", func(e *colly.XMLElement) {
+		if !crawler.isModifiedSince(e.ChildText("lastmod")) {
+			return
+		}
+
+		if loc := e.Request.AbsoluteURL(strings.TrimSpace(e.ChildText("loc"))); loc != "" {
+			push(loc)
+		}
+	})
+
+	sc.OnError(func(r *colly.Response, err error) {
+		if r.StatusCode != http.StatusNotFound {
+			log.Println(r.Request.URL, err)
+		}
+	})
+
+	for _, root := range siteRoots(entryUrls) {
+		sitemaps = sitemaps[:0]
+		sc.Visit(root + "/robots.txt")
+
+		if len(sitemaps) == 0 {
+			sitemaps = append(sitemaps, root+"/sitemap.xml")
+		}
+
+		for _, sitemap := range sitemaps {
+			sc.Visit(sitemap)
+		}
+	}
+}
+
+func (crawler *WebCrawler) isModifiedSince(lastmod string) bool {
+	lastmod = strings.TrimSpace(lastmod)
+	if crawler.sitemapsModifiedSince.IsZero() || lastmod == "" {
+		return true
+	}
+
+	for _, layout := range lastmodLayouts {
+		if t, err := time.Parse(layout, lastmod); err == nil {
+			return !t.Before(crawler.sitemapsModifiedSince)
+		}
+	}
+
+	return true
+}
+
+// siteRoots returns the distinct scheme://host prefixes of urls
+func siteRoots(urls []url) []url {
+	roots := make([]url, 0, len(urls))
+	known := make(map[url]struct{}, len(urls))
+
+	for _, u := range urls {
+		parsed, err := neturl.Parse(u)
+		if err != nil || parsed.Host == "" {
+			continue
+		}
+
+		root := parsed.Scheme + "://" + parsed.Host
+		if _, exists := known[root]; !exists {
+			known[root] = struct{}{}
+			roots = append(roots, root)
+		}
+	}
+
+	return roots
+}
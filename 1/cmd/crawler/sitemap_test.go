@@ -0,0 +1,117 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"colly"
+)
+
+func TestIsModifiedSince(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		lastmod string
+		since   time.Time
+		want    bool
+	}{
+		{"2024-06-01T10:00:00+02:00", since, true},
+		{"2023-12-31T23:59Z", since, false},
+		{"2024-01-01", since, true},
+		{"2023-12", since, false},
+		{"2023", since, false},
+		{" 2025 ", since, true},
+		{"", since, true},
+		{"yesterday", since, true},
+		{"2020-01-01", time.Time{}, true},
+	}
+
+	for _, test := range tests {
+		crawler := &WebCrawler{}
+		Sitemaps(test.since)(crawler)
+		if got := crawler.isModifiedSince(test.lastmod); got != test.want {
+			t.Errorf("isModifiedSince(%q) = %v", test.lastmod, got)
+		}
+	}
+}
+
+func TestSiteRoots(t *testing.T) {
+	got := siteRoots([]url{
+		"http://example.com/a",
+		"http://example.com/b?c=d",
+		"https://example.com/",
+		"http://example.org:8080/",
+		"/relative",
+		"://bad",
+	})
+	want := []url{"http://example.com", "https://example.com", "http://example.org:8080"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func serveXML(w http.ResponseWriter, format string, args ...any) {
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`+format, args...)
+}
+
+func TestDiscoverSitemaps(t *testing.T) {
+	var ts *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "User-agent: *\nDisallow:\nSitemap: %s/index.xml\n", ts.URL)
+	})
+	mux.HandleFunc("/index.xml", func(w http.ResponseWriter, r *http.Request) {
+		serveXML(w, `<sitemapindex>
+<sitemap><loc>%[1]s/old.xml</loc><lastmod>2020-01-01</lastmod></sitemap>
+<sitemap><loc>%[1]s/pages.xml.gz</loc><lastmod>2024-05-01</lastmod></sitemap>
+</sitemapindex>`, ts.URL)
+	})
+	mux.HandleFunc("/old.xml", func(w http.ResponseWriter, r *http.Request) {
+		serveXML(w, `<urlset><url><loc>%s/old</loc></url></urlset>`, ts.URL)
+	})
+	mux.HandleFunc("/pages.xml.gz", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		fmt.Fprintf(gz, `<?xml version="1.0" encoding="UTF-8"?><urlset>
+<url><loc> %[1]s/a </loc><lastmod>2024-06-01</lastmod></url>
+<url><loc>%[1]s/b</loc><lastmod>2023-01-01</lastmod></url>
+<url><loc>%[1]s/c</loc></url>
+</urlset>`, ts.URL)
+		gz.Close()
+		w.Header().Set("Content-Type", "application/x-gzip")
+		w.Write(buf.Bytes())
+	})
+	ts = httptest.NewServer(mux)
+	defer ts.Close()
+
+	// a site without robots.txt falls back to /sitemap.xml
+	var other *httptest.Server
+	other = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sitemap.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		serveXML(w, `<urlset><url><loc>%s/d</loc></url></urlset>`, other.URL)
+	}))
+	defer other.Close()
+
+	crawler := &WebCrawler{}
+	Sitemaps(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))(crawler)
+
+	var found []url
+	crawler.discoverSitemaps(colly.NewCollector(), []url{ts.URL + "/", ts.URL + "/x", other.URL + "/"}, func(href url) {
+		found = append(found, href)
+	})
+
+	want := []url{ts.URL + "/a", ts.URL + "/c", other.URL + "/d"}
+	if !slices.Equal(found, want) {
+		t.Errorf("got %v, want %v", found, want)
+	}
+}
@@ -0,0 +1,290 @@
+package crawler
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	neturl "net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Rules of the trap detector, they label the trapped urls in the report.
+const (
+	trapURLLength         = "url_length"
+	trapSegmentRepeats    = "segment_repeats"
+	trapParamCombinations = "param_combinations"
+	trapTemplateURLs      = "template_urls"
+)
+
+// TrapRules stop the crawl from going down url spaces which never end,
+// like calendars, faceted search, session ids and /a/b/a/b/a/b loops.
+// They apply to the links found on pages. A zero field disables its check.
+type TrapRules struct {
+	// MaxURLLength drops the urls which are longer than it.
+	MaxURLLength int
+	// MaxSegmentRepeats drops the urls with a path segment which
+	// occurs more than this many times.
+	MaxSegmentRepeats int
+	// MaxParamCombinations limits the number of distinct combinations of
+	// query parameter names of a host, which faceted search multiplies.
+	MaxParamCombinations int
+	// MaxTemplateURLs limits the number of urls which differ only in numbers,
+	// long ids and query values, like the days of a calendar or the same
+	// page with different session ids.
+	MaxTemplateURLs int
+}
+
+// DefaultTrapRules are generous enough for the sites which are not traps.
+var DefaultTrapRules = TrapRules{
+	MaxURLLength:         1024,
+	MaxSegmentRepeats:    2,
+	MaxParamCombinations: 100,
+	MaxTemplateURLs:      5000,
+}
+
+// Traps replaces DefaultTrapRules with rules.
+func Traps(rules TrapRules) Option {
+	return func(crawler *WebCrawler) {
+		crawler.trapRules = rules
+	}
+}
+
+// TrapReport makes the crawler write the trapped patterns to the file at
+// path when the crawl ends, one "rule\tpattern\tskipped" line per pattern.
+func TrapReport(path string) Option {
+	return func(crawler *WebCrawler) {
+		crawler.trapReportPath = path
+	}
+}
+
+// idToken matches the parts of a path which are numbers or look like ids,
+// e.g. hex session ids. Dashes are not a part of an id, so the slugs of
+// articles stay as they are.
+var idToken = regexp.MustCompile(`[0-9A-Za-z]{16,}|[0-9]+`)
+
+// trap is a pattern of urls which hit a rule
+type trap struct {
+	rule    string
+	pattern string
+}
+
+// trapDetector counts the urls which have been enqueued by their patterns.
+// Like the host budgets of scopeChecker, a url is counted by Admit and
+// has to be returned with Release if it is not enqueued after all.
+type trapDetector struct {
+	rules TrapRules
+
+	lock              sync.Mutex
+	paramCombinations map[string]map[string]int
+	templateURLs      map[string]int
+	trapped           map[trap]int
+}
+
+func newTrapDetector(rules TrapRules) *trapDetector {
+	return &trapDetector{
+		rules:             rules,
+		paramCombinations: make(map[string]map[string]int),
+		templateURLs:      make(map[string]int),
+		trapped:           make(map[trap]int),
+	}
+}
+
+// Admit reports whether u is not in a trap and counts it if it is not.
+// The first url of a trapped pattern is logged.
+func (d *trapDetector) Admit(u *neturl.URL) bool {
+	t, trapped := d.check(u)
+	if !trapped {
+		return true
+	}
+
+	d.lock.Lock()
+	d.trapped[t]++
+	first := d.trapped[t] == 1
+	d.lock.Unlock()
+
+	if first {
+		log.Printf("trap %s: %s, its urls are skipped\n", t.rule, t.pattern)
+	}
+
+	return false
+}
+
+func (d *trapDetector) check(u *neturl.URL) (trap, bool) {
+	href := u.String()
+	if d.rules.MaxURLLength > 0 && len(href) > d.rules.MaxURLLength {
+		return trap{trapURLLength, u.Host}, true
+	}
+
+	if d.rules.MaxSegmentRepeats > 0 {
+		if segment, found := repeatedSegment(u.Path, d.rules.MaxSegmentRepeats); found {
+			return trap{trapSegmentRepeats, u.Host + " /" + segment + "/"}, true
+		}
+	}
+
+	params := paramNames(u)
+	template := urlTemplate(u, params)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	combinations := d.paramCombinations[u.Host]
+	_, known := combinations[params]
+	if params != "" && !known && d.rules.MaxParamCombinations > 0 && len(combinations) >= d.rules.MaxParamCombinations {
+		return trap{trapParamCombinations, u.Host}, true
+	}
+	if d.rules.MaxTemplateURLs > 0 && d.templateURLs[template] >= d.rules.MaxTemplateURLs {
+		return trap{trapTemplateURLs, template}, true
+	}
+
+	d.count(u.Host, params, template, 1)
+
+	return trap{}, false
+}
+
+// Release returns a url counted by Admit
+func (d *trapDetector) Release(u *neturl.URL) {
+	params := paramNames(u)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.count(u.Host, params, urlTemplate(u, params), -1)
+}
+
+func (d *trapDetector) count(host string, params string, template string, delta int) {
+	if params != "" {
+		combinations := d.paramCombinations[host]
+		if combinations == nil {
+			combinations = make(map[string]int)
+			d.paramCombinations[host] = combinations
+		}
+		if combinations[params] += delta; combinations[params] <= 0 {
+			delete(combinations, params)
+		}
+	}
+
+	if d.templateURLs[template] += delta; d.templateURLs[template] <= 0 {
+		delete(d.templateURLs, template)
+	}
+}
+
+// countSeen counts the urls enqueued by previous runs.
+func (d *trapDetector) countSeen(f *frontier) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	f.seen.RangeEnqueued(func(u url) {
+		if parsed, err := neturl.Parse(u); err == nil {
+			params := paramNames(parsed)
+			d.count(parsed.Host, params, urlTemplate(parsed, params), 1)
+		}
+	})
+}
+
+// Trapped returns the number of skipped urls by rule
+func (d *trapDetector) Trapped() map[string]int64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	byRule := make(map[string]int64)
+	for t, count := range d.trapped {
+		byRule[t.rule] += int64(count)
+	}
+
+	return byRule
+}
+
+// WriteReport writes the trapped patterns to the file at path,
+// the ones with the most skipped urls first.
+func (d *trapDetector) WriteReport(path string) error {
+	d.lock.Lock()
+	traps := make([]trap, 0, len(d.trapped))
+	counts := make(map[trap]int, len(d.trapped))
+	for t, count := range d.trapped {
+		traps = append(traps, t)
+		counts[t] = count
+	}
+	d.lock.Unlock()
+
+	sort.Slice(traps, func(i, j int) bool {
+		if counts[traps[i]] != counts[traps[j]] {
+			return counts[traps[i]] > counts[traps[j]]
+		}
+		return traps[i].rule+traps[i].pattern < traps[j].rule+traps[j].pattern
+	})
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	fmt.Fprintln(writer, "rule\tpattern\tskipped")
+	for _, t := range traps {
+		fmt.Fprintf(writer, "%s\t%s\t%d\n", t.rule, t.pattern, counts[t])
+	}
+
+	err = writer.Flush()
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// repeatedSegment returns a path segment which occurs more than limit times
+func repeatedSegment(path string, limit int) (string, bool) {
+	counts := make(map[string]int)
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			continue
+		}
+		if counts[segment]++; counts[segment] > limit {
+			return segment, true
+		}
+	}
+
+	return "", false
+}
+
+// paramNames returns the sorted names of the query parameters of u
+func paramNames(u *neturl.URL) string {
+	if u.RawQuery == "" {
+		return ""
+	}
+
+	query := u.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, "&")
+}
+
+// urlTemplate replaces the numbers and ids of the path of u and
+// the values of its query with placeholders
+func urlTemplate(u *neturl.URL, params string) string {
+	path := idToken.ReplaceAllStringFunc(u.Path, func(token string) string {
+		if strings.IndexFunc(token, unicode.IsDigit) < 0 {
+			// a long word
+			return token
+		}
+		if _, err := strconv.Atoi(token); err == nil {
+			return "{n}"
+		}
+		return "{id}"
+	})
+
+	if params == "" {
+		return u.Host + path
+	}
+
+	return u.Host + path + "?" + strings.ReplaceAll(params, "&", "=*&") + "=*"
+}
@@ -0,0 +1,116 @@
+package crawler
+
+import (
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestURLTemplate(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/calendar/2024/05/17", "example.com/calendar/{n}/{n}/{n}"},
+		{"http://example.com/s/0123456789abcdef0123/page", "example.com/s/{id}/page"},
+		{"http://example.com/a-long-article-slug-without-ids", "example.com/a-long-article-slug-without-ids"},
+		{"http://example.com/search?q=go&page=2", "example.com/search?page=*&q=*"},
+	}
+
+	for _, test := range tests {
+		u, _ := neturl.Parse(test.url)
+		if got := urlTemplate(u, paramNames(u)); got != test.want {
+			t.Errorf("urlTemplate(%s) = %s, want %s", test.url, got, test.want)
+		}
+	}
+}
+
+func TestTrapDetector(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    TrapRules
+		urls     []string
+		admitted int
+		rule     string
+	}{
+		{"url length", TrapRules{MaxURLLength: 30}, []string{"http://example.com/short", "http://example.com/" + strings.Repeat("a", 30)}, 1, trapURLLength},
+		{"segment repeats", TrapRules{MaxSegmentRepeats: 2}, []string{"http://example.com/a/b/a/b", "http://example.com/a/b/a/b/a"}, 1, trapSegmentRepeats},
+		{"param combinations", TrapRules{MaxParamCombinations: 2}, []string{
+			"http://example.com/shop?color=red",
+			"http://example.com/shop?size=m",
+			"http://example.com/shop?color=blue",
+			"http://example.com/shop?color=red&size=m",
+			"http://example.org/shop?color=red&size=m",
+		}, 4, trapParamCombinations},
+		{"template urls", TrapRules{MaxTemplateURLs: 3}, []string{
+			"http://example.com/day/1",
+			"http://example.com/day/2",
+			"http://example.com/day/3",
+			"http://example.com/day/4",
+			"http://example.com/month/1",
+		}, 4, trapTemplateURLs},
+		{"no rules", TrapRules{}, []string{"http://example.com/a/a/a/a/a/" + strings.Repeat("a", 2000)}, 1, ""},
+	}
+
+	for _, test := range tests {
+		d := newTrapDetector(test.rules)
+		admitted := 0
+		for _, raw := range test.urls {
+			u, _ := neturl.Parse(raw)
+			if d.Admit(u) {
+				admitted++
+			}
+		}
+
+		if admitted != test.admitted {
+			t.Errorf("%s: admitted %d urls, want %d", test.name, admitted, test.admitted)
+		}
+		trapped := d.Trapped()
+		if test.rule != "" && trapped[test.rule] != int64(len(test.urls)-test.admitted) {
+			t.Errorf("%s: trapped %v", test.name, trapped)
+		}
+	}
+}
+
+func TestTrapDetectorRelease(t *testing.T) {
+	d := newTrapDetector(TrapRules{MaxTemplateURLs: 1})
+	first, _ := neturl.Parse("http://example.com/day/1")
+	second, _ := neturl.Parse("http://example.com/day/2")
+
+	if !d.Admit(first) {
+		t.Fatal("the first url is not admitted")
+	}
+	d.Release(first)
+	if !d.Admit(second) {
+		t.Error("a released url is still counted")
+	}
+	if d.Admit(first) {
+		t.Error("the template is not limited")
+	}
+}
+
+func TestTrapReport(t *testing.T) {
+	d := newTrapDetector(TrapRules{MaxTemplateURLs: 1, MaxSegmentRepeats: 1})
+	for _, raw := range []string{"http://example.com/day/1", "http://example.com/day/2", "http://example.com/day/3", "http://example.com/a/a"} {
+		u, _ := neturl.Parse(raw)
+		d.Admit(u)
+	}
+
+	path := filepath.Join(t.TempDir(), "traps.tsv")
+	if err := d.WriteReport(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "rule\tpattern\tskipped\n" +
+		"template_urls\texample.com/day/{n}\t2\n" +
+		"segment_repeats\texample.com /a/\t1\n"
+	if string(data) != want {
+		t.Errorf("report is\n%s\nwant\n%s", data, want)
+	}
+}
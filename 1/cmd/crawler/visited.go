@@ -0,0 +1,162 @@
+package crawler
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// urlSet remembers every url the crawler has put into its frontier and
+// every url it has only marked as seen, e.g. a redirect target.
+// When backed by files every new url is appended as a line, the enqueued
+// urls to one file and the marked ones to another, so a restarted crawl
+// starts with the same set and does not download pages twice.
+type urlSet struct {
+	lock sync.Mutex
+	// urls tells for every url whether it has been enqueued
+	urls       map[url]bool
+	file       *os.File
+	markedFile *os.File
+}
+
+func newUrlSet() *urlSet {
+	return &urlSet{urls: make(map[url]bool)}
+}
+
+func openUrlSet(path string, markedPath string) (*urlSet, error) {
+	set := newUrlSet()
+
+	// a url which has been enqueued stays enqueued when it is marked too
+	if err := set.load(markedPath, false); err != nil {
+		return nil, err
+	}
+	if err := set.load(path, true); err != nil {
+		return nil, err
+	}
+
+	var err error
+	set.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	set.markedFile, err = os.OpenFile(markedPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		set.file.Close()
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// load adds the urls of the file at path, a missing file has none
+func (s *urlSet) load(path string, enqueued bool) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			s.urls[line] = enqueued
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Add records u as enqueued. It returns true if u was not in the set before
+// the call.
+func (s *urlSet) Add(u url) (bool, error) {
+	return s.add(u, true)
+}
+
+// Mark records u as seen without being enqueued. It returns true if u was
+// not in the set before the call.
+func (s *urlSet) Mark(u url) (bool, error) {
+	return s.add(u, false)
+}
+
+func (s *urlSet) add(u url, enqueued bool) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, exists := s.urls[u]; exists {
+		return false, nil
+	}
+
+	file := s.markedFile
+	if enqueued {
+		file = s.file
+	}
+	if file != nil {
+		if _, err := file.WriteString(u + "\n"); err != nil {
+			return false, err
+		}
+	}
+	s.urls[u] = enqueued
+
+	return true, nil
+}
+
+// Contains reports whether u is in the set.
+func (s *urlSet) Contains(u url) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, exists := s.urls[u]
+
+	return exists
+}
+
+// Clear removes every url from the set.
+func (s *urlSet) Clear() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.urls = make(map[url]bool)
+	if s.file == nil {
+		return nil
+	}
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+
+	return s.markedFile.Truncate(0)
+}
+
+// RangeEnqueued calls f for every url in the set which has been enqueued.
+// The urls which have only been marked are skipped.
+func (s *urlSet) RangeEnqueued(f func(u url)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for u, enqueued := range s.urls {
+		if enqueued {
+			f(u)
+		}
+	}
+}
+
+func (s *urlSet) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	if cerr := s.markedFile.Close(); err == nil {
+		err = cerr
+	}
+	s.file, s.markedFile = nil, nil
+
+	return err
+}
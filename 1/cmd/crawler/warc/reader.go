@@ -0,0 +1,149 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is a WARC record read by Reader.
+type Record struct {
+	Header textproto.MIMEHeader
+	Block  []byte
+}
+
+// Type returns the WARC-Type of the record.
+func (r *Record) Type() string {
+	return r.Header.Get("WARC-Type")
+}
+
+// TargetURI returns the url the record is about.
+func (r *Record) TargetURI() string {
+	// WARC 1.0 files of some tools wrap the uri in angle brackets
+	return strings.Trim(r.Header.Get("WARC-Target-URI"), "<>")
+}
+
+// Id returns the WARC-Record-ID of the record.
+func (r *Record) Id() string {
+	return r.Header.Get("WARC-Record-ID")
+}
+
+// ConcurrentTo returns the WARC-Record-ID of the record written together
+// with this one, e.g. the response of a request record.
+func (r *Record) ConcurrentTo() string {
+	return r.Header.Get("WARC-Concurrent-To")
+}
+
+// OriginalURI returns the url requested before the redirects which led to
+// the target of a request record, the target if there were none.
+func (r *Record) OriginalURI() string {
+	if original := r.Header.Get(OriginalURIField); original != "" {
+		return original
+	}
+
+	return r.TargetURI()
+}
+
+// Date returns the WARC-Date of the record, the zero time if it is invalid.
+func (r *Record) Date() time.Time {
+	date, _ := time.Parse(time.RFC3339, r.Header.Get("WARC-Date"))
+
+	return date
+}
+
+// HTTPResponse parses the block of a response record. The body is returned
+// without its transfer and content encodings. A truncated body is returned
+// as far as it goes.
+func (r *Record) HTTPResponse() (*http.Response, []byte, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(r.Block)), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, nil, err
+	}
+
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		if body, err = io.ReadAll(zr); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, nil, err
+		}
+		resp.Header.Del("Content-Encoding")
+	}
+
+	return resp, body, nil
+}
+
+// Reader reads the records of a WARC file, gzip compressed or not.
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		// the gzip reader reads all the members of the file one by one
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(zr)
+	}
+
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record, io.EOF after the last one.
+func (r *Reader) Next() (*Record, error) {
+	var line string
+	for line == "" {
+		var err error
+		line, err = r.r.ReadString('\n')
+		if errors.Is(err, io.EOF) && strings.TrimSpace(line) == "" {
+			return nil, io.EOF
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+	}
+
+	if !strings.HasPrefix(line, "WARC/") {
+		return nil, fmt.Errorf("warc: expected a record, got %q", line)
+	}
+
+	header, err := textproto.NewReader(r.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("warc: invalid Content-Length %q", header.Get("Content-Length"))
+	}
+
+	block := make([]byte, length)
+	if _, err := io.ReadFull(r.r, block); err != nil {
+		return nil, err
+	}
+
+	return &Record{Header: header, Block: block}, nil
+}
@@ -0,0 +1,89 @@
+package warc
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+)
+
+// Transport archives every exchange made through the wrapped RoundTripper.
+// The response body is recorded as the client reads it, so it is archived
+// after the transport has removed the transfer and content encodings.
+// A body which is closed before it is read to the end is recorded as
+// truncated, one which is not read at all is not recorded.
+type Transport struct {
+	next   http.RoundTripper
+	writer *Writer
+}
+
+// NewTransport wraps next, http.DefaultTransport when it is nil.
+func NewTransport(next http.RoundTripper, writer *Writer) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &Transport{next: next, writer: writer}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	resp.Body = &recordingBody{
+		body:   resp.Body,
+		req:    req,
+		resp:   resp,
+		writer: t.writer,
+	}
+
+	return resp, nil
+}
+
+// recordingBody keeps a copy of the body it reads and
+// archives the exchange when it is closed
+type recordingBody struct {
+	body   io.ReadCloser
+	req    *http.Request
+	resp   *http.Response
+	writer *Writer
+	buf    bytes.Buffer
+	read   bool
+	eof    bool
+	closed bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.buf.Write(p[:n])
+	b.read = true
+	if err == io.EOF {
+		b.eof = true
+	}
+
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.body.Close()
+	if b.closed {
+		return err
+	}
+	b.closed = true
+
+	if !b.read {
+		return err
+	}
+
+	truncated := ""
+	if !b.eof {
+		truncated = "length"
+	}
+	if writeErr := b.writer.WriteExchange(b.req, b.resp, b.buf.Bytes(), truncated); writeErr != nil {
+		log.Println(writeErr)
+	}
+
+	return err
+}
@@ -0,0 +1,103 @@
+package warc
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readRecords(t *testing.T, dir string) []*Record {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+FileExtension))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("expected one file, got %v %v", paths, err)
+	}
+	file, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []*Record
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return records
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+}
+
+func TestTransportWriteThenRead(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html>new</html>"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	writer, err := NewWriter(dir, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: NewTransport(nil, writer)}
+	resp, err := client.Get(ts.URL + "/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	writer.Close()
+
+	records := readRecords(t, dir)
+	tests := []struct {
+		typ      string
+		target   string
+		original string
+	}{
+		{TypeWarcinfo, "", ""},
+		{TypeRequest, ts.URL + "/old", ts.URL + "/old"},
+		{TypeResponse, ts.URL + "/old", ts.URL + "/old"},
+		{TypeRequest, ts.URL + "/new", ts.URL + "/old"},
+		{TypeResponse, ts.URL + "/new", ts.URL + "/new"},
+	}
+	if len(records) != len(tests) {
+		t.Fatalf("expected %d records, got %d", len(tests), len(records))
+	}
+
+	for i, test := range tests {
+		record := records[i]
+		if record.Type() != test.typ || record.TargetURI() != test.target || record.OriginalURI() != test.original {
+			t.Errorf("record %d: expected %s %s %s, got %s %s %s", i, test.typ, test.target, test.original,
+				record.Type(), record.TargetURI(), record.OriginalURI())
+		}
+		if record.Type() == TypeRequest && record.ConcurrentTo() != records[i+1].Id() {
+			t.Errorf("record %d: request is concurrent to %s instead of its response %s", i, record.ConcurrentTo(), records[i+1].Id())
+		}
+	}
+
+	resp, body, err := records[4].HTTPResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "<html>new</html>" || resp.Header.Get("Content-Type") != "text/html" {
+		t.Errorf("unexpected response %d %q %v", resp.StatusCode, body, resp.Header)
+	}
+	if records[4].Date().IsZero() {
+		t.Error("response date is not readable")
+	}
+}
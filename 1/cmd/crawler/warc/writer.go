@@ -0,0 +1,279 @@
+// Package warc archives http exchanges in the WARC 1.1 format
+// (ISO 28500, https://iipc.github.io/warc-specifications/).
+package warc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	version = "WARC/1.1"
+	// FileExtension is the extension of the files written by Writer.
+	FileExtension = ".warc.gz"
+	// DefaultMaxFileSize is the size after which Writer starts a new file.
+	DefaultMaxFileSize = 1 << 30
+	// OriginalURIField is the extension field of a request record which
+	// follows redirects. It holds the url requested before the redirects.
+	OriginalURIField = "Crawler-Original-Target-URI"
+)
+
+// Record types.
+const (
+	TypeWarcinfo = "warcinfo"
+	TypeRequest  = "request"
+	TypeResponse = "response"
+)
+
+// Writer appends request and response records to gzip compressed WARC files
+// in a dir. Every record is a separate gzip member, so the files can be read
+// record by record. A new file is started when the current one exceeds
+// the max size, every file starts with a warcinfo record.
+type Writer struct {
+	dir         string
+	prefix      string
+	maxFileSize int64
+
+	lock    sync.Mutex
+	file    *os.File
+	written int64
+	serial  int
+	// infoId is the id of the warcinfo record of the current file
+	infoId string
+}
+
+func NewWriter(dir string, prefix string, maxFileSize int64) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if maxFileSize <= 0 {
+		maxFileSize = DefaultMaxFileSize
+	}
+
+	return &Writer{dir: dir, prefix: prefix, maxFileSize: maxFileSize}, nil
+}
+
+// WriteExchange writes a request record and a response record with body
+// as the payload. Truncated tells why the body is incomplete, e.g. "length",
+// it is empty for complete bodies. The request record of a redirect target
+// keeps the url of the first request in the OriginalURIField.
+func (w *Writer) WriteExchange(req *http.Request, resp *http.Response, body []byte, truncated string) error {
+	date := time.Now()
+	target := req.URL.String()
+	requestId := newRecordId()
+	responseId := newRecordId()
+
+	requestBlock := requestHeader(req)
+	responseBlock := append(responseHeader(resp), body...)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.openFile(); err != nil {
+		return err
+	}
+
+	request := record{
+		Type: TypeRequest,
+		Id:   requestId,
+		Headers: [][2]string{
+			{"WARC-Target-URI", target},
+			{"WARC-Warcinfo-ID", w.infoId},
+			{"WARC-Concurrent-To", responseId},
+			{"Content-Type", "application/http;msgtype=request"},
+		},
+		Block: requestBlock,
+	}
+	response := record{
+		Type: TypeResponse,
+		Id:   responseId,
+		Headers: [][2]string{
+			{"WARC-Target-URI", target},
+			{"WARC-Warcinfo-ID", w.infoId},
+			{"Content-Type", "application/http;msgtype=response"},
+			{"WARC-Payload-Digest", digest(body)},
+		},
+		Block: responseBlock,
+	}
+	if original := originalURL(req); original != target {
+		request.Headers = append(request.Headers, [2]string{OriginalURIField, original})
+	}
+	if truncated != "" {
+		response.Headers = append(response.Headers, [2]string{"WARC-Truncated", truncated})
+	}
+
+	for _, r := range []record{request, response} {
+		if err := w.write(r, date); err != nil {
+			return err
+		}
+	}
+
+	if w.written >= w.maxFileSize {
+		return w.closeFile()
+	}
+
+	return nil
+}
+
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.closeFile()
+}
+
+// openFile starts a new file unless one is open
+func (w *Writer) openFile() error {
+	if w.file != nil {
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-%s-%05d%s", w.prefix, time.Now().UTC().Format("20060102150405"), w.serial, FileExtension)
+	file, err := os.OpenFile(filepath.Join(w.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.written = 0
+	w.serial++
+	w.infoId = newRecordId()
+
+	info := record{
+		Type: TypeWarcinfo,
+		Id:   w.infoId,
+		Headers: [][2]string{
+			{"WARC-Filename", name},
+			{"Content-Type", "application/warc-fields"},
+		},
+		Block: []byte("software: crawler\r\nformat: WARC File Format 1.1\r\n" +
+			"conformsTo: https://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/\r\n"),
+	}
+	if err := w.write(info, time.Now()); err != nil {
+		w.closeFile()
+		return err
+	}
+
+	return nil
+}
+
+func (w *Writer) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+
+	return err
+}
+
+// write appends r to the current file as a gzip member
+func (w *Writer) write(r record, date time.Time) error {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(r.bytes(date)); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	n, err := w.file.Write(buf.Bytes())
+	w.written += int64(n)
+
+	return err
+}
+
+// record is a WARC record. Headers holds the named fields besides the
+// ones every record has.
+type record struct {
+	Type    string
+	Id      string
+	Headers [][2]string
+	Block   []byte
+}
+
+func (r record) bytes(date time.Time) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(version + "\r\n")
+	writeField(buf, "WARC-Type", r.Type)
+	writeField(buf, "WARC-Record-ID", r.Id)
+	writeField(buf, "WARC-Date", date.UTC().Format(time.RFC3339))
+	for _, field := range r.Headers {
+		writeField(buf, field[0], field[1])
+	}
+	writeField(buf, "WARC-Block-Digest", digest(r.Block))
+	writeField(buf, "Content-Length", strconv.Itoa(len(r.Block)))
+	buf.WriteString("\r\n")
+	buf.Write(r.Block)
+	buf.WriteString("\r\n\r\n")
+
+	return buf.Bytes()
+}
+
+func writeField(buf *bytes.Buffer, name string, value string) {
+	buf.WriteString(name + ": " + value + "\r\n")
+}
+
+// originalURL returns the url of the request which req was redirected from,
+// the url of req if it is not a redirect
+func originalURL(req *http.Request) string {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+
+	return req.URL.String()
+}
+
+// requestHeader returns the request line and the headers of req
+func requestHeader(req *http.Request) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	fmt.Fprintf(buf, "Host: %s\r\n", host)
+	req.Header.Write(buf)
+	buf.WriteString("\r\n")
+
+	return buf.Bytes()
+}
+
+// responseHeader returns the status line and the headers of resp
+func responseHeader(resp *http.Response) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+	resp.Header.Write(buf)
+	buf.WriteString("\r\n")
+
+	return buf.Bytes()
+}
+
+// digest returns the SHA-1 digest of data in the labelled form WARC uses
+func digest(data []byte) string {
+	sum := sha1.Sum(data)
+
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// newRecordId returns a random version 4 UUID URN
+func newRecordId() string {
+	var id [16]byte
+	rand.Read(id[:])
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
@@ -0,0 +1,3 @@
+module pagerank
+
+go 1.24.1
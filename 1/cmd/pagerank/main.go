@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type url = string
+type fileId = int64
+
+// danglingPolicy tells where the rank of pages without outgoing links goes
+type danglingPolicy string
+
+const (
+	// danglingUniform spreads the rank of dangling pages over all pages,
+	// as if they linked to every page
+	danglingUniform danglingPolicy = "uniform"
+	// danglingSelf keeps the rank of dangling pages on themselves
+	danglingSelf danglingPolicy = "self"
+	// danglingIgnore drops the rank of dangling pages, the scores are
+	// normalized after the last iteration
+	danglingIgnore danglingPolicy = "ignore"
+)
+
+type document struct {
+	Id           fileId `json:"id"`
+	Url          url    `json:"url"`
+	CanonicalUrl url    `json:"canonical_url"`
+}
+
+// graph is the link graph with pages numbered from 0
+type graph struct {
+	nodes    map[url]int
+	outLinks [][]int
+}
+
+func (g *graph) node(u url) int {
+	if i, exists := g.nodes[u]; exists {
+		return i
+	}
+
+	i := len(g.outLinks)
+	g.nodes[u] = i
+	g.outLinks = append(g.outLinks, nil)
+
+	return i
+}
+
+func main() {
+	linksPath := flag.String("links", "", "link graph written by the crawler, \"from\\tto\" per line")
+	documentsPath := flag.String("documents", "", "documents.jsonl written by the parser")
+	outPath := flag.String("out", "pagerank.csv", "output file with \"id,score\" rows")
+	damping := flag.Float64("damping", 0.85, "probability of following a link instead of jumping to a random page")
+	dangling := flag.String("dangling", string(danglingUniform), "rank of pages without links: uniform, self or ignore")
+	iterations := flag.Int("iterations", 100, "maximum number of iterations")
+	tolerance := flag.Float64("tolerance", 1e-9, "stop when the scores change less than this in total")
+	flag.Parse()
+
+	if *linksPath == "" || *documentsPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *damping < 0 || *damping >= 1 {
+		log.Fatalln("damping must be in [0, 1)")
+	}
+	policy := danglingPolicy(*dangling)
+	if policy != danglingUniform && policy != danglingSelf && policy != danglingIgnore {
+		log.Fatalf("unknown dangling policy %q\n", *dangling)
+	}
+
+	documents, gone, err := loadDocuments(*documentsPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	g, err := loadGraph(*linksPath, documents, gone)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ranks := pageRank(g, *damping, policy, *iterations, *tolerance)
+
+	if err := writeScores(*outPath, g, documents, ranks); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// loadDocuments maps the canonical url of every document to its id. The
+// documents listed in deleted.txt next to documents.jsonl are gone, they
+// are returned apart.
+func loadDocuments(path string) (documents map[url]fileId, gone map[url]struct{}, err error) {
+	deleted, err := readDeletedIds(filepath.Join(filepath.Dir(path), "deleted.txt"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	documents = make(map[url]fileId)
+	gone = make(map[url]struct{})
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var doc document
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		u := doc.CanonicalUrl
+		if u == "" {
+			u = doc.Url
+		}
+		if _, isDeleted := deleted[doc.Id]; isDeleted {
+			gone[u] = struct{}{}
+			continue
+		}
+		documents[u] = doc.Id
+	}
+
+	return documents, gone, nil
+}
+
+// readDeletedIds reads the ids of the documents deleted by a recrawl,
+// one per line. There is no file when nothing has been deleted.
+func readDeletedIds(path string) (map[fileId]struct{}, error) {
+	deleted := make(map[fileId]struct{})
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return deleted, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if id, err := strconv.ParseInt(strings.TrimSpace(scanner.Text()), 10, 64); err == nil {
+			deleted[id] = struct{}{}
+		}
+	}
+
+	return deleted, scanner.Err()
+}
+
+// loadGraph reads the links between crawled pages. A page is crawled if it
+// has outgoing links or is a document, links to other pages are dropped.
+// The gone pages are not crawled anymore. Repeated links between two pages
+// count once.
+func loadGraph(path string, documents map[url]fileId, gone map[url]struct{}) (*graph, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	g := &graph{nodes: make(map[url]int)}
+	for u := range documents {
+		g.node(u)
+	}
+
+	var edges [][2]url
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		from, to, found := strings.Cut(scanner.Text(), "\t")
+		if !found {
+			continue
+		}
+		if _, isGone := gone[from]; isGone {
+			continue
+		}
+
+		g.node(from)
+		edges = append(edges, [2]url{from, to})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	linked := make(map[[2]int]struct{}, len(edges))
+	for _, edge := range edges {
+		to, crawled := g.nodes[edge[1]]
+		if !crawled {
+			continue
+		}
+
+		link := [2]int{g.nodes[edge[0]], to}
+		if _, exists := linked[link]; !exists {
+			linked[link] = struct{}{}
+			g.outLinks[link[0]] = append(g.outLinks[link[0]], to)
+		}
+	}
+
+	return g, nil
+}
+
+// pageRank runs the power iteration until the total change of the scores
+// is below tolerance. The scores sum to 1.
+func pageRank(g *graph, damping float64, policy danglingPolicy, iterations int, tolerance float64) []float64 {
+	n := len(g.outLinks)
+	if n == 0 {
+		return nil
+	}
+
+	ranks := make([]float64, n)
+	next := make([]float64, n)
+	for i := range ranks {
+		ranks[i] = 1 / float64(n)
+	}
+
+	for iteration := 0; iteration < iterations; iteration++ {
+		var danglingRank float64
+		for i := range next {
+			next[i] = (1 - damping) / float64(n)
+		}
+
+		for i, links := range g.outLinks {
+			if len(links) == 0 {
+				switch policy {
+				case danglingUniform:
+					danglingRank += ranks[i]
+				case danglingSelf:
+					next[i] += damping * ranks[i]
+				}
+				continue
+			}
+
+			share := damping * ranks[i] / float64(len(links))
+			for _, to := range links {
+				next[to] += share
+			}
+		}
+
+		var change float64
+		for i := range next {
+			next[i] += damping * danglingRank / float64(n)
+			change += math.Abs(next[i] - ranks[i])
+		}
+
+		ranks, next = next, ranks
+		if change < tolerance {
+			log.Printf("converged after %d iterations\n", iteration+1)
+			break
+		}
+	}
+
+	if policy == danglingIgnore {
+		var sum float64
+		for _, rank := range ranks {
+			sum += rank
+		}
+		for i := range ranks {
+			ranks[i] /= sum
+		}
+	}
+
+	return ranks
+}
+
+func writeScores(path string, g *graph, documents map[url]fileId, ranks []float64) error {
+	type score struct {
+		id   fileId
+		rank float64
+	}
+
+	scores := make([]score, 0, len(documents))
+	for u, id := range documents {
+		scores = append(scores, score{id: id, rank: ranks[g.nodes[u]]})
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].id < scores[j].id
+	})
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	writer.WriteString("id,score\n")
+	for _, s := range scores {
+		writer.WriteString(fmt.Sprintf("%d,%g\n", s.id, s.rank))
+	}
+
+	return writer.Flush()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newGraph returns the graph of n pages with the links
+func newGraph(n int, links [][2]int) *graph {
+	g := &graph{nodes: make(map[url]int)}
+	for i := range n {
+		g.node(strconv.Itoa(i))
+	}
+	for _, link := range links {
+		g.outLinks[link[0]] = append(g.outLinks[link[0]], link[1])
+	}
+	return g
+}
+
+func TestPageRank(t *testing.T) {
+	const damping = 0.85
+
+	tests := []struct {
+		name   string
+		n      int
+		links  [][2]int
+		policy danglingPolicy
+		want   []float64
+	}{
+		{"cycle", 3, [][2]int{{0, 1}, {1, 2}, {2, 0}}, danglingUniform, []float64{1.0 / 3, 1.0 / 3, 1.0 / 3}},
+		{"dangling uniform", 2, [][2]int{{0, 1}}, danglingUniform, []float64{1 / (2 + damping), (1 + damping) / (2 + damping)}},
+		{"dangling self", 2, [][2]int{{0, 1}}, danglingSelf, []float64{(1 - damping) / 2, 1 - (1-damping)/2}},
+		{"dangling ignore", 2, [][2]int{{0, 1}}, danglingIgnore, []float64{1 / (2 + damping), (1 + damping) / (2 + damping)}},
+		{"no pages", 0, nil, danglingUniform, nil},
+	}
+
+	for _, test := range tests {
+		ranks := pageRank(newGraph(test.n, test.links), damping, test.policy, 1000, 1e-12)
+		if len(ranks) != len(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, ranks, test.want)
+			continue
+		}
+		var sum float64
+		for i, rank := range ranks {
+			sum += rank
+			if math.Abs(rank-test.want[i]) > 1e-6 {
+				t.Errorf("%s: rank of %d is %v, want %v", test.name, i, rank, test.want[i])
+			}
+		}
+		if len(ranks) > 0 && math.Abs(sum-1) > 1e-9 {
+			t.Errorf("%s: the ranks sum to %v", test.name, sum)
+		}
+	}
+}
+
+func TestPageRankFavorsLinkedPages(t *testing.T) {
+	// 1, 2 and 3 link to 0, 0 links to 1
+	ranks := pageRank(newGraph(4, [][2]int{{1, 0}, {2, 0}, {3, 0}, {0, 1}}), 0.85, danglingUniform, 100, 1e-9)
+
+	if !(ranks[0] > ranks[1] && ranks[1] > ranks[2] && math.Abs(ranks[2]-ranks[3]) < 1e-12) {
+		t.Errorf("unexpected ranks %v", ranks)
+	}
+}
+
+func TestPageRankFiles(t *testing.T) {
+	dir := t.TempDir()
+	documentsPath := filepath.Join(dir, "documents.jsonl")
+	linksPath := filepath.Join(dir, "links.tsv")
+	outPath := filepath.Join(dir, "pagerank.csv")
+
+	documents := `{"id": 7, "url": "http://example.com/a?utm_source=x", "canonical_url": "http://example.com/a"}
+{"id": 3, "url": "http://example.com/b"}
+`
+	// the links of the crawled page c count, the one to the outside page x
+	// is dropped and the repeated link counts once
+	links := "http://example.com/a\thttp://example.com/b\n" +
+		"http://example.com/a\thttp://example.com/b\n" +
+		"http://example.com/b\thttp://example.com/a\n" +
+		"http://example.com/c\thttp://example.com/a\n" +
+		"http://example.com/b\thttp://example.org/x\n" +
+		"broken line\n"
+	for path, content := range map[string]string{documentsPath: documents, linksPath: links} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded, gone, err := loadDocuments(documentsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := loadGraph(linksPath, loaded, gone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.outLinks) != 3 {
+		t.Fatalf("the graph has %d pages, want a, b and c", len(g.outLinks))
+	}
+	a, b := g.nodes["http://example.com/a"], g.nodes["http://example.com/b"]
+	if len(g.outLinks[a]) != 1 || len(g.outLinks[b]) != 1 {
+		t.Errorf("unexpected links %v", g.outLinks)
+	}
+
+	ranks := pageRank(g, 0.85, danglingUniform, 100, 1e-9)
+	if err := writeScores(outPath, g, loaded, ranks); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || lines[0] != "id,score" || !strings.HasPrefix(lines[1], "3,") || !strings.HasPrefix(lines[2], "7,") {
+		t.Fatalf("unexpected scores %q", lines)
+	}
+	scoreA, _ := strconv.ParseFloat(strings.TrimPrefix(lines[2], "7,"), 64)
+	if math.Abs(scoreA-ranks[a]) > 1e-12 || ranks[a] <= ranks[b] {
+		t.Errorf("unexpected ranks %v", ranks)
+	}
+}
+
+func TestPageRankSkipsDeletedDocuments(t *testing.T) {
+	dir := t.TempDir()
+	documentsPath := filepath.Join(dir, "documents.jsonl")
+	linksPath := filepath.Join(dir, "links.tsv")
+
+	documents := `{"id": 1, "url": "http://example.com/a"}
+{"id": 2, "url": "http://example.com/b"}
+{"id": 3, "url": "http://example.com/gone"}
+`
+	links := "http://example.com/a\thttp://example.com/b\n" +
+		"http://example.com/a\thttp://example.com/gone\n" +
+		"http://example.com/gone\thttp://example.com/b\n"
+
+	tests := []struct {
+		name    string
+		deleted string
+		pages   int
+		ids     []fileId
+	}{
+		{"nothing deleted", "", 3, []fileId{1, 2, 3}},
+		{"gone", "3\n", 2, []fileId{1, 2}},
+	}
+
+	for _, test := range tests {
+		files := map[string]string{documentsPath: documents, linksPath: links, filepath.Join(dir, "deleted.txt"): test.deleted}
+		for path, content := range files {
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		loaded, gone, err := loadDocuments(documentsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []fileId
+		for _, id := range loaded {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		if !slices.Equal(ids, test.ids) {
+			t.Errorf("%s: documents %v, want %v", test.name, ids, test.ids)
+		}
+
+		g, err := loadGraph(linksPath, loaded, gone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(g.outLinks) != test.pages {
+			t.Errorf("%s: the graph has %d pages, want %d", test.name, len(g.outLinks), test.pages)
+		}
+	}
+}
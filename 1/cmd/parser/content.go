@@ -0,0 +1,320 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// minParagraphChars is the shortest text of a block which adds to the
+	// score of its ancestors
+	minParagraphChars = 25
+	// minContentChars is the shortest main content, the full text of the
+	// page is used when the extracted content is shorter
+	minContentChars = 250
+	// maxContentLinkDensity is the share of link text above which a block
+	// inside the main content is treated as a list of links
+	maxContentLinkDensity = 0.5
+)
+
+var (
+	positiveBlockNames = regexp.MustCompile(`(?i)article|body|content|entry|main|page|post|text|blog|story`)
+	negativeBlockNames = regexp.MustCompile(`(?i)comment|footer|footnote|masthead|menu|nav|sidebar|sponsor|share|social|banner|cookie|consent|popup|modal|promo|related|widget|breadcrumb|pagination|subscribe|advert|\bads?\b`)
+)
+
+// boilerplateTags are never a part of the main content. A header is only
+// when it is the header of the page, not the one of an article.
+var boilerplateTags = map[string]struct{}{
+	"nav":    {},
+	"aside":  {},
+	"footer": {},
+	"form":   {},
+	"button": {},
+	"select": {},
+	"iframe": {},
+	"svg":    {},
+}
+
+// tagWeights is the initial score of the blocks which may hold the main content
+var tagWeights = map[string]float64{
+	"article":    10,
+	"main":       10,
+	"section":    5,
+	"div":        5,
+	"pre":        3,
+	"td":         3,
+	"blockquote": 3,
+	"address":    -3,
+	"ol":         -3,
+	"ul":         -3,
+	"dl":         -3,
+	"dd":         -3,
+	"dt":         -3,
+	"li":         -3,
+	"h1":         -5,
+	"h2":         -5,
+	"h3":         -5,
+	"h4":         -5,
+	"h5":         -5,
+	"h6":         -5,
+	"th":         -5,
+}
+
+// paragraphTags are the blocks whose text is scored
+var paragraphTags = map[string]struct{}{
+	"p":          {},
+	"pre":        {},
+	"td":         {},
+	"blockquote": {},
+}
+
+// FullText makes the parser keep the whole text of a page, including menus,
+// footers and other boilerplate, instead of the main content only.
+func FullText() Option {
+	return func(w *htmlTextToFileWriter) {
+		w.fullText = true
+	}
+}
+
+type blockStats struct {
+	textChars int
+	linkChars int
+	commas    int
+	hasBlocks bool
+
+	isCandidate bool
+	score       float64
+}
+
+func (b *blockStats) linkDensity() float64 {
+	if b.textChars == 0 {
+		return 0
+	}
+
+	return float64(b.linkChars) / float64(b.textChars)
+}
+
+// contentExtractor finds the main content of a page in the manner of
+// Readability. Blocks get points for the paragraphs of text they contain,
+// depending on the paragraph length and number of commas, and for their tag
+// and class names. The score is reduced by the share of link text, so menus
+// and lists of related links lose to the article body. The best block and
+// its siblings with similar scores form the main content.
+type contentExtractor struct {
+	stats map[*html.Node]*blockStats
+	// order holds the measured elements in document order, so of the blocks
+	// with equal scores the first one is always the main content
+	order []*html.Node
+}
+
+func newContentExtractor() *contentExtractor {
+	return &contentExtractor{stats: make(map[*html.Node]*blockStats)}
+}
+
+// Extract returns the nodes which hold the main content of root, or nil
+// when no block has enough text to be the main content.
+func (c *contentExtractor) Extract(root *html.Node) []*html.Node {
+	clear(c.stats)
+	c.order = c.order[:0]
+	c.measure(root, false)
+	c.score(root)
+
+	var best *html.Node
+	var bestScore float64
+	for _, node := range c.order {
+		stats := c.stats[node]
+		if !stats.isCandidate {
+			continue
+		}
+
+		stats.score *= 1 - stats.linkDensity()
+		if best == nil || stats.score > bestScore {
+			best, bestScore = node, stats.score
+		}
+	}
+
+	if best == nil || c.stats[best].textChars < minContentChars {
+		return nil
+	}
+
+	if best.Parent == nil {
+		return []*html.Node{best}
+	}
+
+	threshold := max(10, bestScore*0.2)
+	var content []*html.Node
+	for sibling := best.Parent.FirstChild; sibling != nil; sibling = sibling.NextSibling {
+		if sibling == best || c.isContentSibling(sibling, threshold) {
+			content = append(content, sibling)
+		}
+	}
+
+	return content
+}
+
+func (c *contentExtractor) isContentSibling(n *html.Node, threshold float64) bool {
+	stats, measured := c.stats[n]
+	if !measured {
+		return false
+	}
+
+	if stats.isCandidate && stats.score >= threshold {
+		return true
+	}
+
+	return n.Data == "p" && stats.linkDensity() < 0.25 && stats.textChars > 80
+}
+
+// measure counts the text, link text and commas of every element
+// under n and returns the stats of n
+func (c *contentExtractor) measure(n *html.Node, inLink bool) blockStats {
+	var stats blockStats
+
+	switch n.Type {
+	case html.TextNode:
+		for _, word := range strings.Fields(n.Data) {
+			stats.textChars += utf8.RuneCountInString(word)
+		}
+		stats.commas = strings.Count(n.Data, ",")
+		if inLink {
+			stats.linkChars = stats.textChars
+		}
+		return stats
+
+	case html.ElementNode:
+		if isSkippedElement(n) {
+			return stats
+		}
+		inLink = inLink || n.Data == "a"
+		c.order = append(c.order, n)
+
+	case html.DocumentNode:
+
+	default:
+		return stats
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		childStats := c.measure(child, inLink)
+		stats.textChars += childStats.textChars
+		stats.linkChars += childStats.linkChars
+		stats.commas += childStats.commas
+
+		if child.Type == html.ElementNode && (isBlockTag(child.Data) || childStats.hasBlocks) {
+			stats.hasBlocks = true
+		}
+	}
+
+	if n.Type == html.ElementNode {
+		stored := stats
+		c.stats[n] = &stored
+	}
+
+	return stats
+}
+
+// score gives the points of every paragraph to its parent and half of
+// them to its grandparent
+func (c *contentExtractor) score(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode {
+			c.score(child)
+		}
+	}
+
+	stats, measured := c.stats[n]
+	if !measured || !c.isParagraph(n, stats) || stats.textChars < minParagraphChars {
+		return
+	}
+
+	points := 1 + float64(stats.commas) + min(float64(stats.textChars)/100, 3)
+
+	if parent := n.Parent; parent != nil && parent.Type == html.ElementNode {
+		c.candidate(parent).score += points
+
+		if grandparent := parent.Parent; grandparent != nil && grandparent.Type == html.ElementNode {
+			c.candidate(grandparent).score += points / 2
+		}
+	}
+}
+
+// isParagraph reports whether n is a paragraph of text. A div without
+// nested blocks is a paragraph as well.
+func (c *contentExtractor) isParagraph(n *html.Node, stats *blockStats) bool {
+	if _, isParagraph := paragraphTags[n.Data]; isParagraph {
+		return true
+	}
+
+	return n.Data == "div" && !stats.hasBlocks
+}
+
+func (c *contentExtractor) candidate(n *html.Node) *blockStats {
+	stats := c.stats[n]
+	if !stats.isCandidate {
+		stats.isCandidate = true
+		stats.score = tagWeights[n.Data] + classWeight(n)
+	}
+
+	return stats
+}
+
+// isBoilerplate reports whether a block of the main content block root
+// should be dropped
+func (c *contentExtractor) isBoilerplate(n *html.Node, root *html.Node) bool {
+	if _, isBoilerplate := boilerplateTags[n.Data]; isBoilerplate {
+		return true
+	}
+
+	// a header inside the content holds the title and the lead of an
+	// article, the one beside it or at the top of the body is the page's
+	if n.Data == "header" && (n == root || n.Parent.Type == html.ElementNode && n.Parent.Data == "body") {
+		return true
+	}
+
+	if classWeight(n) < 0 {
+		return true
+	}
+
+	stats, measured := c.stats[n]
+	return measured && stats.linkDensity() > maxContentLinkDensity
+}
+
+// visitContent writes the text of n skipping boilerplate blocks
+func (c *contentExtractor) visitContent(t *textWriter, n *html.Node) {
+	t.visit(n, func(block *html.Node) bool { return c.isBoilerplate(block, n) })
+}
+
+// classWeight scores the class and id of n by the words which are
+// common for content and boilerplate blocks
+func classWeight(n *html.Node) float64 {
+	var weight float64
+
+	for _, attr := range n.Attr {
+		if attr.Key != "class" && attr.Key != "id" {
+			continue
+		}
+
+		if negativeBlockNames.MatchString(attr.Val) {
+			weight -= 25
+		}
+		if positiveBlockNames.MatchString(attr.Val) {
+			weight += 25
+		}
+	}
+
+	return weight
+}
+
+func isBlockTag(tag string) bool {
+	switch tag {
+	case "address", "article", "aside", "blockquote", "div", "dl", "fieldset", "figure",
+		"footer", "form", "h1", "h2", "h3", "h4", "h5", "h6", "header", "hr", "li",
+		"main", "nav", "ol", "p", "pre", "section", "table", "ul":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	menu    = `<nav><ul><li><a href="/">Главная</a></li><li><a href="/news">Новости</a></li><li><a href="/about">О сайте</a></li></ul></nav>`
+	footer  = `<footer><p>© 2024 Пример, все права защищены. Перепечатка материалов запрещена.</p></footer>`
+	sidebar = `<div class="sidebar"><p>Подпишитесь на рассылку, чтобы первыми узнавать о новых статьях, акциях и скидках.</p></div>`
+	related = `<div><a href="/1">Похожая статья о поездах и вокзалах</a> <a href="/2">Ещё одна статья о железных дорогах</a></div>`
+)
+
+// articleBody is a few paragraphs long enough to be the main content
+var articleBody = `<h1>Поездка на север</h1>` +
+	`<p>Поезд отправился с первого пути точно по расписанию, и за окном замелькали поля, перелески и маленькие станции.</p>` +
+	`<p>Пассажиры пили чай из стаканов в подстаканниках, разговаривали о погоде, о ценах и о прочитанных книгах.</p>` +
+	`<p>К вечеру небо затянуло облаками, начался мелкий осенний дождь, и он не прекращался до самого утра.</p>`
+
+func TestContentExtraction(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		fullText bool
+		want     []string
+		notWant  []string
+	}{
+		{"article", menu + `<article>` + articleBody + `</article>` + sidebar + footer, false,
+			[]string{"Поездка на север", "Поезд отправился", "осенний дождь"},
+			[]string{"Главная", "Подпишитесь", "права защищены"}},
+		{"div with content class", menu + `<div class="post-content">` + articleBody + `</div>` + footer, false,
+			[]string{"Поезд отправился", "осенний дождь"},
+			[]string{"Новости", "права защищены"}},
+		{"links inside the content", `<article>` + articleBody + related + `</article>`, false,
+			[]string{"осенний дождь"},
+			[]string{"Похожая статья"}},
+		{"boilerplate inside the content", `<main>` + articleBody + `<div class="share-buttons">Поделиться в соцсетях</div>` + `</main>`, false,
+			[]string{"Поезд отправился"},
+			[]string{"Поделиться"}},
+		{"article header", `<header><p>Сайт о путешествиях по России и миру, рассказы и фотографии читателей.</p></header>` +
+			`<article><header><h1>Заголовок статьи</h1><p class="lead">Вводный абзац статьи</p></header>` + articleBody + `</article>`, false,
+			[]string{"Заголовок статьи", "Вводный абзац статьи", "Поезд отправился"},
+			[]string{"Сайт о путешествиях"}},
+		{"short page", menu + `<p>Короткая заметка.</p>`, false,
+			[]string{"Главная", "Короткая заметка."},
+			nil},
+		{"full text", menu + `<article>` + articleBody + `</article>` + footer, true,
+			[]string{"Главная", "Поезд отправился", "права защищены"},
+			nil},
+	}
+
+	for _, test := range tests {
+		e := newTestElement(t, "http://example.com/", "text/html", "<html><body>"+test.body+"</body></html>")
+		var extractor *contentExtractor
+		if !test.fullText {
+			extractor = newContentExtractor()
+		}
+
+		text := parseElement(newTextWriter(false, false), extractor, e)
+		for _, want := range test.want {
+			if !strings.Contains(text, want) {
+				t.Errorf("%s: %q is missing from %q", test.name, want, text)
+			}
+		}
+		for _, notWant := range test.notWant {
+			if strings.Contains(text, notWant) {
+				t.Errorf("%s: %q is in %q", test.name, notWant, text)
+			}
+		}
+	}
+}
+
+func TestContentExtractionIsDeterministic(t *testing.T) {
+	// two blocks in different sections with equal scores, the first one wins
+	paragraph := func(word string) string {
+		return "<p>" + strings.Repeat(word+" ", 60) + "</p>"
+	}
+	body := `<section><div>` + paragraph("первый") + `</div></section>` +
+		`<section><div>` + paragraph("второй") + `</div></section>`
+
+	for i := 0; i < 20; i++ {
+		e := newTestElement(t, "http://example.com/", "text/html", "<html><body>"+body+"</body></html>")
+		text := parseElement(newTextWriter(false, false), newContentExtractor(), e)
+		if !strings.HasPrefix(text, "первый") || strings.Contains(text, "второй") {
+			t.Fatalf("run %d: got %q", i, text)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// DuplicatePolicy tells what happens to a page which is a near-duplicate
+// of an already saved document.
+type DuplicatePolicy int
+
+const (
+	// SkipDuplicates drops near-duplicate pages.
+	SkipDuplicates DuplicatePolicy = iota
+	// AliasDuplicates writes the url of a near-duplicate page to the index
+	// with the id of the original document.
+	AliasDuplicates
+)
+
+const (
+	// DefaultDuplicateDistance is the maximum number of differing fingerprint
+	// bits of two near-duplicate documents, about 95% similarity.
+	DefaultDuplicateDistance = 3
+	// MaxDuplicateDistance is the largest supported distance. Greater
+	// distances make the fingerprint bands too short to narrow the search.
+	MaxDuplicateDistance = 15
+	// NoDuplicateDetection disables near-duplicate detection.
+	NoDuplicateDetection = -1
+
+	shingleSize = 3
+)
+
+var errInvalidDuplicateDistance = errors.New("near-duplicate distance must be in [-1, 15]")
+
+// NearDuplicates sets the maximum Hamming distance between the 64 bit SimHash
+// fingerprints of two documents which are considered near-duplicates, and what
+// to do with them. NoDuplicateDetection turns the detection off.
+func NearDuplicates(maxDistance int, policy DuplicatePolicy) Option {
+	return func(w *htmlTextToFileWriter) {
+		w.duplicateDistance = maxDistance
+		w.duplicatePolicy = policy
+	}
+}
+
+// duplicateDetector finds documents with close SimHash fingerprints.
+//
+// Fingerprints are split into maxDistance+1 bands. Two fingerprints which
+// differ in at most maxDistance bits have at least one equal band, so only
+// the documents sharing a band with the new one are compared.
+type duplicateDetector struct {
+	maxDistance int
+
+	lock         sync.Mutex
+	fingerprints map[int64]uint64
+	bands        []map[uint64][]int64
+}
+
+func newDuplicateDetector(maxDistance int) *duplicateDetector {
+	d := &duplicateDetector{
+		maxDistance:  maxDistance,
+		fingerprints: make(map[int64]uint64),
+		bands:        make([]map[uint64][]int64, maxDistance+1),
+	}
+
+	for i := range d.bands {
+		d.bands[i] = make(map[uint64][]int64)
+	}
+
+	return d
+}
+
+// Register returns the id of a known near-duplicate of the document with
+// the fingerprint. Otherwise it calls newId and remembers the fingerprint
+// under the returned id.
+func (d *duplicateDetector) Register(fingerprint uint64, newId func() int64) (id int64, isDuplicate bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if id, found := d.find(fingerprint); found {
+		return id, true
+	}
+
+	id = newId()
+	d.add(id, fingerprint)
+
+	return id, false
+}
+
+// Add remembers the fingerprint of a document saved by a previous run.
+func (d *duplicateDetector) Add(id int64, fingerprint uint64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, exists := d.fingerprints[id]; !exists {
+		d.add(id, fingerprint)
+	}
+}
+
+// Forget removes a document which could not be saved or has been deleted.
+func (d *duplicateDetector) Forget(id int64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.forget(id)
+}
+
+// Update replaces the fingerprint of a document which has changed.
+func (d *duplicateDetector) Update(id int64, fingerprint uint64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.forget(id)
+	d.add(id, fingerprint)
+}
+
+func (d *duplicateDetector) forget(id int64) {
+	fingerprint, exists := d.fingerprints[id]
+	if !exists {
+		return
+	}
+	delete(d.fingerprints, id)
+
+	for i, band := range d.bands {
+		key := d.bandKey(fingerprint, i)
+		ids := band[key]
+		for j, other := range ids {
+			if other == id {
+				band[key] = append(ids[:j], ids[j+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (d *duplicateDetector) find(fingerprint uint64) (int64, bool) {
+	for i, band := range d.bands {
+		for _, id := range band[d.bandKey(fingerprint, i)] {
+			if bits.OnesCount64(fingerprint^d.fingerprints[id]) <= d.maxDistance {
+				return id, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func (d *duplicateDetector) add(id int64, fingerprint uint64) {
+	d.fingerprints[id] = fingerprint
+
+	for i, band := range d.bands {
+		key := d.bandKey(fingerprint, i)
+		band[key] = append(band[key], id)
+	}
+}
+
+// bandKey returns the bits of the i-th band of the fingerprint
+func (d *duplicateDetector) bandKey(fingerprint uint64, i int) uint64 {
+	from := i * 64 / len(d.bands)
+	to := (i + 1) * 64 / len(d.bands)
+
+	return fingerprint << (64 - to) >> (64 - to + from)
+}
+
+// simHash computes the SimHash fingerprint of the word shingles of text.
+func simHash(text string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var weights [64]int
+	hasher := fnv.New64a()
+
+	for i := 0; i+shingleSize <= len(words) || i == 0 && len(words) > 0; i++ {
+		hasher.Reset()
+		for _, word := range words[i:min(i+shingleSize, len(words))] {
+			hasher.Write([]byte(word))
+			hasher.Write([]byte{' '})
+		}
+
+		hash := hasher.Sum64()
+		for bit := range weights {
+			if hash&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+
+	return fingerprint
+}
@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"context"
+	"math/bits"
+	"os"
+	"strings"
+	"testing"
+)
+
+const article = "Поезд отправился с первого пути точно по расписанию. За окном мелькали поля, " +
+	"перелески и маленькие станции, на которых он не останавливался. Пассажиры пили чай из " +
+	"стаканов в подстаканниках и разговаривали о погоде, о ценах и о прочитанных книгах. " +
+	"К вечеру небо затянуло облаками, и начался мелкий осенний дождь, который не прекращался до утра."
+
+// longText is long enough for a changed word to move a few fingerprint bits only
+var longText = strings.Join([]string{article, russianProse, englishProse, ukrainianProse}, " ")
+
+func TestSimHash(t *testing.T) {
+	tests := []struct {
+		name        string
+		a, b        string
+		minDistance int
+		maxDistance int
+	}{
+		{"same text", article, article, 0, 0},
+		{"case and punctuation", article, strings.ToUpper(strings.ReplaceAll(article, ",", "")), 0, 0},
+		{"one word changed", longText, strings.Replace(longText, "осенний", "весенний", 1), 1, DefaultDuplicateDistance},
+		{"different text", article, englishProse, DefaultDuplicateDistance + 10, 64},
+	}
+
+	for _, test := range tests {
+		distance := bits.OnesCount64(simHash(test.a) ^ simHash(test.b))
+		if distance < test.minDistance || distance > test.maxDistance {
+			t.Errorf("%s: distance %d is not in [%d, %d]", test.name, distance, test.minDistance, test.maxDistance)
+		}
+	}
+
+	if simHash("") != 0 {
+		t.Error("the fingerprint of no text is not 0")
+	}
+	if simHash("слово") == 0 {
+		t.Error("a text shorter than a shingle has no fingerprint")
+	}
+}
+
+func TestDuplicateDetector(t *testing.T) {
+	const base = uint64(0xF0F0_F0F0_F0F0_F0F0)
+
+	tests := []struct {
+		name        string
+		maxDistance int
+		other       uint64
+		duplicate   bool
+	}{
+		{"same", 3, base, true},
+		{"one bit", 3, base ^ 1, true},
+		{"spread bits", 3, base ^ (1 | 1<<20 | 1<<63), true},
+		{"too far", 3, base ^ 0xF, false},
+		{"exact only", 0, base ^ 1, false},
+		{"max distance", MaxDuplicateDistance, base ^ 0x7FFF, true},
+	}
+
+	for _, test := range tests {
+		d := newDuplicateDetector(test.maxDistance)
+		d.Add(1, base)
+
+		id, isDuplicate := d.Register(test.other, func() int64 { return 2 })
+		if isDuplicate != test.duplicate {
+			t.Errorf("%s: duplicate %v", test.name, isDuplicate)
+		}
+		if wantId := map[bool]int64{true: 1, false: 2}[test.duplicate]; id != wantId {
+			t.Errorf("%s: id %d, want %d", test.name, id, wantId)
+		}
+	}
+}
+
+func TestDuplicateDetectorForgetAndUpdate(t *testing.T) {
+	d := newDuplicateDetector(DefaultDuplicateDistance)
+	d.Add(1, 0xFF)
+
+	d.Update(1, 0xFF00)
+	if _, isDuplicate := d.Register(0xFF, func() int64 { return 2 }); isDuplicate {
+		t.Error("the old fingerprint of an updated document is kept")
+	}
+	if id, isDuplicate := d.Register(0xFF01, func() int64 { return 3 }); !isDuplicate || id != 1 {
+		t.Errorf("the new fingerprint of an updated document is not found, got %d %v", id, isDuplicate)
+	}
+
+	d.Forget(1)
+	if _, isDuplicate := d.Register(0xFF00, func() int64 { return 4 }); isDuplicate {
+		t.Error("a forgotten document is still found")
+	}
+}
+
+func TestNearDuplicatePolicies(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    DuplicatePolicy
+		indexRows int
+	}{
+		{"skip", SkipDuplicates, 1},
+		{"alias", AliasDuplicates, 2},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		w, err := New(context.Background(), dir, 1, Filters(), NearDuplicates(DefaultDuplicateDistance, test.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range []string{"http://example.com/a", "http://example.com/a-copy"} {
+			w.Process(*newTestElement(t, u, "text/html", "<html><body><p>"+article+"</p></body></html>"))
+		}
+		w.Complete()
+		w.Wait()
+
+		stats := w.Stats()
+		if stats["accepted"] != 1 || stats["rejected_duplicate"] != 1 {
+			t.Errorf("%s: unexpected stats %v", test.name, stats)
+		}
+		data, err := os.ReadFile(indexFilePath(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rows := strings.Count(string(data), "\n"); rows != test.indexRows {
+			t.Errorf("%s: %d index entries, want %d", test.name, rows, test.indexRows)
+		}
+	}
+}
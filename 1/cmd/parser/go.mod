@@ -4,11 +4,11 @@ go 1.24.1
 
 require (
 	colly v0.0.1
+	github.com/PuerkitoBio/goquery v1.5.1
 	golang.org/x/net v0.17.0
 )
 
 require (
-	github.com/PuerkitoBio/goquery v1.5.1 // indirect
 	github.com/andybalholm/cascadia v1.3.1 // indirect
 	github.com/antchfx/htmlquery v1.2.3 // indirect
 	github.com/antchfx/xmlquery v1.3.4 // indirect
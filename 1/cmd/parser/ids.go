@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"bufio"
+	"colly"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func idsFilePath(dir string) string {
+	return filepath.Join(dir, "ids.tsv")
+}
+
+// idRegistry gives every page a document id which does not change between
+// crawls. The id is derived from the hash of the canonical url of the page,
+// so it does not depend on the order the pages are parsed in. The ids are
+// kept in ids.tsv, one "id\turl" line per url, so an id which had to be
+// moved because of a hash collision stays where it was moved to.
+type idRegistry struct {
+	lock sync.Mutex
+	ids  map[string]int64
+	urls map[int64]string
+	file *os.File
+}
+
+// openIdRegistry reads the registry at path. The ids of documents, the
+// documents saved by previous runs, are taken, so no url gets them even if
+// these documents got their ids before the registry existed.
+func openIdRegistry(path string, documents map[string]int64) (*idRegistry, error) {
+	r := &idRegistry{
+		ids:  make(map[string]int64),
+		urls: make(map[int64]string),
+	}
+	for u, id := range documents {
+		r.urls[id] = u
+	}
+
+	if err := r.read(path); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r.file = file
+
+	return r, nil
+}
+
+func (r *idRegistry) read(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		idStr, u, found := strings.Cut(scanner.Text(), "\t")
+		if !found {
+			continue
+		}
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		r.ids[u] = id
+		r.urls[id] = u
+	}
+
+	return scanner.Err()
+}
+
+// Id returns the id of the page with the canonical url u
+func (r *idRegistry) Id(u string) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if id, found := r.ids[u]; found {
+		return id, nil
+	}
+
+	id := urlHashId(u)
+	for {
+		if owner, taken := r.urls[id]; !taken || owner == u {
+			break
+		}
+		// a collision, the next free id is taken instead
+		if id++; id == math.MaxInt64 {
+			id = 1
+		}
+	}
+
+	if _, err := fmt.Fprintf(r.file, "%d\t%s\n", id, u); err != nil {
+		return 0, err
+	}
+	r.ids[u] = id
+	r.urls[id] = u
+
+	return id, nil
+}
+
+func (r *idRegistry) Close() error {
+	return r.file.Close()
+}
+
+// urlHashId returns the positive 63 bit number
+// at the start of the SHA-256 hash of u
+func urlHashId(u string) int64 {
+	hash := sha256.Sum256([]byte(u))
+	id := int64(binary.BigEndian.Uint64(hash[:8]) >> 1)
+	if id == 0 {
+		return 1
+	}
+
+	return id
+}
+
+// pageIdUrl returns the url the id of the page e is derived from,
+// its canonical url if the crawler has set one and decodedUrl otherwise
+func pageIdUrl(e *colly.HTMLElement, decodedUrl string) string {
+	if canonical := e.Request.Ctx.Get(colly.CanonicalURLCtxKey); canonical != "" {
+		return canonical
+	}
+
+	return decodedUrl
+}
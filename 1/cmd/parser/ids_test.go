@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIdRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ids.tsv")
+	a, b := "http://example.com/a", "http://example.com/b"
+
+	// a document of a previous run already has the hash id of b
+	r, err := openIdRegistry(path, map[string]int64{"http://example.com/old": urlHashId(b)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idA, err := r.Id(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idB, err := r.Id(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	if idA != urlHashId(a) {
+		t.Errorf("the id of a is %d instead of its hash %d", idA, urlHashId(a))
+	}
+	if idB != urlHashId(b)+1 {
+		t.Errorf("the id of b is %d, want the next one after the taken %d", idB, urlHashId(b))
+	}
+
+	r, err = openIdRegistry(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	tests := []struct {
+		url  string
+		want int64
+	}{
+		{a, idA},
+		{b, idB},
+		{a, idA},
+	}
+
+	for _, test := range tests {
+		if got, err := r.Id(test.url); err != nil || got != test.want {
+			t.Errorf("Id(%q) = %d, %v after reopening, want %d", test.url, got, err, test.want)
+		}
+	}
+}
+
+func TestUrlHashId(t *testing.T) {
+	for _, u := range []string{"", "http://example.com/", "http://example.com/a"} {
+		if id := urlHashId(u); id <= 0 || id != urlHashId(u) {
+			t.Errorf("urlHashId(%q) = %d", u, id)
+		}
+	}
+	if urlHashId("http://example.com/a") == urlHashId("http://example.com/b") {
+		t.Error("different urls have the same id")
+	}
+}
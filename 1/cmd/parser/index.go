@@ -0,0 +1,231 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// csvIndexHeader is the header of index.txt, the index of older runs
+const csvIndexHeader = "id,url,lang,simhash"
+
+func csvIndexFilePath(dir string) string {
+	return filepath.Join(dir, "index.txt")
+}
+
+func indexFilePath(dir string) string {
+	return filepath.Join(dir, "index.json")
+}
+
+func recordsFilePath(dir string) string {
+	return filepath.Join(dir, "documents.jsonl")
+}
+
+func deletedFilePath(dir string) string {
+	return filepath.Join(dir, "deleted.txt")
+}
+
+// indexEntry is a line of index.json, the metadata of a saved document or
+// of an alias of a near-duplicate. The values are strings, which the
+// following stages read.
+type indexEntry struct {
+	Id      string `json:"id"`
+	Url     string `json:"url"`
+	Lang    string `json:"lang"`
+	SimHash string `json:"simhash,omitempty"`
+}
+
+func newIndexEntry(meta indexMeta) indexEntry {
+	return indexEntry{
+		Id:      fmt.Sprint(meta.fileId),
+		Url:     meta.url,
+		Lang:    meta.lang,
+		SimHash: fmt.Sprintf("%016x", meta.fingerprint),
+	}
+}
+
+// loadIndex returns the entries of the index in dir. The index of an older
+// run, index.txt or an index.json holding an array, is rewritten as JSON
+// Lines, so the new entries can be appended to it. So is an index with a
+// line cut by a crash. A missing index has no entries.
+func loadIndex(dir string) ([]indexEntry, error) {
+	entries, err := readCSVIndex(csvIndexFilePath(dir))
+	if err == nil {
+		if err := rewriteIndex(indexFilePath(dir), entries); err != nil {
+			return nil, err
+		}
+		return entries, os.Remove(csvIndexFilePath(dir))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	entries, lines, err := readIndex(indexFilePath(dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !lines {
+		err = rewriteIndex(indexFilePath(dir), entries)
+	}
+
+	return entries, err
+}
+
+// readIndex reads index.json. lines is false if the index is not in JSON
+// Lines or its last line is cut.
+func readIndex(path string) (entries []indexEntry, lines bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	decoder := json.NewDecoder(reader)
+	first, err := firstByte(reader)
+	if err == io.EOF {
+		return nil, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	if first == '[' {
+		err = decoder.Decode(&entries)
+		return entries, false, err
+	}
+
+	for {
+		var entry indexEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return entries, true, nil
+		} else if errors.Is(err, io.ErrUnexpectedEOF) {
+			return entries, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// firstByte returns the first byte of reader which is not a space
+// without consuming it
+func firstByte(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			return b[0], nil
+		}
+		reader.Discard(1)
+	}
+}
+
+// readCSVIndex reads index.txt of an older run, which may have fewer columns
+func readCSVIndex(path string) ([]indexEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := strings.Split(csvIndexHeader, ",")
+	scanner := bufio.NewScanner(file)
+	if scanner.Scan() {
+		header = strings.Split(scanner.Text(), ",")
+	}
+	// the columns after the url
+	trailingColumns := len(header) - 2
+
+	var entries []indexEntry
+	for scanner.Scan() {
+		id, rest, found := strings.Cut(scanner.Text(), ",")
+		if !found {
+			continue
+		}
+
+		// urls may contain commas, the other columns never do
+		columns := strings.Split(rest, ",")
+		if len(columns) <= trailingColumns {
+			continue
+		}
+
+		entry := indexEntry{Id: id, Url: strings.Join(columns[:len(columns)-trailingColumns], ",")}
+		for i, value := range columns[len(columns)-trailingColumns:] {
+			switch header[2+i] {
+			case "lang":
+				entry.Lang = value
+			case "simhash":
+				entry.SimHash = value
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// rewriteIndex replaces the index at path with entries atomically
+func rewriteIndex(path string, entries []indexEntry) error {
+	tmpPath := path + "~"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+
+	encoder := json.NewEncoder(writer)
+	for _, entry := range entries {
+		if err = encoder.Encode(entry); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = writer.Flush()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+
+	return err
+}
+
+// writeIndex appends every index entry to index.json as a line of JSON once
+// it is received, so the index is complete up to the last saved document
+// even if the process dies.
+func writeIndex(dir string, toWrite <-chan indexMeta) {
+	file, err := os.OpenFile(indexFilePath(dir), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// the encoder writes every entry with a single write
+	encoder := json.NewEncoder(file)
+	for meta := range toWrite {
+		if err := encoder.Encode(newIndexEntry(meta)); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		log.Fatalln(err)
+	}
+}
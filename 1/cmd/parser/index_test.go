@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadIndex(t *testing.T) {
+	entries := []indexEntry{
+		{Id: "1", Url: "http://example.com/a,b", Lang: "ru", SimHash: "00000000000000ff"},
+		{Id: "2", Url: "http://example.com/c", Lang: "en", SimHash: "0000000000000001"},
+	}
+	lines := `{"id":"1","url":"http://example.com/a,b","lang":"ru","simhash":"00000000000000ff"}
+{"id":"2","url":"http://example.com/c","lang":"en","simhash":"0000000000000001"}
+`
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []indexEntry
+	}{
+		{"missing", nil, nil},
+		{"lines", map[string]string{"index.json": lines}, entries},
+		{"cut line", map[string]string{"index.json": lines + `{"id":"3","url":"http://exa`}, entries},
+		{"array", map[string]string{"index.json": "[\n" + strings.Replace(strings.TrimSpace(lines), "\n", ",\n", 1) + "\n]\n"}, entries},
+		{"csv", map[string]string{
+			"index.txt":  "id,url,lang,simhash\n1,http://example.com/a,b,ru,00000000000000ff\n2,http://example.com/c,en,0000000000000001\n",
+			"index.json": "[]",
+		}, entries},
+		{"csv without simhash", map[string]string{"index.txt": "id,url,lang\n1,http://example.com/a,b,ru\n"}, []indexEntry{
+			{Id: "1", Url: "http://example.com/a,b", Lang: "ru"},
+		}},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		for name, content := range test.files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := loadIndex(dir)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+
+		if _, err := os.Stat(csvIndexFilePath(dir)); err == nil {
+			t.Errorf("%s: index.txt is kept", test.name)
+		}
+		if test.files == nil {
+			continue
+		}
+		// the index can be appended to
+		if reread, lines, err := readIndex(indexFilePath(dir)); err != nil || !lines || !reflect.DeepEqual(reread, test.want) {
+			t.Errorf("%s: rewritten as %v, in lines %v, %v", test.name, reread, lines, err)
+		}
+	}
+}
+
+func TestWriteIndex(t *testing.T) {
+	dir := t.TempDir()
+	toWrite := make(chan indexMeta)
+	done := make(chan struct{})
+	go func() {
+		writeIndex(dir, toWrite)
+		close(done)
+	}()
+
+	toWrite <- indexMeta{fileId: 7, url: "http://example.com/", lang: "ru", fingerprint: 255}
+	want := `{"id":"7","url":"http://example.com/","lang":"ru","simhash":"00000000000000ff"}` + "\n"
+
+	// the entry is written before the index is closed
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, _ := os.ReadFile(indexFilePath(dir))
+		if string(data) == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("index.json is %q, want %q", data, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(toWrite)
+	<-done
+}
@@ -0,0 +1,210 @@
+package parser
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxNgramSize = 3
+	// maxDetectedRunes bounds the part of a document used for detection,
+	// the beginning of a long text is enough to tell its language
+	maxDetectedRunes = 8000
+	// confidentMargin is the margin per n-gram between the log likelihoods
+	// of the two most likely languages which gives a confidence of 1-1/e.
+	// Closely related languages, e.g. Russian and Ukrainian, differ by
+	// about 0.15 on ordinary prose.
+	confidentMargin = 0.05
+	// confidentNgrams is the number of n-grams a text needs to be detected
+	// with full confidence, about 15 words
+	confidentNgrams = 100
+)
+
+// DefaultAcceptedLanguages are the languages of the documents kept by the parser.
+var DefaultAcceptedLanguages = []string{"ru"}
+
+// AcceptedLanguages replaces DefaultAcceptedLanguages with langs,
+// given as ISO 639-1 codes.
+func AcceptedLanguages(langs ...string) Option {
+	return func(w *htmlTextToFileWriter) {
+		w.acceptedLanguages = make(map[string]struct{}, len(langs))
+		for _, lang := range langs {
+			w.acceptedLanguages[strings.ToLower(lang)] = struct{}{}
+		}
+	}
+}
+
+// MinLanguageConfidence drops the documents whose language is detected
+// with a confidence below minConfidence.
+func MinLanguageConfidence(minConfidence float64) Option {
+	return func(w *htmlTextToFileWriter) {
+		w.minLanguageConfidence = minConfidence
+	}
+}
+
+// ngramProfile holds the counts of the character n-grams of a text
+type ngramProfile struct {
+	counts map[string]float64
+	total  float64
+}
+
+// languageModel is the smoothed n-gram distribution of a language
+type languageModel struct {
+	logProbabilities     map[string]float64
+	unseenLogProbability float64
+}
+
+var languageModels = buildLanguageModels()
+
+// buildLanguageModels estimates the n-gram probabilities of every sample
+// with additive smoothing, so the n-grams which are missing in a sample,
+// e.g. letters absent in the alphabet, get a small but non-zero probability.
+func buildLanguageModels() map[string]languageModel {
+	const smoothing = 0.5
+
+	profiles := make(map[string]ngramProfile, len(languageSamples))
+	vocabulary := make(map[string]struct{})
+	for lang, sample := range languageSamples {
+		profile := newNgramProfile(sample)
+		profiles[lang] = profile
+
+		for ngram := range profile.counts {
+			vocabulary[ngram] = struct{}{}
+		}
+	}
+
+	models := make(map[string]languageModel, len(profiles))
+	for lang, profile := range profiles {
+		denominator := profile.total + smoothing*float64(len(vocabulary)+1)
+
+		model := languageModel{
+			logProbabilities:     make(map[string]float64, len(profile.counts)),
+			unseenLogProbability: math.Log(smoothing / denominator),
+		}
+		for ngram, count := range profile.counts {
+			model.logProbabilities[ngram] = math.Log((count + smoothing) / denominator)
+		}
+
+		models[lang] = model
+	}
+
+	return models
+}
+
+// newNgramProfile counts the 1 to 3 letter n-grams of the words of text.
+// Words are padded with spaces, so n-grams at word boundaries are distinct.
+func newNgramProfile(text string) ngramProfile {
+	profile := ngramProfile{counts: make(map[string]float64)}
+	word := make([]rune, 0, 32)
+	runes := 0
+
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+
+		padded := make([]rune, 0, len(word)+2)
+		padded = append(padded, ' ')
+		padded = append(padded, word...)
+		padded = append(padded, ' ')
+
+		for n := 1; n <= maxNgramSize; n++ {
+			for i := 0; i+n <= len(padded); i++ {
+				if n == 1 && padded[i] == ' ' {
+					continue
+				}
+				profile.counts[string(padded[i:i+n])]++
+				profile.total++
+			}
+		}
+
+		word = word[:0]
+	}
+
+	for _, r := range text {
+		if runes >= maxDetectedRunes {
+			break
+		}
+		runes++
+
+		if unicode.IsLetter(r) {
+			word = append(word, unicode.ToLower(r))
+		} else {
+			flush()
+		}
+	}
+	flush()
+
+	return profile
+}
+
+// logLikelihood returns the log probability of the n-grams of profile
+func (m languageModel) logLikelihood(profile ngramProfile) float64 {
+	var result float64
+	for ngram, count := range profile.counts {
+		logProbability, known := m.logProbabilities[ngram]
+		if !known {
+			logProbability = m.unseenLogProbability
+		}
+		result += count * logProbability
+	}
+
+	return result
+}
+
+// letterCoverage returns the share of the letters of profile the model has
+// seen in its sample. The letters of another alphabet are unseen, so it is
+// low for a text in two languages written in different scripts.
+func (m languageModel) letterCoverage(profile ngramProfile) float64 {
+	var letters, known float64
+	for ngram, count := range profile.counts {
+		if len(ngram) > utf8.UTFMax || utf8.RuneCountInString(ngram) != 1 {
+			continue
+		}
+		letters += count
+		if _, seen := m.logProbabilities[ngram]; seen {
+			known += count
+		}
+	}
+	if letters == 0 {
+		return 0
+	}
+
+	return known / letters
+}
+
+// DetectLanguage returns the ISO 639-1 code of the language of text, chosen
+// by a naive Bayes classifier over the character n-grams of the built-in
+// language samples. An empty language is returned for a text without letters.
+//
+// The confidence is in [0, 1]. It grows with the margin per n-gram between
+// the two most likely languages, and is reduced for texts shorter than about
+// 15 words and for the letters the language does not have, so a text in two
+// languages or of a few words gets a low confidence.
+func DetectLanguage(text string) (lang string, confidence float64) {
+	profile := newNgramProfile(text)
+	if profile.total == 0 {
+		return "", 0
+	}
+
+	best, second := math.Inf(-1), math.Inf(-1)
+	for candidate, model := range languageModels {
+		logLikelihood := model.logLikelihood(profile)
+		if logLikelihood > best {
+			lang, best, second = candidate, logLikelihood, best
+		} else if logLikelihood > second {
+			second = logLikelihood
+		}
+	}
+
+	margin := (best - second) / profile.total
+	confidence = 1 - math.Exp(-margin/confidentMargin)
+	confidence *= min(1, profile.total/confidentNgrams)
+	// squared, so a text half in another alphabet is not confident
+	coverage := languageModels[lang].letterCoverage(profile)
+	confidence *= coverage * coverage
+
+	return lang, confidence
+}
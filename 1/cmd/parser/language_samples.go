@@ -0,0 +1,102 @@
+package parser
+
+// languageSamples are the texts the language profiles are built from.
+// Every sample is ordinary prose, so the profiles reflect common words
+// and letter combinations of the language.
+var languageSamples = map[string]string{
+	"ru": `Все люди рождаются свободными и равными в своем достоинстве и правах. Они наделены разумом и совестью
+и должны поступать в отношении друг друга в духе братства. Каждый человек должен обладать всеми правами и всеми
+свободами, провозглашенными настоящей декларацией, без какого бы то ни было различия. Вчера вечером мы долго
+гуляли по старому городу, смотрели на реку и разговаривали о том, что будет с нами через несколько лет. Погода
+была тёплая, на улицах было много людей, которые тоже не хотели идти домой. В библиотеке нашего университета
+хранится больше миллиона книг, и студенты часто занимаются там до позднего вечера. Если вы хотите узнать больше
+о истории этого места, обратитесь к экскурсоводу или прочитайте информацию на сайте музея. Это очень интересно.`,
+
+	"uk": `Усі люди народжуються вільними і рівними у своїй гідності та правах. Вони наділені розумом і совістю
+і повинні діяти у відношенні один до одного в дусі братерства. Кожна людина повинна мати всі права і всі свободи,
+проголошені цією декларацією, незалежно від будь-яких відмінностей. Учора ввечері ми довго гуляли старим містом,
+дивилися на річку і розмовляли про те, що з нами буде через кілька років. Погода була тепла, на вулицях було
+багато людей, які теж не хотіли йти додому. У бібліотеці нашого університету зберігається понад мільйон книжок,
+і студенти часто займаються там до пізнього вечора. Якщо ви хочете дізнатися більше про історію цього місця,
+зверніться до екскурсовода або прочитайте інформацію на сайті музею. Це дуже цікаво, їжте й пийте на здоров'я.`,
+
+	"be": `Усе людзі нараджаюцца свабоднымі і роўнымі ў сваёй годнасці і правах. Яны надзелены розумам і сумленнем
+і павінны ставіцца адзін да аднаго ў духу брацтва. Кожны чалавек павінен мець усе правы і ўсе свабоды, абвешчаныя
+гэтай дэкларацыяй, без якіх бы там ні было адрозненняў. Учора ўвечары мы доўга гулялі па старым горадзе, глядзелі
+на раку і размаўлялі пра тое, што будзе з намі праз некалькі гадоў. Надвор'е было цёплае, на вуліцах было шмат
+людзей, якія таксама не хацелі ісці дадому. У бібліятэцы нашага ўніверсітэта захоўваецца больш за мільён кніг,
+і студэнты часта займаюцца там да позняга вечара. Калі вы хочаце даведацца больш пра гісторыю гэтага месца,
+звярніцеся да экскурсавода або прачытайце інфармацыю на сайце музея. Гэта вельмі цікава.`,
+
+	"bg": `Всички хора се раждат свободни и равни по достойнство и права. Те са надарени с разум и съвест и следва
+да се отнасят помежду си в дух на братство. Всеки човек има право на всички права и свободи, провъзгласени в тази
+декларация, без каквото и да е различие. Вчера вечерта дълго се разхождахме из стария град, гледахме реката и
+говорихме за това какво ще стане с нас след няколко години. Времето беше топло, по улиците имаше много хора,
+които също не искаха да се прибират вкъщи. В библиотеката на нашия университет се съхраняват повече от един
+милион книги и студентите често учат там до късно вечерта. Ако искате да научите повече за историята на това
+място, обърнете се към екскурзовода или прочетете информацията на сайта на музея. Това е много интересно.`,
+
+	"sr": `Сва људска бића рађају се слободна и једнака у достојанству и правима. Она су обдарена разумом и свешћу
+и треба једни према другима да поступају у духу братства. Свакоме припадају сва права и слободе проглашене у овој
+декларацији без икаквих разлика. Јуче увече смо дуго шетали старим градом, гледали реку и разговарали о томе шта
+ће бити са нама за неколико година. Време је било топло, на улицама је било много људи који такође нису желели
+да иду кући. У библиотеци нашег универзитета чува се више од милион књига, и студенти често тамо уче до касно
+увече. Ако желите да сазнате више о историји овог места, обратите се водичу или прочитајте информације на сајту
+музеја. То је веома занимљиво, њихова кућа је близу.`,
+
+	"kk": `Барлық адамдар тумысынан азат және қадір-қасиеті мен кұқықтары тең болып дүниеге келеді. Адамдарға ақыл-парасат,
+ар-ождан берілген, сондықтан олар бір-бірімен туыстық, бауырмалдық қарым-қатынас жасаулары тиіс. Кеше кешке біз
+ескі қаланы ұзақ араладық, өзенге қарап, бірнеше жылдан кейін бізбен не болатыны туралы сөйлестік. Ауа райы
+жылы болды, көшелерде үйге барғысы келмейтін адамдар көп болды. Біздің университеттің кітапханасында миллионнан
+астам кітап сақталады, студенттер онда кешке дейін жиі оқиды. Егер сіз осы жердің тарихы туралы көбірек білгіңіз
+келсе, экскурсоводқа хабарласыңыз немесе мұражайдың сайтындағы ақпаратты оқыңыз. Бұл өте қызықты.`,
+
+	"en": `All human beings are born free and equal in dignity and rights. They are endowed with reason and conscience
+and should act towards one another in a spirit of brotherhood. Everyone is entitled to all the rights and freedoms
+set forth in this declaration, without distinction of any kind. Yesterday evening we walked around the old town
+for a long time, looked at the river and talked about what would happen to us in a few years. The weather was warm,
+and there were many people in the streets who did not want to go home either. The library of our university keeps
+more than a million books, and students often study there until late in the evening. If you would like to learn
+more about the history of this place, ask the guide or read the information on the website of the museum.`,
+
+	"de": `Alle Menschen sind frei und gleich an Würde und Rechten geboren. Sie sind mit Vernunft und Gewissen begabt
+und sollen einander im Geist der Brüderlichkeit begegnen. Jeder hat Anspruch auf alle in dieser Erklärung
+verkündeten Rechte und Freiheiten ohne irgendeinen Unterschied. Gestern Abend sind wir lange durch die Altstadt
+gelaufen, haben auf den Fluss geschaut und darüber gesprochen, was in ein paar Jahren mit uns sein wird. Das Wetter
+war warm, und auf den Straßen waren viele Leute, die auch nicht nach Hause gehen wollten. Die Bibliothek unserer
+Universität bewahrt mehr als eine Million Bücher auf, und die Studenten lernen dort oft bis spät am Abend. Wenn Sie
+mehr über die Geschichte dieses Ortes erfahren möchten, wenden Sie sich an den Führer oder lesen Sie die Webseite.`,
+
+	"fr": `Tous les êtres humains naissent libres et égaux en dignité et en droits. Ils sont doués de raison et de
+conscience et doivent agir les uns envers les autres dans un esprit de fraternité. Chacun peut se prévaloir de tous
+les droits et de toutes les libertés proclamés dans la présente déclaration, sans distinction aucune. Hier soir,
+nous nous sommes longtemps promenés dans la vieille ville, nous avons regardé la rivière et parlé de ce que nous
+deviendrions dans quelques années. Il faisait chaud, et il y avait beaucoup de gens dans les rues qui ne voulaient
+pas non plus rentrer chez eux. La bibliothèque de notre université conserve plus d'un million de livres, et les
+étudiants y travaillent souvent jusqu'à tard le soir. Pour en savoir plus sur l'histoire de ce lieu, adressez-vous
+au guide ou lisez les informations sur le site du musée.`,
+
+	"es": `Todos los seres humanos nacen libres e iguales en dignidad y derechos y, dotados como están de razón y
+conciencia, deben comportarse fraternalmente los unos con los otros. Toda persona tiene todos los derechos y
+libertades proclamados en esta declaración, sin distinción alguna. Ayer por la tarde paseamos mucho tiempo por la
+ciudad vieja, miramos el río y hablamos de lo que sería de nosotros dentro de unos años. Hacía calor y en las calles
+había mucha gente que tampoco quería volver a casa. La biblioteca de nuestra universidad guarda más de un millón de
+libros, y los estudiantes a menudo estudian allí hasta tarde por la noche. Si quiere saber más sobre la historia de
+este lugar, pregunte al guía o lea la información en la página del museo. Es muy interesante.`,
+
+	"it": `Tutti gli esseri umani nascono liberi ed eguali in dignità e diritti. Essi sono dotati di ragione e di
+coscienza e devono agire gli uni verso gli altri in spirito di fratellanza. Ad ogni individuo spettano tutti i
+diritti e tutte le libertà enunciate nella presente dichiarazione, senza distinzione alcuna. Ieri sera abbiamo
+passeggiato a lungo per la città vecchia, abbiamo guardato il fiume e parlato di cosa sarebbe stato di noi fra
+qualche anno. Faceva caldo e per le strade c'era molta gente che non voleva tornare a casa. La biblioteca della nostra
+università conserva più di un milione di libri e gli studenti spesso studiano lì fino a tarda sera. Se volete sapere
+di più sulla storia di questo luogo, rivolgetevi alla guida o leggete le informazioni sul sito del museo.`,
+
+	"pl": `Wszyscy ludzie rodzą się wolni i równi pod względem swej godności i swych praw. Są oni obdarzeni rozumem i
+sumieniem i powinni postępować wobec innych w duchu braterstwa. Każdy człowiek posiada wszystkie prawa i wolności
+zawarte w niniejszej deklaracji bez względu na jakiekolwiek różnice. Wczoraj wieczorem długo spacerowaliśmy po
+starym mieście, patrzyliśmy na rzekę i rozmawialiśmy o tym, co będzie z nami za kilka lat. Pogoda była ciepła, a na
+ulicach było wielu ludzi, którzy też nie chcieli wracać do domu. Biblioteka naszego uniwersytetu przechowuje ponad
+milion książek, a studenci często uczą się tam do późnego wieczora. Jeśli chcą Państwo dowiedzieć się więcej o
+historii tego miejsca, proszę zwrócić się do przewodnika lub przeczytać informacje na stronie muzeum.`,
+}
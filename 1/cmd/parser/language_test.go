@@ -0,0 +1,65 @@
+package parser
+
+import "testing"
+
+const (
+	russianProse   = "Вчера вечером я читал интересную книгу о путешествиях по северным морям. Автор подробно описывает, как команда корабля боролась со штормами и льдами, и как им удалось вернуться домой после долгой зимовки. Мне особенно понравились главы о жизни местных жителей и их традициях."
+	ukrainianProse = "Вчора ввечері я читав цікаву книжку про подорожі північними морями. Автор докладно описує, як команда корабля боролася зі штормами та кригою, і як їм вдалося повернутися додому після довгої зимівлі."
+	englishProse   = "Yesterday evening I was reading an interesting book about travelling across the northern seas. The author describes in detail how the crew fought storms and ice and how they managed to return home after a long winter."
+	mixedProse     = "Вчера вечером я читал интересную книгу о путешествиях. Yesterday evening I was reading an interesting book about travelling across the northern seas. Автор подробно описывает, как команда корабля боролась со штормами. The author describes in detail how the crew fought storms."
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		lang          string
+		minConfidence float64
+		maxConfidence float64
+	}{
+		{"russian", russianProse, "ru", 0.9, 1},
+		{"ukrainian", ukrainianProse, "uk", 0.9, 1},
+		{"english", englishProse, "en", 0.9, 1},
+		{"no letters", "123 456 !!!", "", 0, 0},
+		{"mixed", mixedProse, "", 0, 0.5},
+		{"short", "Привет, как дела?", "", 0, 0.5},
+		{"single word", "книга", "", 0, 0.5},
+	}
+
+	for _, test := range tests {
+		lang, confidence := DetectLanguage(test.text)
+		if test.lang != "" && lang != test.lang {
+			t.Errorf("%s: detected %s", test.name, lang)
+		}
+		if confidence < test.minConfidence || confidence > test.maxConfidence {
+			t.Errorf("%s: confidence %.3f is not in [%.2f, %.2f]", test.name, confidence, test.minConfidence, test.maxConfidence)
+		}
+	}
+}
+
+func TestLanguageFilter(t *testing.T) {
+	filter := LanguageFilter([]string{"ru", "EN"}, 0.5)
+
+	tests := []struct {
+		name     string
+		text     string
+		accepted bool
+	}{
+		{"russian", russianProse, true},
+		{"english", englishProse, true},
+		{"ukrainian", ukrainianProse, false},
+		{"mixed", mixedProse, false},
+		{"short", "Привет, как дела?", false},
+	}
+
+	for _, test := range tests {
+		page := &Page{Text: test.text}
+		reason := filter.Check(page)
+		if accepted := reason == ""; accepted != test.accepted {
+			t.Errorf("%s: accepted %v, reason %q", test.name, accepted, reason)
+		}
+		if page.Lang == "" {
+			t.Errorf("%s: the language is not set", test.name)
+		}
+	}
+}
@@ -5,11 +5,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/fs"
 	"log"
 	"net/url"
 	"os"
-	"strings"
+	"strconv"
 	"sync"
 	"sync/atomic"
 
@@ -21,18 +20,52 @@ import (
 const targetWordsCount int = 1000
 
 type htmlTextToFileWriter struct {
-	wg               sync.WaitGroup
-	toParseQueue     chan colly.HTMLElement
-	parsedPages      int64
-	gotRequestToStop uint32
+	wg                sync.WaitGroup
+	dir               string
+	toParseQueue      chan colly.HTMLElement
+	parsedPages       int64
+	gotRequestToStop  uint32
+	duplicateDistance int
+	duplicatePolicy   DuplicatePolicy
+	duplicates        *duplicateDetector
+	documents         *documentUrls
+	ids               *idRegistry
+	// deleted are the ids listed in deleted.txt
+	deleted     map[int64]struct{}
+	deletedLock sync.Mutex
+
+	acceptedLanguages     map[string]struct{}
+	minLanguageConfidence float64
+
+	fullText     bool
+	imageAltText bool
+	linkMarkers  bool
+	noTextFiles  bool
+	sinks        []DocumentSink
+	sinkQueues   []chan *pendingDocument
+
+	filters          []Filter
+	moreFilters      []Filter
+	rejectionLogPath string
+	rejections       *rejections
+
+	failedWrites   int64
+	updatedPages   int64
+	unchangedPages int64
+	deletedPages   int64
 }
 
+// Option configures the parser.
+type Option func(*htmlTextToFileWriter)
+
 type indexMeta struct {
-	fileId int64
-	url    string
+	fileId      int64
+	url         string
+	lang        string
+	fingerprint uint64
 }
 
-func New(context context.Context, distanationPath string, workersCount int) (*htmlTextToFileWriter, error) {
+func New(context context.Context, distanationPath string, workersCount int, options ...Option) (*htmlTextToFileWriter, error) {
 	if workersCount < 1 {
 		return nil, errors.New("pass at least 1 worker")
 	}
@@ -41,52 +74,157 @@ func New(context context.Context, distanationPath string, workersCount int) (*ht
 	}
 
 	w := htmlTextToFileWriter{
-		wg:               sync.WaitGroup{},
-		toParseQueue:     make(chan colly.HTMLElement, workersCount*2),
-		parsedPages:      0,
-		gotRequestToStop: 0,
+		wg:                sync.WaitGroup{},
+		toParseQueue:      make(chan colly.HTMLElement, workersCount*2),
+		gotRequestToStop:  0,
+		duplicateDistance: DefaultDuplicateDistance,
+		duplicatePolicy:   SkipDuplicates,
+	}
+
+	AcceptedLanguages(DefaultAcceptedLanguages...)(&w)
+	for _, option := range options {
+		option(&w)
+	}
+
+	if w.duplicateDistance < NoDuplicateDetection || w.duplicateDistance > MaxDuplicateDistance {
+		return nil, errInvalidDuplicateDistance
+	}
+	if w.duplicateDistance != NoDuplicateDetection {
+		w.duplicates = newDuplicateDetector(w.duplicateDistance)
+	}
+
+	w.dir = distanationPath
+	deleted, err := readDeletedIds(deletedFilePath(distanationPath))
+	if err != nil {
+		return nil, err
+	}
+	w.deleted = deleted
+	documents := prepareDir(distanationPath, deleted, w.duplicates)
+	w.documents = newDocumentUrls(documents)
+	w.parsedPages = int64(len(documents))
+	ids, err := openIdRegistry(idsFilePath(distanationPath), documents)
+	if err != nil {
+		return nil, err
+	}
+	w.ids = ids
+	if w.sinks == nil {
+		sinks, err := defaultSinks(distanationPath, w.noTextFiles)
+		if err != nil {
+			return nil, err
+		}
+		w.sinks = sinks
+	}
+
+	if w.filters == nil {
+		w.filters = w.defaultFilters()
+	}
+	w.filters = append(w.filters, w.moreFilters...)
+	stages := make([]string, 0, len(w.filters)+1)
+	for _, filter := range w.filters {
+		stages = append(stages, filter.Name())
+	}
+	if w.duplicates != nil {
+		stages = append(stages, duplicateStage)
+	}
+	w.rejections, err = newRejections(stages, w.rejectionLogPath)
+	if err != nil {
+		return nil, err
 	}
 
-	cleanUpDir(distanationPath)
 	setupWorkersAndFinish(context, &w, distanationPath, workersCount)
 
 	return &w, nil
 }
 
-func cleanUpDir(distanationPath string) {
-	exists := false
-	_, err := os.Stat(distanationPath)
-	if err == nil {
-		exists = true
-	} else if errors.Is(err, fs.ErrNotExist) {
-		exists = false
-	} else {
+// prepareDir creates the output dir if needed and returns the ids of the
+// documents which were already written into it by previous runs by their
+// urls, so a page fetched again keeps its id. The deleted documents and the
+// aliases of near-duplicates are left out. The fingerprints of the documents
+// are passed to duplicates unless it is nil.
+func prepareDir(distanationPath string, deleted map[int64]struct{}, duplicates *duplicateDetector) map[string]int64 {
+	documents := make(map[string]int64)
+
+	err := os.MkdirAll(distanationPath, 0755)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	entries, err := loadIndex(distanationPath)
+	if err != nil {
 		log.Fatalln(err)
 	}
 
-	if exists {
-		err := os.RemoveAll(distanationPath)
+	owners := make(map[int64]string)
+	fingerprints := make(map[int64]uint64)
+	for _, entry := range entries {
+		id, err := strconv.ParseInt(entry.Id, 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, isDeleted := deleted[id]; isDeleted {
+			continue
+		}
+
+		// the first entry of an id belongs to the document, the following
+		// ones are its updates or the aliases of its near-duplicates
+		u := entry.Url
+		if owner, exists := owners[id]; !exists {
+			owners[id] = u
+			documents[u] = id
+		} else if owner != u {
+			continue
+		}
+
+		if fingerprint, err := strconv.ParseUint(entry.SimHash, 16, 64); err == nil {
+			fingerprints[id] = fingerprint
+		}
+	}
+
+	if duplicates != nil {
+		for id, fingerprint := range fingerprints {
+			duplicates.Add(id, fingerprint)
+		}
+	}
+
+	return documents
+}
+
+// defaultSinks returns the sinks which write the text files of the
+// documents to dir, unless noTextFiles, and their records to documents.jsonl
+func defaultSinks(dir string, noTextFiles bool) ([]DocumentSink, error) {
+	var sinks []DocumentSink
+	if !noTextFiles {
+		directory, err := NewDirectorySink(dir)
 		if err != nil {
-			log.Fatalln(err)
+			return nil, err
 		}
+		sinks = append(sinks, directory)
 	}
 
-	err = os.Mkdir(distanationPath, os.ModeDir)
+	records, err := NewJSONLSink(recordsFilePath(dir))
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
+
+	return append(sinks, records), nil
 }
 
 func setupWorkersAndFinish(ctx context.Context, w *htmlTextToFileWriter, distanationPath string, workersCount int) {
 
 	toIndexChan := make(chan indexMeta, workersCount)
+	workers := sync.WaitGroup{}
 
 	for range workersCount {
-		w.wg.Add(1)
+		workers.Add(1)
 
 		go func() {
-			defer w.wg.Done()
-			sb := &strings.Builder{}
+			defer workers.Done()
+			text := newTextWriter(w.imageAltText, w.linkMarkers)
+
+			var extractor *contentExtractor
+			if !w.fullText {
+				extractor = newContentExtractor()
+			}
 
 			for {
 				select {
@@ -97,91 +235,231 @@ func setupWorkersAndFinish(ctx context.Context, w *htmlTextToFileWriter, distana
 						return
 					}
 
-					w.parseOne(sb, &distanationPath, &html, toIndexChan)
+					w.parseOne(text, extractor, &html, toIndexChan)
+				}
+			}
+		}()
+	}
+
+	// every sink writes the documents from its own queue, so a slow sink
+	// holds the workers back only once its queue is full
+	sinkWorkers := sync.WaitGroup{}
+	for _, sink := range w.sinks {
+		queue := make(chan *pendingDocument, sinkQueueSize)
+		w.sinkQueues = append(w.sinkQueues, queue)
+
+		sinkWorkers.Add(1)
+		go func() {
+			defer sinkWorkers.Done()
+			for pending := range queue {
+				if pending.deleted {
+					if err := sink.Delete(pending.document.Id); err != nil {
+						log.Println(err)
+					}
+					continue
 				}
+				w.written(pending, sink.Write(pending.document), toIndexChan)
 			}
 		}()
 	}
 
-	go writeIndex(ctx, distanationPath, toIndexChan)
+	// the index is written until the last document is written by the sinks,
+	// so every saved document gets its index entry even when ctx is cancelled
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		writeIndex(distanationPath, toIndexChan)
+		w.closeSinks()
+		if err := w.ids.Close(); err != nil {
+			log.Println(err)
+		}
+		if err := w.rejections.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	go func() {
+		workers.Wait()
+		for _, queue := range w.sinkQueues {
+			close(queue)
+		}
+		sinkWorkers.Wait()
+		close(toIndexChan)
+	}()
 }
 
-func writeIndex(ctx context.Context, dir string, toWrite <-chan indexMeta) {
-	indexPath := fmt.Sprintf("%s\\index.txt", dir)
-	file, err := os.Create(indexPath)
+func (w *htmlTextToFileWriter) parseOne(text *textWriter, extractor *contentExtractor, e *colly.HTMLElement, toIndexFile chan<- indexMeta) {
+	decodedUrl, err := url.QueryUnescape(e.Request.URL.String())
 	if err != nil {
-		log.Fatalln(err)
+		log.Println(err)
+		return
 	}
-	defer file.Close()
 
-	_, err = file.WriteString("id,url\n")
-	if err != nil {
-		log.Fatalln(err)
+	page := &Page{Url: decodedUrl, Element: e, Text: parseElement(text, extractor, e)}
+	for _, filter := range w.filters {
+		if reason := filter.Check(page); reason != "" {
+			w.reject(page, filter.Name(), reason)
+			return
+		}
+	}
+	if page.Lang == "" {
+		page.Lang, page.LangConfidence = DetectLanguage(page.Text)
 	}
-LOOP:
-	for {
-		select {
-		case <-ctx.Done():
-			break LOOP
-		case info, ok := <-toWrite:
-			if !ok {
-				break LOOP
-			}
 
-			_, err = file.WriteString(fmt.Sprintf("%d,%s\n", info.fileId, info.url))
-			if err != nil {
-				log.Fatalln(err)
+	fingerprint := simHash(page.Text)
+	fileNumber, isUpdate := w.documents.Id(decodedUrl)
+	if !isUpdate {
+		fileNumber, err = w.ids.Id(pageIdUrl(e, decodedUrl))
+		if err != nil {
+			log.Println(err)
+			atomic.AddInt64(&w.failedWrites, 1)
+			return
+		}
+		// another url of a saved page, e.g. with tracking parameters
+		isUpdate = w.documents.Stored(fileNumber)
+	}
+	isDuplicate := false
+	if isUpdate {
+		// a changed page keeps its id and its file is overwritten
+		atomic.AddInt64(&w.updatedPages, 1)
+		if w.duplicates != nil {
+			w.duplicates.Update(fileNumber, fingerprint)
+		}
+	} else {
+		fileNumber, isDuplicate = w.newDocumentId(fileNumber, fingerprint)
+	}
+	if isDuplicate {
+		w.reject(page, duplicateStage, fmt.Sprintf("near-duplicate of %d", fileNumber))
+		if w.duplicatePolicy == AliasDuplicates {
+			toIndexFile <- indexMeta{
+				fileId:      fileNumber,
+				url:         decodedUrl,
+				lang:        page.Lang,
+				fingerprint: fingerprint,
 			}
 		}
+		return
 	}
-}
 
-func isNotRussianHtml(e *colly.HTMLElement) bool {
-	return !e.DOM.Is("html[lang='ru']")
+	document := newDocument(e)
+	document.Id = fileNumber
+	document.Text = page.Text
+	document.Lang = page.Lang
+
+	if !isUpdate {
+		w.documents.Add(decodedUrl, fileNumber)
+	}
+
+	w.fanOut(&pendingDocument{
+		document: document,
+		url:      decodedUrl,
+		isUpdate: isUpdate,
+		meta: indexMeta{
+			fileId:      fileNumber,
+			url:         decodedUrl,
+			lang:        page.Lang,
+			fingerprint: fingerprint,
+		},
+	}, toIndexFile)
 }
 
-func (w *htmlTextToFileWriter) parseOne(sb *strings.Builder, dir *string, e *colly.HTMLElement, toIndexFile chan<- indexMeta) {
-	decodedUrl, err := url.QueryUnescape(e.Request.URL.String())
-	if err != nil {
+// reject counts the page rejected by the stage of the pipeline
+func (w *htmlTextToFileWriter) reject(page *Page, stage string, reason string) {
+	if err := w.rejections.Add(page.Url, stage, reason); err != nil {
 		log.Println(err)
-		return
 	}
+}
 
-	if isNotRussianHtml(e) {
+// fanOut passes pending to the queue of every sink. It waits while
+// a queue is full.
+func (w *htmlTextToFileWriter) fanOut(pending *pendingDocument, toIndexFile chan<- indexMeta) {
+	if len(w.sinkQueues) == 0 {
+		w.saved(pending, toIndexFile)
 		return
 	}
 
-	text := parseElement(sb, e)
-
-	if !hasEqualOrMoreThanNWords(&text, targetWordsCount) {
-		return
+	pending.remaining = int32(len(w.sinkQueues))
+	for _, queue := range w.sinkQueues {
+		queue <- pending
 	}
+}
 
-	fileNumber := atomic.AddInt64(&w.parsedPages, 1)
-	fullFilePath := fmt.Sprintf("%s\\%d.txt", *dir, fileNumber)
-	file, err := os.Create(fullFilePath)
+// fanOutDelete passes the deletion of the document with the id to the queue
+// of every sink, behind the versions of the document which are still queued
+func (w *htmlTextToFileWriter) fanOutDelete(id int64) {
+	defer func() {
+		if panic := recover(); panic != nil {
+			log.Printf("document %d is not deleted from the sinks, parser is disposed\n", id)
+		}
+	}()
 
+	pending := &pendingDocument{document: &Document{Id: id}, deleted: true}
+	for _, queue := range w.sinkQueues {
+		queue <- pending
+	}
+}
+
+// written is called by a sink which has written pending. Once all the sinks
+// have written it its index entry is sent. If one of them has failed the
+// document is dropped instead, the other sinks may keep it.
+func (w *htmlTextToFileWriter) written(pending *pendingDocument, err error, toIndexFile chan<- indexMeta) {
 	if err != nil {
-		atomic.AddInt64(&w.parsedPages, -1)
-		println(err)
+		log.Println(err)
+		atomic.StoreInt32(&pending.failed, 1)
+	}
+	if atomic.AddInt32(&pending.remaining, -1) > 0 {
 		return
 	}
-	defer file.Close()
 
-	_, err = file.WriteString(text)
-	if err != nil {
-		println(err)
+	if atomic.LoadInt32(&pending.failed) == 0 {
+		w.saved(pending, toIndexFile)
+		return
+	}
 
-		atomic.AddInt64(&w.parsedPages, -1)
-		err = os.Remove(fullFilePath)
-		if err != nil {
-			println(err)
+	atomic.AddInt64(&w.failedWrites, 1)
+	if !pending.isUpdate {
+		w.documents.Remove(pending.url)
+		w.discardDocumentId(pending.document.Id)
+	}
+}
+
+// saved sends the index entry of a document written by all the sinks.
+// A page which was gone and has come back is no longer deleted.
+func (w *htmlTextToFileWriter) saved(pending *pendingDocument, toIndexFile chan<- indexMeta) {
+	if err := w.restore(pending.document.Id); err != nil {
+		log.Println(err)
+	}
+	toIndexFile <- pending.meta
+}
+
+func (w *htmlTextToFileWriter) closeSinks() {
+	for _, sink := range w.sinks {
+		if err := sink.Close(); err != nil {
+			log.Println(err)
 		}
 	}
+}
 
-	toIndexFile <- indexMeta{
-		fileId: fileNumber,
-		url:    decodedUrl,
+// newDocumentId counts a new document with the id and returns the id.
+// If a near-duplicate document has already been saved its id is returned instead.
+func (w *htmlTextToFileWriter) newDocumentId(id int64, fingerprint uint64) (int64, bool) {
+	newId := func() int64 {
+		atomic.AddInt64(&w.parsedPages, 1)
+		return id
+	}
+
+	if w.duplicates == nil {
+		return newId(), false
+	}
+
+	return w.duplicates.Register(fingerprint, newId)
+}
+
+func (w *htmlTextToFileWriter) discardDocumentId(fileId int64) {
+	atomic.AddInt64(&w.parsedPages, -1)
+
+	if w.duplicates != nil {
+		w.duplicates.Forget(fileId)
 	}
 }
 
@@ -205,38 +483,32 @@ func hasEqualOrMoreThanNWords(text *string, n int) bool {
 	return count >= n
 }
 
-func parseElement(sb *strings.Builder, e *colly.HTMLElement) string {
-	sb.Reset()
-
-	for _, node := range e.DOM.Nodes {
-		visit(sb, node)
-	}
-
-	return sb.String()
-}
-
-func visit(sb *strings.Builder, n *html.Node) {
-	if n == nil {
-		return
-	}
+// parseElement returns the main content of the page found by extractor.
+// The full text is returned when extractor is nil or finds no main content.
+func parseElement(text *textWriter, extractor *contentExtractor, e *colly.HTMLElement) string {
+	text.Reset()
 
-	switch n.Type {
-	case html.ElementNode:
-		skipElement := n.Data == "script" || n.Data == "style" || n.Data == "noscript" || n.Data == "meta" || n.Data == "link"
-		if skipElement {
-			return
+	if extractor != nil {
+		for _, node := range e.DOM.Nodes {
+			for _, content := range extractor.Extract(node) {
+				extractor.visitContent(text, content)
+			}
 		}
 
-		for child := n.FirstChild; child != nil; child = child.NextSibling {
-			visit(sb, child)
+		if text.Len() > 0 {
+			return text.String()
 		}
+	}
 
-	case html.TextNode:
-		for _, word := range strings.Fields(n.Data) {
-			sb.WriteString(word)
-			sb.WriteRune(' ')
-		}
+	for _, node := range e.DOM.Nodes {
+		text.visit(node, nil)
 	}
+
+	return text.String()
+}
+
+func isSkippedElement(n *html.Node) bool {
+	return n.Data == "script" || n.Data == "style" || n.Data == "noscript" || n.Data == "meta" || n.Data == "link"
 }
 
 func (w *htmlTextToFileWriter) Complete() (err error) {
@@ -250,6 +522,29 @@ func (w *htmlTextToFileWriter) Complete() (err error) {
 	return
 }
 
+// Accepted returns the number of documents in the output dir,
+// including the ones written by previous runs.
+func (w *htmlTextToFileWriter) Accepted() int64 {
+	return atomic.LoadInt64(&w.parsedPages)
+}
+
+// Stats returns the number of accepted documents, including the ones saved
+// by previous runs, and the number of pages rejected by every check of this run.
+func (w *htmlTextToFileWriter) Stats() map[string]int64 {
+	stats := map[string]int64{
+		"accepted":     atomic.LoadInt64(&w.parsedPages),
+		"write_failed": atomic.LoadInt64(&w.failedWrites),
+		"updated":      atomic.LoadInt64(&w.updatedPages),
+		"unchanged":    atomic.LoadInt64(&w.unchangedPages),
+		"deleted":      atomic.LoadInt64(&w.deletedPages),
+	}
+	for stage, count := range w.rejections.Counts() {
+		stats["rejected_"+stage] = count
+	}
+
+	return stats
+}
+
 func (w *htmlTextToFileWriter) Wait() {
 	w.wg.Wait()
 }
@@ -0,0 +1,267 @@
+package parser
+
+import (
+	"bufio"
+	"colly"
+	"fmt"
+	"mime"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// duplicateStage is the name of the near-duplicate check, the last stage
+// of the pipeline. It follows the filters as it remembers the pages it passes.
+const duplicateStage = "duplicate"
+
+// Page is a parsed page on its way through the pipeline.
+// The filters may fill in or fix its fields.
+type Page struct {
+	// Url is the decoded url of the page
+	Url     string
+	Element *colly.HTMLElement
+	// Text is the main content of the page, or its whole text with FullText
+	Text string
+	// Lang and LangConfidence are set by the language filter. The parser
+	// detects the language of the pages which have passed without one.
+	Lang           string
+	LangConfidence float64
+}
+
+// Filter is a stage of the pipeline the pages pass before they are saved.
+// Check returns the reason the page is rejected for, an empty reason passes
+// the page to the next stage. Check is called by several workers at once.
+type Filter interface {
+	// Name labels the rejections of the filter in the stats and the rejection log
+	Name() string
+	Check(page *Page) string
+}
+
+// Filters replaces the default filters, which reject the pages with garbled
+// text, with less than 1000 words or in a language which is not accepted,
+// with filters. The pages are checked in the order of filters, then the
+// near-duplicates set with NearDuplicates are rejected.
+func Filters(filters ...Filter) Option {
+	return func(w *htmlTextToFileWriter) {
+		w.filters = append([]Filter{}, filters...)
+	}
+}
+
+// MoreFilters appends filters to the default filters, or to the ones set
+// with Filters, e.g. a URLFilter which keeps the pages of a site section only.
+func MoreFilters(filters ...Filter) Option {
+	return func(w *htmlTextToFileWriter) {
+		w.moreFilters = append(w.moreFilters, filters...)
+	}
+}
+
+// RejectionLog makes the parser append every rejected page to the file
+// at path, one "url\tstage\treason" line per page.
+func RejectionLog(path string) Option {
+	return func(w *htmlTextToFileWriter) {
+		w.rejectionLogPath = path
+	}
+}
+
+type filterFunc struct {
+	name  string
+	check func(page *Page) string
+}
+
+func (f filterFunc) Name() string { return f.name }
+
+func (f filterFunc) Check(page *Page) string { return f.check(page) }
+
+// NewFilter returns a filter named name which rejects the pages check returns a reason for.
+func NewFilter(name string, check func(page *Page) string) Filter {
+	return filterFunc{name: name, check: check}
+}
+
+// EncodingFilter repairs the text of the pages which have been decoded with
+// a wrong charset and rejects the ones which can not be repaired.
+func EncodingFilter() Filter {
+	return NewFilter("encoding", func(page *Page) string {
+		if !colly.IsMojibake(page.Text) {
+			return ""
+		}
+
+		repaired, ok := colly.RepairMojibake(page.Text)
+		if !ok {
+			return "garbled text"
+		}
+		page.Text = repaired
+
+		return ""
+	})
+}
+
+// WordsFilter rejects the pages with less than n words.
+func WordsFilter(n int) Filter {
+	return NewFilter("words", func(page *Page) string {
+		if hasEqualOrMoreThanNWords(&page.Text, n) {
+			return ""
+		}
+
+		return fmt.Sprintf("less than %d words", n)
+	})
+}
+
+// LanguageFilter detects the language of the pages and rejects the ones
+// which are not in langs, given as ISO 639-1 codes, or are detected with
+// a confidence below minConfidence.
+func LanguageFilter(langs []string, minConfidence float64) Filter {
+	accepted := make(map[string]struct{}, len(langs))
+	for _, lang := range langs {
+		accepted[strings.ToLower(lang)] = struct{}{}
+	}
+
+	return NewFilter("language", func(page *Page) string {
+		page.Lang, page.LangConfidence = DetectLanguage(page.Text)
+		if _, ok := accepted[page.Lang]; !ok {
+			return fmt.Sprintf("language %s", page.Lang)
+		}
+		if page.LangConfidence < minConfidence {
+			return fmt.Sprintf("language %s with confidence %.2f", page.Lang, page.LangConfidence)
+		}
+
+		return ""
+	})
+}
+
+// URLFilter rejects the pages whose urls do not match one of include,
+// unless it is empty, or match one of exclude.
+func URLFilter(include []*regexp.Regexp, exclude []*regexp.Regexp) Filter {
+	return NewFilter("url", func(page *Page) string {
+		for _, re := range exclude {
+			if re.MatchString(page.Url) {
+				return "excluded by " + re.String()
+			}
+		}
+		if len(include) == 0 {
+			return ""
+		}
+		for _, re := range include {
+			if re.MatchString(page.Url) {
+				return ""
+			}
+		}
+
+		return "not included"
+	})
+}
+
+// ContentTypeFilter rejects the pages whose Content-Type is not one of
+// types, e.g. "text/html". A page without a Content-Type passes.
+func ContentTypeFilter(types ...string) Filter {
+	return NewFilter("content_type", func(page *Page) string {
+		response := page.Element.Response
+		if response == nil || response.Headers == nil || response.Headers.Get("Content-Type") == "" {
+			return ""
+		}
+
+		contentType := response.Headers.Get("Content-Type")
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return "content type " + contentType
+		}
+		for _, t := range types {
+			if strings.EqualFold(mediaType, t) {
+				return ""
+			}
+		}
+
+		return "content type " + mediaType
+	})
+}
+
+// defaultFilters returns the filters of the parser without the Filters option
+func (w *htmlTextToFileWriter) defaultFilters() []Filter {
+	langs := make([]string, 0, len(w.acceptedLanguages))
+	for lang := range w.acceptedLanguages {
+		langs = append(langs, lang)
+	}
+
+	return []Filter{
+		EncodingFilter(),
+		WordsFilter(targetWordsCount),
+		LanguageFilter(langs, w.minLanguageConfidence),
+	}
+}
+
+// rejections counts the rejected pages by stage
+// and appends them to the rejection log
+type rejections struct {
+	lock   sync.Mutex
+	counts map[string]int64
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// newRejections returns the counts of stages, all of them 0. The rejection
+// log at path is opened unless path is empty.
+func newRejections(stages []string, path string) (*rejections, error) {
+	r := &rejections{counts: make(map[string]int64, len(stages))}
+	for _, stage := range stages {
+		r.counts[stage] = 0
+	}
+	if path == "" {
+		return r, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r.file = file
+	r.writer = bufio.NewWriter(file)
+	if stat, err := file.Stat(); err == nil && stat.Size() == 0 {
+		r.writer.WriteString("url\tstage\treason\n")
+	}
+
+	return r, nil
+}
+
+// Add counts the page u rejected by stage
+func (r *rejections) Add(u string, stage string, reason string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.counts[stage]++
+	if r.writer == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(r.writer, "%s\t%s\t%s\n", u, stage, reason)
+
+	return err
+}
+
+// Counts returns the number of rejected pages by stage
+func (r *rejections) Counts() map[string]int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	counts := make(map[string]int64, len(r.counts))
+	for stage, count := range r.counts {
+		counts[stage] = count
+	}
+
+	return counts
+}
+
+func (r *rejections) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.writer.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.file, r.writer = nil, nil
+
+	return err
+}
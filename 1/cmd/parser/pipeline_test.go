@@ -0,0 +1,161 @@
+package parser
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"colly"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// newTestElement returns the html element of the page at pageUrl,
+// as the crawler passes it to the parser
+func newTestElement(t *testing.T, pageUrl string, contentType string, body string) *colly.HTMLElement {
+	u, err := url.Parse(pageUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := colly.NewContext()
+	headers := http.Header{}
+	if contentType != "" {
+		headers.Set("Content-Type", contentType)
+	}
+	response := &colly.Response{
+		StatusCode: http.StatusOK,
+		Body:       []byte(body),
+		Headers:    &headers,
+		Ctx:        ctx,
+		Request:    &colly.Request{URL: u, Ctx: ctx, Method: "GET"},
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	selection := doc.Find("html")
+
+	return colly.NewHTMLElementFromSelectionNode(response, selection, selection.Nodes[0], 0)
+}
+
+// sinkOp is a write or a delete made by recordingSink
+type sinkOp struct {
+	op string
+	id int64
+}
+
+// recordingSink records the writes and the deletes in the order they are made.
+// If written is set, Write sends the document to it and waits for release.
+type recordingSink struct {
+	lock    sync.Mutex
+	ops     []sinkOp
+	written chan *Document
+	release chan struct{}
+}
+
+func (s *recordingSink) Write(document *Document) error {
+	if s.written != nil {
+		s.written <- document
+		<-s.release
+	}
+	s.add("write", document.Id)
+	return nil
+}
+
+func (s *recordingSink) Delete(id int64) error {
+	s.add("delete", id)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func (s *recordingSink) add(op string, id int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ops = append(s.ops, sinkOp{op, id})
+}
+
+func TestFilters(t *testing.T) {
+	tests := []struct {
+		name        string
+		filter      Filter
+		url         string
+		contentType string
+		text        string
+		passed      bool
+		wantText    string
+	}{
+		{"url included", URLFilter([]*regexp.Regexp{regexp.MustCompile("/wiki/")}, nil), "http://example.com/wiki/a", "", "", true, ""},
+		{"url not included", URLFilter([]*regexp.Regexp{regexp.MustCompile("/wiki/")}, nil), "http://example.com/tag/a", "", "", false, ""},
+		{"url excluded", URLFilter(nil, []*regexp.Regexp{regexp.MustCompile("/tag/")}), "http://example.com/tag/a", "", "", false, ""},
+		{"url exclude wins", URLFilter([]*regexp.Regexp{regexp.MustCompile("/wiki/")}, []*regexp.Regexp{regexp.MustCompile("Special:")}), "http://example.com/wiki/Special:Random", "", "", false, ""},
+		{"html", ContentTypeFilter("text/html"), "http://example.com/", "text/html; charset=utf-8", "", true, ""},
+		{"content type case", ContentTypeFilter("text/html"), "http://example.com/", "Text/HTML", "", true, ""},
+		{"no content type", ContentTypeFilter("text/html"), "http://example.com/", "", "", true, ""},
+		{"xml", ContentTypeFilter("text/html"), "http://example.com/", "application/xml", "", false, ""},
+		{"enough words", WordsFilter(3), "http://example.com/", "", "раз, два три", true, "раз, два три"},
+		{"few words", WordsFilter(3), "http://example.com/", "", "раз — два", false, "раз — два"},
+		{"utf-8 text", EncodingFilter(), "http://example.com/", "", "Привет, мир", true, "Привет, мир"},
+		{"mojibake", EncodingFilter(), "http://example.com/", "", "РџСЂРёРІРµС‚, РјРёСЂ", true, "Привет, мир"},
+	}
+
+	for _, test := range tests {
+		page := &Page{Url: test.url, Element: newTestElement(t, test.url, test.contentType, "<html></html>"), Text: test.text}
+		reason := test.filter.Check(page)
+		if passed := reason == ""; passed != test.passed {
+			t.Errorf("%s: passed %v, reason %q", test.name, passed, reason)
+		}
+		if page.Text != test.wantText {
+			t.Errorf("%s: text %q, want %q", test.name, page.Text, test.wantText)
+		}
+	}
+}
+
+func TestMoreFilters(t *testing.T) {
+	sink := &recordingSink{}
+	exclude := URLFilter(nil, []*regexp.Regexp{regexp.MustCompile("/tag/")})
+	w, err := New(context.Background(), t.TempDir(), 1, Filters(), MoreFilters(exclude), Sinks(sink), NearDuplicates(NoDuplicateDetection, SkipDuplicates))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []string{"http://example.com/wiki/a", "http://example.com/tag/a"} {
+		w.Process(*newTestElement(t, u, "text/html", "<html><body><p>"+u+"</p></body></html>"))
+	}
+	w.Complete()
+	w.Wait()
+
+	stats := w.Stats()
+	if stats["accepted"] != 1 || stats["rejected_url"] != 1 || len(sink.ops) != 1 {
+		t.Errorf("unexpected stats %v and writes %v", stats, sink.ops)
+	}
+}
+
+func TestGoneFollowsQueuedWrite(t *testing.T) {
+	sink := &recordingSink{written: make(chan *Document), release: make(chan struct{})}
+	w, err := New(context.Background(), t.TempDir(), 1, Filters(), Sinks(sink))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := "http://example.com/a"
+	w.Process(*newTestElement(t, u, "text/html", "<html><body><p>a page</p></body></html>"))
+	document := <-sink.written
+	// the page is gone while its document is still on its way to the sink
+	w.Gone(u)
+	close(sink.release)
+	w.Complete()
+	w.Wait()
+
+	want := []sinkOp{{"write", document.Id}, {"delete", document.Id}}
+	if len(sink.ops) != len(want) || sink.ops[0] != want[0] || sink.ops[1] != want[1] {
+		t.Errorf("sink got %v, want %v", sink.ops, want)
+	}
+}
@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"colly"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Document is the structured form of a saved document, which the parser
+// passes to its sinks. The JSONL sink writes it as a line of documents.jsonl.
+type Document struct {
+	Id           int64     `json:"id"`
+	Url          string    `json:"url"`
+	FinalUrl     string    `json:"final_url"`
+	CanonicalUrl string    `json:"canonical_url"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	Headings     []Heading `json:"headings"`
+	Text         string    `json:"text"`
+	Outlinks     []string  `json:"outlinks"`
+	FetchedAt    time.Time `json:"fetched_at"`
+	Status       int       `json:"status"`
+	ContentHash  string    `json:"content_hash"`
+	Lang         string    `json:"lang"`
+}
+
+type Heading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+}
+
+// NoTextFiles leaves the directory sink out of the default sinks, so the text
+// of every document is not written to its own N.txt file. The text is still
+// available in documents.jsonl.
+func NoTextFiles() Option {
+	return func(w *htmlTextToFileWriter) {
+		w.noTextFiles = true
+	}
+}
+
+// newDocument collects the metadata of the page e. The id and the
+// text are filled in by the caller.
+func newDocument(e *colly.HTMLElement) *Document {
+	document := &Document{
+		FinalUrl:    e.Request.URL.String(),
+		Title:       normalizeSpaces(e.DOM.Find("title").First().Text()),
+		Description: metaDescription(e.DOM),
+		Headings:    headings(e.DOM),
+		Outlinks:    outlinks(e),
+		FetchedAt:   time.Now(),
+	}
+
+	document.Url = document.FinalUrl
+	if original := e.Request.Ctx.Get(colly.OriginalURLCtxKey); original != "" {
+		document.Url = original
+	}
+	document.CanonicalUrl = e.Request.Ctx.Get(colly.CanonicalURLCtxKey)
+	if fetchedAt, ok := e.Request.Ctx.GetAny(colly.FetchedAtCtxKey).(time.Time); ok {
+		document.FetchedAt = fetchedAt
+	}
+
+	if e.Response != nil {
+		document.Status = e.Response.StatusCode
+		hash := sha256.Sum256(e.Response.Body)
+		document.ContentHash = hex.EncodeToString(hash[:])
+	}
+
+	return document
+}
+
+func metaDescription(doc *goquery.Selection) string {
+	for _, selector := range []string{`meta[name="description"]`, `meta[property="og:description"]`} {
+		if content, exists := doc.Find(selector).First().Attr("content"); exists && strings.TrimSpace(content) != "" {
+			return normalizeSpaces(content)
+		}
+	}
+
+	return ""
+}
+
+func headings(doc *goquery.Selection) []Heading {
+	result := []Heading{}
+
+	doc.Find("h1, h2, h3").Each(func(_ int, s *goquery.Selection) {
+		text := normalizeSpaces(s.Text())
+		if text == "" {
+			return
+		}
+
+		result = append(result, Heading{
+			Level: int(goquery.NodeName(s)[1] - '0'),
+			Text:  text,
+		})
+	})
+
+	return result
+}
+
+// outlinks returns the distinct absolute http(s) urls the page links to,
+// without fragments
+func outlinks(e *colly.HTMLElement) []string {
+	result := []string{}
+	seen := make(map[string]struct{})
+
+	e.DOM.Find("a[href]").Each(func(_ int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		u, err := url.Parse(e.Request.AbsoluteURL(href))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		u.Fragment = ""
+		link := u.String()
+
+		if _, exists := seen[link]; !exists {
+			seen[link] = struct{}{}
+			result = append(result, link)
+		}
+	})
+
+	return result
+}
+
+func normalizeSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"colly"
+)
+
+func TestNewDocument(t *testing.T) {
+	body := `<html><head><title> Заголовок
+страницы </title>
+<meta property="og:description" content="og">
+<meta name="description" content=" Описание  страницы "></head>
+<body><h1>Первый</h1><h2> </h2><h3>Третий</h3><h4>Четвёртый</h4>
+<a href="/a#top">a</a><a href="http://example.org/b">b</a><a href="/a">a again</a>
+<a href="mailto:a@example.com">mail</a><a href="javascript:void(0)">js</a></body></html>`
+	e := newTestElement(t, "http://example.com/final", "text/html", body)
+	fetchedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.Request.Ctx.Put(colly.OriginalURLCtxKey, "http://example.com/original")
+	e.Request.Ctx.Put(colly.CanonicalURLCtxKey, "http://example.com/canonical")
+	e.Request.Ctx.Put(colly.FetchedAtCtxKey, fetchedAt)
+
+	document := newDocument(e)
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"url", document.Url, "http://example.com/original"},
+		{"final url", document.FinalUrl, "http://example.com/final"},
+		{"canonical url", document.CanonicalUrl, "http://example.com/canonical"},
+		{"title", document.Title, "Заголовок страницы"},
+		{"description", document.Description, "Описание страницы"},
+		{"fetched at", document.FetchedAt, fetchedAt},
+		{"status", document.Status, 200},
+		{"content hash length", len(document.ContentHash), 64},
+	}
+
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+		}
+	}
+
+	if want := []Heading{{1, "Первый"}, {3, "Третий"}}; !slices.Equal(document.Headings, want) {
+		t.Errorf("headings %v, want %v", document.Headings, want)
+	}
+	if want := []string{"http://example.com/a", "http://example.org/b"}; !slices.Equal(document.Outlinks, want) {
+		t.Errorf("outlinks %v, want %v", document.Outlinks, want)
+	}
+}
+
+func TestNewDocumentWithoutContext(t *testing.T) {
+	e := newTestElement(t, "http://example.com/page", "text/html", `<html><head><meta property="og:description" content="og"></head><body></body></html>`)
+
+	document := newDocument(e)
+
+	if document.Url != "http://example.com/page" || document.CanonicalUrl != "" {
+		t.Errorf("url %q, canonical url %q", document.Url, document.CanonicalUrl)
+	}
+	if document.Description != "og" {
+		t.Errorf("description %q", document.Description)
+	}
+	if document.Headings == nil || document.Outlinks == nil {
+		t.Error("empty lists are written as null")
+	}
+	if document.FetchedAt.IsZero() {
+		t.Error("the fetch time is not set")
+	}
+}
@@ -0,0 +1,174 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// documentUrls maps the url of every saved document to its id,
+// so a page fetched again by a recrawl keeps its id
+type documentUrls struct {
+	lock sync.Mutex
+	ids  map[string]int64
+	// stored counts the urls of every id
+	stored map[int64]int
+}
+
+func newDocumentUrls(ids map[string]int64) *documentUrls {
+	d := &documentUrls{ids: ids, stored: make(map[int64]int)}
+	for _, id := range ids {
+		d.stored[id]++
+	}
+
+	return d
+}
+
+func (d *documentUrls) Id(u string) (int64, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	id, found := d.ids[u]
+
+	return id, found
+}
+
+func (d *documentUrls) Add(u string, id int64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, exists := d.ids[u]; !exists {
+		d.stored[id]++
+	}
+	d.ids[u] = id
+}
+
+// Stored reports whether a document with the id has been saved
+func (d *documentUrls) Stored(id int64) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.stored[id] > 0
+}
+
+func (d *documentUrls) Remove(u string) (int64, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	id, found := d.ids[u]
+	if found {
+		delete(d.ids, u)
+		if d.stored[id]--; d.stored[id] <= 0 {
+			delete(d.stored, id)
+		}
+	}
+
+	return id, found
+}
+
+// Unchanged counts a page which has not changed since the previous crawl,
+// its document is kept as it is.
+func (w *htmlTextToFileWriter) Unchanged(pageUrl string) {
+	atomic.AddInt64(&w.unchangedPages, 1)
+}
+
+// Gone deletes the document of a page which no longer exists. It is deleted
+// from the sinks and its id is appended to deleted.txt, so the following stages
+// skip the rows of the id in the index until the page is saved again.
+func (w *htmlTextToFileWriter) Gone(pageUrl string) {
+	decodedUrl, err := url.QueryUnescape(pageUrl)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	id, found := w.documents.Remove(decodedUrl)
+	if !found {
+		return
+	}
+	atomic.AddInt64(&w.deletedPages, 1)
+	atomic.AddInt64(&w.parsedPages, -1)
+
+	if w.duplicates != nil {
+		w.duplicates.Forget(id)
+	}
+
+	w.fanOutDelete(id)
+
+	w.deletedLock.Lock()
+	defer w.deletedLock.Unlock()
+
+	w.deleted[id] = struct{}{}
+	file, err := os.OpenFile(deletedFilePath(w.dir), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintf(file, "%d\n", id); err != nil {
+		log.Println(err)
+	}
+}
+
+// restore removes the id of a saved document from deleted.txt. The file is
+// rewritten without it, so the following stages need not know about restores.
+func (w *htmlTextToFileWriter) restore(id int64) error {
+	w.deletedLock.Lock()
+	defer w.deletedLock.Unlock()
+
+	if _, isDeleted := w.deleted[id]; !isDeleted {
+		return nil
+	}
+	delete(w.deleted, id)
+
+	ids := make([]int64, 0, len(w.deleted))
+	for deletedId := range w.deleted {
+		ids = append(ids, deletedId)
+	}
+	slices.Sort(ids)
+
+	var b strings.Builder
+	for _, deletedId := range ids {
+		fmt.Fprintf(&b, "%d\n", deletedId)
+	}
+
+	// the file is replaced at once, a crash leaves the old one
+	path := deletedFilePath(w.dir)
+	if err := os.WriteFile(path+".tmp", []byte(b.String()), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// readDeletedIds reads the ids of the deleted documents, one per line
+func readDeletedIds(path string) (map[int64]struct{}, error) {
+	deleted := make(map[int64]struct{})
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return deleted, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if id, err := strconv.ParseInt(strings.TrimSpace(scanner.Text()), 10, 64); err == nil {
+			deleted[id] = struct{}{}
+		}
+	}
+
+	return deleted, scanner.Err()
+}
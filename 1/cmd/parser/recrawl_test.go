@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// runParser parses the pages into dir and calls gone with the parser
+// before it completes
+func runParser(t *testing.T, dir string, pages []string, gone func(w *htmlTextToFileWriter)) *htmlTextToFileWriter {
+	w, err := New(context.Background(), dir, 1, Filters(), NearDuplicates(NoDuplicateDetection, SkipDuplicates))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range pages {
+		w.Process(*newTestElement(t, u, "text/html", "<html><body><p>page "+u+"</p></body></html>"))
+	}
+	if gone != nil {
+		gone(w)
+	}
+	w.Complete()
+	w.Wait()
+
+	return w
+}
+
+func readDeletedFile(t *testing.T, dir string) string {
+	data, err := os.ReadFile(deletedFilePath(dir))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestGonePageComesBack(t *testing.T) {
+	dir := t.TempDir()
+	a, b := "http://example.com/a", "http://example.com/b"
+
+	w := runParser(t, dir, []string{a, b}, nil)
+	idA, _ := w.documents.Id(a)
+	idB, _ := w.documents.Id(b)
+
+	// a recrawl finds a gone, b is already deleted by an earlier one
+	runParser(t, dir, nil, func(w *htmlTextToFileWriter) {
+		w.Gone(a)
+		w.Gone(b)
+	})
+	wantDeleted := []string{strconv.FormatInt(idA, 10), strconv.FormatInt(idB, 10)}
+	if got := readDeletedFile(t, dir); got != strings.Join(wantDeleted, "\n") {
+		t.Fatalf("deleted.txt is %q, want %q", got, wantDeleted)
+	}
+
+	// a is found again
+	w = runParser(t, dir, []string{a}, nil)
+	if id, _ := w.documents.Id(a); id != idA {
+		t.Errorf("the page got the id %d instead of %d", id, idA)
+	}
+	if got := readDeletedFile(t, dir); got != strconv.FormatInt(idB, 10) {
+		t.Errorf("deleted.txt is %q, want only %d", got, idB)
+	}
+
+	tests := []struct {
+		url     string
+		visible bool
+	}{
+		{a, true},
+		{b, false},
+	}
+
+	w = runParser(t, dir, nil, nil)
+	for _, test := range tests {
+		id, stored := w.documents.Id(test.url)
+		if stored != test.visible {
+			t.Errorf("%s: stored %v, want %v", test.url, stored, test.visible)
+		}
+		_, err := os.Stat(filepath.Join(dir, documentFileName(id)))
+		if stored && err != nil {
+			t.Errorf("%s: %v", test.url, err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package parser
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DocumentSink stores the documents accepted by the parser. Write is called
+// by several workers at once. A document written with the id of a stored one
+// is its new version, e.g. a page changed since the previous crawl.
+type DocumentSink interface {
+	Write(document *Document) error
+	// Delete removes the document with the id. The sinks which can only
+	// append keep it, deleted.txt lists the ids of the deleted documents.
+	Delete(id int64) error
+	// Close flushes the written documents. The parser closes its sinks
+	// once the last document has been written.
+	Close() error
+}
+
+// Sinks replaces the default sinks of the parser, the text files of the
+// output dir and documents.jsonl, with sinks. The parser closes them.
+func Sinks(sinks ...DocumentSink) Option {
+	return func(w *htmlTextToFileWriter) {
+		// no sinks is not the defaults, only the index is written
+		w.sinks = append([]DocumentSink{}, sinks...)
+	}
+}
+
+// sinkQueueSize is the number of documents waiting for a sink
+// before the workers wait for it
+const sinkQueueSize = 64
+
+// pendingDocument is a saved document on its way to the sinks
+type pendingDocument struct {
+	document *Document
+	url      string
+	isUpdate bool
+	meta     indexMeta
+	// remaining is the number of sinks which have not written the document yet
+	remaining int32
+	// failed is 1 if one of the sinks could not write the document
+	failed int32
+	// deleted asks the sinks to delete the document instead of writing it.
+	// It goes through the same queues, so it follows the earlier writes.
+	deleted bool
+}
+
+// documentFileName is the name of the text file of the document with the
+// id, the following stages look the documents up by it
+func documentFileName(id int64) string {
+	return strconv.FormatInt(id, 10) + ".txt"
+}
+
+// directorySink writes the text of every document to its own N.txt file
+type directorySink struct {
+	dir string
+}
+
+// NewDirectorySink returns a sink which writes the text of every document
+// to the N.txt file of dir, N being the id of the document.
+func NewDirectorySink(dir string) (DocumentSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &directorySink{dir: dir}, nil
+}
+
+func (s *directorySink) Write(document *Document) error {
+	return writeTextFile(filepath.Join(s.dir, documentFileName(document.Id)), document.Text)
+}
+
+func (s *directorySink) Delete(id int64) error {
+	err := os.Remove(filepath.Join(s.dir, documentFileName(id)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *directorySink) Close() error {
+	return nil
+}
+
+// writeTextFile writes text to path. A partially written file is removed.
+func writeTextFile(path string, text string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.WriteString(text)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if rmErr := os.Remove(path); rmErr != nil {
+			println(rmErr)
+		}
+	}
+
+	return err
+}
+
+// jsonlSink appends the documents to a JSON Lines file
+type jsonlSink struct {
+	lock   sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// NewJSONLSink returns a sink which appends every document with its
+// metadata to the file at path as a line of JSON. The new version of
+// a document is appended as well, the last line of an id is the current one.
+func NewJSONLSink(path string) (DocumentSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonlSink{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (s *jsonlSink) Write(document *Document) error {
+	line, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err = s.writer.Write(append(line, '\n'))
+
+	return err
+}
+
+func (s *jsonlSink) Delete(id int64) error {
+	return nil
+}
+
+func (s *jsonlSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.writer.Flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// tarSink writes the texts of the documents to a gzip compressed tar archive
+type tarSink struct {
+	lock   sync.Mutex
+	file   *os.File
+	gzip   *gzip.Writer
+	writer *tar.Writer
+}
+
+// NewTarSink returns a sink which writes the text of every document as the
+// N.txt entry of a new documents-<time>.tar.gz archive in dir. An archive
+// can not be appended to, so every run writes its own one. A new version of
+// a document is a later entry with the same name, which replaces the earlier
+// one when the archive is extracted.
+func NewTarSink(dir string) (DocumentSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("documents-%s.tar.gz", time.Now().UTC().Format("20060102150405"))
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	compressed := gzip.NewWriter(file)
+
+	return &tarSink{file: file, gzip: compressed, writer: tar.NewWriter(compressed)}, nil
+}
+
+func (s *tarSink) Write(document *Document) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     documentFileName(document.Id),
+		Size:     int64(len(document.Text)),
+		Mode:     0644,
+		ModTime:  document.FetchedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.writer.Write([]byte(document.Text))
+
+	return err
+}
+
+func (s *tarSink) Delete(id int64) error {
+	return nil
+}
+
+func (s *tarSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.writer.Close()
+	if gerr := s.gzip.Close(); err == nil {
+		err = gerr
+	}
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readDirectory returns the texts of the N.txt files of dir by their names
+func readDirectory(t *testing.T, dir string) map[string]string {
+	texts := map[string]string{}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		texts[filepath.Base(path)] = string(text)
+	}
+	return texts
+}
+
+// readJSONL returns the texts of the last lines of the documents in dir
+func readJSONL(t *testing.T, dir string) map[string]string {
+	file, err := os.Open(filepath.Join(dir, "documents.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	texts := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var document Document
+		if err := json.Unmarshal(scanner.Bytes(), &document); err != nil {
+			t.Fatal(err)
+		}
+		texts[documentFileName(document.Id)] = document.Text
+	}
+	return texts
+}
+
+// readTar returns the texts of the entries of the archive in dir,
+// a later entry replacing an earlier one
+func readTar(t *testing.T, dir string) map[string]string {
+	paths, err := filepath.Glob(filepath.Join(dir, "documents-*.tar.gz"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("archives %v, %v", paths, err)
+	}
+	file, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	compressed, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	texts := map[string]string{}
+	archive := tar.NewReader(compressed)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		text, err := io.ReadAll(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		texts[header.Name] = string(text)
+	}
+	return texts
+}
+
+func TestSinks(t *testing.T) {
+	tests := []struct {
+		name string
+		open func(dir string) (DocumentSink, error)
+		read func(t *testing.T, dir string) map[string]string
+		want map[string]string
+	}{
+		{"directory", NewDirectorySink, readDirectory, map[string]string{"1.txt": "first, changed"}},
+		{"jsonl", func(dir string) (DocumentSink, error) {
+			return NewJSONLSink(filepath.Join(dir, "documents.jsonl"))
+		}, readJSONL, map[string]string{"1.txt": "first, changed", "2.txt": "second"}},
+		{"tar", NewTarSink, readTar, map[string]string{"1.txt": "first, changed", "2.txt": "second"}},
+	}
+
+	for _, test := range tests {
+		dir := filepath.Join(t.TempDir(), "out")
+		sink, err := test.open(dir)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		for _, document := range []*Document{
+			{Id: 1, Text: "first", FetchedAt: time.Now()},
+			{Id: 2, Text: "second", FetchedAt: time.Now()},
+			{Id: 1, Text: "first, changed", FetchedAt: time.Now()},
+		} {
+			if err := sink.Write(document); err != nil {
+				t.Errorf("%s: Write(%d): %v", test.name, document.Id, err)
+			}
+		}
+		if err := sink.Delete(2); err != nil {
+			t.Errorf("%s: Delete: %v", test.name, err)
+		}
+		if err := sink.Delete(3); err != nil {
+			t.Errorf("%s: Delete of a missing document: %v", test.name, err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Errorf("%s: Close: %v", test.name, err)
+		}
+
+		if got := test.read(t, dir); !maps.Equal(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// textBreak is the separator of two words of the text, the stronger
+// of the breaks between them wins
+type textBreak int
+
+const (
+	noBreak textBreak = iota
+	spaceBreak
+	cellBreak
+	// lineBreak ends a sentence, a list item or a table row
+	lineBreak
+	// paragraphBreak ends a paragraph, a heading or another block
+	paragraphBreak
+)
+
+var textBreakSeparators = [...]string{"", " ", "\t", "\n", "\n\n"}
+
+// elementBreaks are the breaks written before and after the text of the elements
+var elementBreaks = map[string]textBreak{
+	"address":    paragraphBreak,
+	"article":    paragraphBreak,
+	"aside":      paragraphBreak,
+	"blockquote": paragraphBreak,
+	"details":    paragraphBreak,
+	"div":        paragraphBreak,
+	"dl":         paragraphBreak,
+	"fieldset":   paragraphBreak,
+	"figcaption": paragraphBreak,
+	"figure":     paragraphBreak,
+	"footer":     paragraphBreak,
+	"form":       paragraphBreak,
+	"h1":         paragraphBreak,
+	"h2":         paragraphBreak,
+	"h3":         paragraphBreak,
+	"h4":         paragraphBreak,
+	"h5":         paragraphBreak,
+	"h6":         paragraphBreak,
+	"header":     paragraphBreak,
+	"hr":         paragraphBreak,
+	"main":       paragraphBreak,
+	"nav":        paragraphBreak,
+	"ol":         paragraphBreak,
+	"p":          paragraphBreak,
+	"pre":        paragraphBreak,
+	"section":    paragraphBreak,
+	"summary":    paragraphBreak,
+	"table":      paragraphBreak,
+	"title":      paragraphBreak,
+	"ul":         paragraphBreak,
+	"br":         lineBreak,
+	"caption":    lineBreak,
+	"dd":         lineBreak,
+	"dt":         lineBreak,
+	"li":         lineBreak,
+	"tr":         lineBreak,
+	"td":         cellBreak,
+	"th":         cellBreak,
+}
+
+const (
+	imageMarker = "[image:"
+	linkMarker  = "[link:"
+	markerEnd   = "]"
+)
+
+// ImageAltText makes the parser keep the alt text of the images
+// as "[image: alt text]".
+func ImageAltText() Option {
+	return func(w *htmlTextToFileWriter) {
+		w.imageAltText = true
+	}
+}
+
+// LinkMarkers makes the parser mark the text of the links
+// as "[link: link text]".
+func LinkMarkers() Option {
+	return func(w *htmlTextToFileWriter) {
+		w.linkMarkers = true
+	}
+}
+
+// textWriter converts html to text which keeps the structure of the page.
+// The paragraphs, headings and other blocks are separated by an empty line,
+// every sentence, list item and table row is on its own line and the cells
+// of a row are separated by tabs. The words of a line are separated by
+// single spaces, whatever whitespace separates them in the html.
+type textWriter struct {
+	buf []byte
+	// pending is the break written before the next word
+	pending textBreak
+	// sentenceEnd is set when the last word ends a sentence
+	sentenceEnd bool
+	// pre is the number of pre elements the text is in, their lines are kept
+	pre int
+
+	imageAltText bool
+	linkMarkers  bool
+}
+
+func newTextWriter(imageAltText bool, linkMarkers bool) *textWriter {
+	return &textWriter{imageAltText: imageAltText, linkMarkers: linkMarkers}
+}
+
+func (t *textWriter) Reset() {
+	t.buf = t.buf[:0]
+	t.pending = noBreak
+	t.sentenceEnd = false
+	t.pre = 0
+}
+
+func (t *textWriter) Len() int {
+	return len(t.buf)
+}
+
+func (t *textWriter) String() string {
+	return string(t.buf)
+}
+
+// visit writes the text of n. The elements skip reports are left out.
+func (t *textWriter) visit(n *html.Node, skip func(n *html.Node) bool) {
+	switch n.Type {
+	case html.TextNode:
+		t.text(n.Data)
+		return
+
+	case html.ElementNode:
+		if isSkippedElement(n) || skip != nil && skip(n) {
+			return
+		}
+
+	case html.DocumentNode:
+
+	default:
+		return
+	}
+
+	switch n.Data {
+	case "img":
+		if alt := attribute(n, "alt"); t.imageAltText && strings.TrimSpace(alt) != "" {
+			t.marked(imageMarker, func() { t.text(alt) })
+		}
+		return
+	case "pre":
+		t.pre++
+		defer func() { t.pre-- }()
+	}
+
+	elementBreak := elementBreaks[n.Data]
+	t.addBreak(elementBreak)
+
+	children := func() {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			t.visit(child, skip)
+		}
+	}
+	if n.Data == "a" && t.linkMarkers {
+		t.marked(linkMarker, children)
+	} else {
+		children()
+	}
+
+	t.addBreak(elementBreak)
+}
+
+// marked writes the text written by write between marker and markerEnd.
+// Nothing is written if write writes no words.
+func (t *textWriter) marked(marker string, write func()) {
+	start, pending, sentenceEnd := len(t.buf), t.pending, t.sentenceEnd
+
+	t.word(marker)
+	t.addBreak(spaceBreak)
+	end := len(t.buf)
+	write()
+
+	if len(t.buf) == end {
+		t.buf, t.pending, t.sentenceEnd = t.buf[:start], pending, sentenceEnd
+		return
+	}
+
+	// the break after the marked text is kept for the next word
+	pending, sentenceEnd = t.pending, t.sentenceEnd
+	t.pending = noBreak
+	t.word(markerEnd)
+	t.pending, t.sentenceEnd = pending, sentenceEnd
+}
+
+// text writes the words of a text node, its entities are decoded by the
+// html parser. Soft hyphens and zero width characters are dropped and the
+// other spaces, including non-breaking ones, separate words.
+func (t *textWriter) text(s string) {
+	s = strings.Map(dropInvisible, s)
+
+	if t.pre > 0 {
+		for i, line := range strings.Split(s, "\n") {
+			if i > 0 {
+				t.addBreak(lineBreak)
+			}
+			t.words(line)
+		}
+		return
+	}
+
+	t.words(s)
+}
+
+func (t *textWriter) words(s string) {
+	for i, word := range strings.Fields(s) {
+		if i > 0 || unicode.IsSpace(firstRune(s)) {
+			t.addBreak(spaceBreak)
+		}
+		t.word(word)
+	}
+	if lastRune, _ := utf8.DecodeLastRuneInString(s); unicode.IsSpace(lastRune) {
+		t.addBreak(spaceBreak)
+	}
+}
+
+func (t *textWriter) word(word string) {
+	if len(t.buf) > 0 {
+		separator := t.pending
+		if separator == spaceBreak && t.sentenceEnd && startsSentence(word) {
+			separator = lineBreak
+		}
+		t.buf = append(t.buf, textBreakSeparators[separator]...)
+	}
+	t.buf = append(t.buf, word...)
+
+	t.pending = noBreak
+	t.sentenceEnd = endsSentence(word)
+}
+
+func (t *textWriter) addBreak(b textBreak) {
+	t.pending = max(t.pending, b)
+}
+
+// dropInvisible drops the soft hyphens and the zero width characters
+func dropInvisible(r rune) rune {
+	switch r {
+	case '\u00ad', '\u200b', '\u200c', '\u200d', '\u2060', '\ufeff':
+		return -1
+	}
+
+	return r
+}
+
+// endsSentence reports whether word ends with a sentence terminator. A word
+// of a single letter followed by a dot is taken for an initial.
+func endsSentence(word string) bool {
+	word = strings.TrimRight(word, `"')]»”’`)
+	last, size := utf8.DecodeLastRuneInString(word)
+
+	switch last {
+	case '!', '?', '…':
+		return true
+	case '.':
+		core := strings.TrimRight(word[:len(word)-size], ".")
+		return utf8.RuneCountInString(core) > 1 && !strings.Contains(core, ".")
+	}
+
+	return false
+}
+
+// startsSentence reports whether word may start a sentence, that is starts
+// with a capital letter or a digit, possibly after opening quotes
+func startsSentence(word string) bool {
+	word = strings.TrimLeft(word, `"'([«„“‘`)
+	first := firstRune(word)
+
+	return unicode.IsUpper(first) || unicode.IsDigit(first)
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+
+	return r
+}
+
+func attribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func extractText(t *testing.T, page string, imageAltText bool, linkMarkers bool) string {
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newTextWriter(imageAltText, linkMarkers)
+	w.visit(doc, nil)
+	return w.String()
+}
+
+func TestTextWriter(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		imageAltText bool
+		linkMarkers  bool
+		want         string
+	}{
+		{"paragraphs", "<h1>Заголовок</h1><p>Первый абзац</p><p>Второй абзац</p>", false, false, "Заголовок\n\nПервый абзац\n\nВторой абзац"},
+		{"sentences", "<p>Первое предложение. Второе, с <b>жир</b>ным словом! Третье?</p>", false, false, "Первое предложение.\nВторое, с жирным словом!\nТретье?"},
+		{"initials", "<p>А. С. Пушкин писал т. е. так.</p>", false, false, "А. С. Пушкин писал т. е. так."},
+		{"list", "<ul><li>один</li><li>два</li></ul>", false, false, "один\nдва"},
+		{"table", "<table><tr><th>a</th><th>b</th></tr><tr><td>1</td><td>2</td></tr></table>", false, false, "a\tb\n1\t2"},
+		{"br", "<p>строка<br>перенос</p>", false, false, "строка\nперенос"},
+		{"pre", "<pre>line 1\n  line 2</pre>", false, false, "line 1\nline 2"},
+		{"whitespace", "<p>  много \n\t пробелов&nbsp;и&#160;nbsp </p>", false, false, "много пробелов и nbsp"},
+		{"invisible", "<p>ко&shy;пи&#8203;рование</p>", false, false, "копирование"},
+		{"entities", "<p>&lt;tag&gt; &amp;lt; &amp;amp;</p>", false, false, "<tag> &lt; &amp;"},
+		{"no markers", `<p>Кот <img src="a" alt="кот"> и <a href="/">ссылка</a></p>`, false, false, "Кот и ссылка"},
+		{"image alt", `<p>Кот <img src="a" alt="кот на крыше"><img src="b"> и</p>`, true, false, "Кот [image: кот на крыше] и"},
+		{"link markers", `<p>Это <a href="/">ссылка</a>, а <a href="/"><img src="c"></a> нет</p>`, false, true, "Это [link: ссылка], а нет"},
+		{"skipped", "<p>текст<script>var a = 1;</script><style>p {}</style></p>", false, false, "текст"},
+	}
+
+	for _, test := range tests {
+		got := extractText(t, "<html><body>"+test.body+"</body></html>", test.imageAltText, test.linkMarkers)
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSentenceBoundaries(t *testing.T) {
+	tests := []struct {
+		word  string
+		ends  bool
+		start bool
+	}{
+		{"конец.", true, false},
+		{"вопрос?", true, false},
+		{"«цитата.»", true, false},
+		{"А.", false, true},
+		{"т.е.", false, false},
+		{"...", false, false},
+		{"«Начало", false, true},
+		{"2024", false, true},
+		{"слово", false, false},
+	}
+
+	for _, test := range tests {
+		if got := endsSentence(test.word); got != test.ends {
+			t.Errorf("endsSentence(%q) = %v", test.word, got)
+		}
+		if got := startsSentence(test.word); got != test.start {
+			t.Errorf("startsSentence(%q) = %v", test.word, got)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"crawler"
+	"crawler/warc"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"parser"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// config holds every setting of a crawl. It is read from a JSON file and
+// the command line flags override the values of the file.
+type config struct {
+	Seeds      []string `json:"seeds"`
+	Out        string   `json:"out"`
+	WipeOutput bool     `json:"wipe_output"`
+	// Timeout limits the whole crawl, TargetDocs stops it after the number
+	// of accepted documents. At least one of them is required.
+	Timeout    duration `json:"timeout"`
+	TargetDocs int64    `json:"target_docs"`
+
+	Workers        int       `json:"workers"`
+	ParserWorkers  int       `json:"parser_workers"`
+	UserAgent      string    `json:"user_agent"`
+	RequestTimeout duration  `json:"request_timeout"`
+	RateLimit      rateLimit `json:"rate_limit"`
+
+	Scope         scopeRules  `json:"scope"`
+	Sitemaps      bool        `json:"sitemaps"`
+	SitemapsSince string      `json:"sitemaps_since"`
+	Robots        robotsRules `json:"robots"`
+	Traps         trapRules   `json:"traps"`
+	// Keywords switch the crawl to best-first: the links which are most
+	// similar to them are fetched first.
+	Keywords []string `json:"keywords"`
+
+	DupDistance       int       `json:"dup_distance"`
+	NoDedup           bool      `json:"no_dedup"`
+	DupAliases        bool      `json:"dup_aliases"`
+	Languages         []string  `json:"languages"`
+	MinLangConfidence float64   `json:"min_lang_confidence"`
+	FullText          bool      `json:"full_text"`
+	ImageAltText      bool      `json:"image_alt_text"`
+	LinkMarkers       bool      `json:"link_markers"`
+	PageFilters       pageRules `json:"page_filters"`
+	// Sinks are the names of the sinks the documents are written to.
+	// NoTextFiles leaves the text sink out.
+	Sinks       []string `json:"sinks"`
+	NoTextFiles bool     `json:"no_text_files"`
+
+	Attempts    int      `json:"attempts"`
+	RetryFailed bool     `json:"retry_failed"`
+	Recrawl     bool     `json:"recrawl"`
+	MetricsAddr string   `json:"metrics_addr"`
+	Progress    duration `json:"progress"`
+
+	// WARC archives the raw responses in the warc subdir of Out,
+	// in files of up to WARCMaxSizeMB megabytes.
+	WARC          bool `json:"warc"`
+	WARCMaxSizeMB int  `json:"warc_max_size_mb"`
+	// Cache keeps the responses in the cache subdir of Out as colly gob files
+	// and takes the pages which are already there from it.
+	Cache bool `json:"cache"`
+
+	// ReplayWARC and ReplayCache switch the program to the offline mode, which
+	// parses the responses stored by a previous crawl instead of crawling.
+	// ReplayWARC is a WARC file or a dir of them. ReplayCache is a colly cache
+	// dir and ReplayURLs is a file with the urls to take from it, one per line.
+	ReplayWARC  string `json:"replay_warc"`
+	ReplayCache string `json:"replay_cache"`
+	ReplayURLs  string `json:"replay_urls"`
+}
+
+type rateLimit struct {
+	// Parallelism is the number of requests sent to a host at once,
+	// 0 means the number of workers.
+	Parallelism int      `json:"parallelism"`
+	Delay       duration `json:"delay"`
+	RandomDelay duration `json:"random_delay"`
+}
+
+// robotsRules selects the robots directives of the pages which are honored
+type robotsRules struct {
+	NoIndex     bool `json:"noindex"`
+	NoFollow    bool `json:"nofollow"`
+	RelNoFollow bool `json:"rel_nofollow"`
+	RobotsTxt   bool `json:"robots_txt"`
+	CrawlDelay  bool `json:"crawl_delay"`
+}
+
+// trapRules limit the url spaces which never end, 0 disables a limit
+type trapRules struct {
+	MaxURLLength         int `json:"max_url_length"`
+	MaxSegmentRepeats    int `json:"max_segment_repeats"`
+	MaxParamCombinations int `json:"max_param_combinations"`
+	MaxTemplateURLs      int `json:"max_template_urls"`
+}
+
+// pageRules select the fetched pages which are saved, unlike the scope
+// their links are still followed
+type pageRules struct {
+	Include      []string `json:"include"`
+	Exclude      []string `json:"exclude"`
+	ContentTypes []string `json:"content_types"`
+}
+
+type scopeRules struct {
+	MaxDepth int      `json:"max_depth"`
+	PerHost  int      `json:"per_host"`
+	SameHost bool     `json:"same_host"`
+	Domains  []string `json:"domains"`
+	Include  []string `json:"include"`
+	Exclude  []string `json:"exclude"`
+}
+
+func defaultConfig() *config {
+	workers := max(runtime.NumCPU()/2, 1)
+
+	return &config{
+		Workers:        workers,
+		ParserWorkers:  workers,
+		UserAgent:      crawler.DefaultUserAgent,
+		RequestTimeout: duration(crawler.DefaultRequestTimeout),
+		RateLimit: rateLimit{
+			Delay:       duration(crawler.DefaultDelay),
+			RandomDelay: duration(crawler.DefaultRandomDelay),
+		},
+		Robots: robotsRules{
+			NoIndex:     crawler.DefaultRobotsDirectives.NoIndex,
+			NoFollow:    crawler.DefaultRobotsDirectives.NoFollow,
+			RelNoFollow: crawler.DefaultRobotsDirectives.RelNoFollow,
+			RobotsTxt:   crawler.DefaultRobotsDirectives.RobotsTxt,
+			CrawlDelay:  crawler.DefaultRobotsDirectives.CrawlDelay,
+		},
+		Traps: trapRules{
+			MaxURLLength:         crawler.DefaultTrapRules.MaxURLLength,
+			MaxSegmentRepeats:    crawler.DefaultTrapRules.MaxSegmentRepeats,
+			MaxParamCombinations: crawler.DefaultTrapRules.MaxParamCombinations,
+			MaxTemplateURLs:      crawler.DefaultTrapRules.MaxTemplateURLs,
+		},
+		DupDistance:   parser.DefaultDuplicateDistance,
+		Languages:     append([]string(nil), parser.DefaultAcceptedLanguages...),
+		Sinks:         []string{textSink, jsonlSink},
+		Progress:      duration(10 * time.Second),
+		WARCMaxSizeMB: warc.DefaultMaxFileSize >> 20,
+	}
+}
+
+// loadConfigFile reads the values of the file at path over the values of c
+func loadConfigFile(path string, c *config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (c *config) isReplay() bool {
+	return c.ReplayWARC != "" || c.ReplayCache != ""
+}
+
+func (c *config) validate() error {
+	if c.isReplay() {
+		return c.validateReplay()
+	}
+
+	switch {
+	case len(c.Seeds) == 0:
+		return errors.New("provide entry urls with -urls or \"seeds\"")
+	case c.Out == "":
+		return errors.New("provide output dir path with -out or \"out\"")
+	case c.Timeout <= 0 && c.TargetDocs <= 0:
+		return errors.New("provide a timeout with -timeout or a target documents count with -docs")
+	case c.Timeout < 0 || c.TargetDocs < 0:
+		return errors.New("timeout and target documents count can not be negative")
+	case c.Workers < 1 || c.ParserWorkers < 1:
+		return errors.New("workers counts must be positive")
+	case c.RequestTimeout <= 0:
+		return errors.New("request timeout must be positive")
+	case c.RateLimit.Parallelism < 0 || c.RateLimit.Delay < 0 || c.RateLimit.RandomDelay < 0:
+		return errors.New("rate limits can not be negative")
+	case c.Scope.MaxDepth < 0 || c.Scope.PerHost < 0:
+		return errors.New("max depth and pages per host can not be negative")
+	case c.Traps.MaxURLLength < 0 || c.Traps.MaxSegmentRepeats < 0 || c.Traps.MaxParamCombinations < 0 || c.Traps.MaxTemplateURLs < 0:
+		return errors.New("trap limits can not be negative")
+	case c.DupDistance < 0 || c.DupDistance > parser.MaxDuplicateDistance:
+		return fmt.Errorf("near-duplicate distance must be in [0, %d]", parser.MaxDuplicateDistance)
+	case c.MinLangConfidence < 0 || c.MinLangConfidence > 1:
+		return errors.New("min language confidence must be in [0, 1]")
+	case !validSinks(c.Sinks):
+		return errors.New("sinks must be some of " + strings.Join(sinkNames, ", "))
+	case c.Attempts < 0:
+		return errors.New("attempts can not be negative")
+	case c.WARCMaxSizeMB < 1:
+		return errors.New("WARC file size must be at least 1 MB")
+	case c.Progress < 0:
+		return errors.New("progress interval can not be negative")
+	}
+
+	if _, err := c.sitemapsSince(); err != nil {
+		return err
+	}
+	if _, err := c.scope(); err != nil {
+		return err
+	}
+	_, err := c.pageFilters()
+
+	return err
+}
+
+func (c *config) validateReplay() error {
+	switch {
+	case c.ReplayWARC != "" && c.ReplayCache != "":
+		return errors.New("replay either WARC files or a cache dir")
+	case c.ReplayCache != "" && c.ReplayURLs == "":
+		return errors.New("provide the file with the urls to take from the cache with -replay-urls")
+	case c.Out == "":
+		return errors.New("provide output dir path with -out or \"out\"")
+	case c.MinLangConfidence < 0 || c.MinLangConfidence > 1:
+		return errors.New("min language confidence must be in [0, 1]")
+	case c.DupDistance < 0 || c.DupDistance > parser.MaxDuplicateDistance:
+		return fmt.Errorf("near-duplicate distance must be in [0, %d]", parser.MaxDuplicateDistance)
+	case !validSinks(c.Sinks):
+		return errors.New("sinks must be some of " + strings.Join(sinkNames, ", "))
+	}
+
+	if _, err := c.pageFilters(); err != nil {
+		return err
+	}
+
+	if c.WipeOutput {
+		out, err := filepath.Abs(c.Out)
+		if err != nil {
+			return err
+		}
+		for _, source := range []string{c.ReplayWARC, c.ReplayCache, c.ReplayURLs} {
+			if source == "" {
+				continue
+			}
+			if path, err := filepath.Abs(source); err != nil {
+				return err
+			} else if path == out || strings.HasPrefix(path, out+string(filepath.Separator)) {
+				return fmt.Errorf("-wipe would delete the replayed %s", source)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *config) sitemapsSince() (time.Time, error) {
+	if c.SitemapsSince == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.DateOnly, c.SitemapsSince)
+}
+
+func (c *config) scope() (scope crawler.Scope, err error) {
+	scope = crawler.Scope{
+		MaxDepth:        c.Scope.MaxDepth,
+		SameHost:        c.Scope.SameHost,
+		AllowedDomains:  c.Scope.Domains,
+		MaxPagesPerHost: c.Scope.PerHost,
+	}
+
+	if scope.Include, err = compileRegexps(c.Scope.Include); err != nil {
+		return
+	}
+	scope.Exclude, err = compileRegexps(c.Scope.Exclude)
+
+	return
+}
+
+// pageFilters returns the parser filters of the page rules, none when they are empty
+func (c *config) pageFilters() ([]parser.Filter, error) {
+	var filters []parser.Filter
+	if len(c.PageFilters.Include) > 0 || len(c.PageFilters.Exclude) > 0 {
+		include, err := compileRegexps(c.PageFilters.Include)
+		if err != nil {
+			return nil, err
+		}
+		exclude, err := compileRegexps(c.PageFilters.Exclude)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, parser.URLFilter(include, exclude))
+	}
+	if len(c.PageFilters.ContentTypes) > 0 {
+		filters = append(filters, parser.ContentTypeFilter(c.PageFilters.ContentTypes...))
+	}
+
+	return filters, nil
+}
+
+func (c *config) robots() crawler.RobotsDirectives {
+	return crawler.RobotsDirectives{
+		NoIndex:     c.Robots.NoIndex,
+		NoFollow:    c.Robots.NoFollow,
+		RelNoFollow: c.Robots.RelNoFollow,
+		RobotsTxt:   c.Robots.RobotsTxt,
+		CrawlDelay:  c.Robots.CrawlDelay,
+	}
+}
+
+func (c *config) traps() crawler.TrapRules {
+	return crawler.TrapRules{
+		MaxURLLength:         c.Traps.MaxURLLength,
+		MaxSegmentRepeats:    c.Traps.MaxSegmentRepeats,
+		MaxParamCombinations: c.Traps.MaxParamCombinations,
+		MaxTemplateURLs:      c.Traps.MaxTemplateURLs,
+	}
+}
+
+// Names of the document sinks
+const (
+	// textSink writes an N.txt file per document to the output dir
+	textSink = "text"
+	// jsonlSink appends the documents to documents.jsonl of the output dir
+	jsonlSink = "jsonl"
+	// tarSink writes the documents to a documents-<time>.tar.gz archive of the output dir
+	tarSink = "tar"
+)
+
+var sinkNames = []string{textSink, jsonlSink, tarSink}
+
+// validSinks reports whether names are known sinks, at least one of them
+func validSinks(names []string) bool {
+	for _, name := range names {
+		if !slices.Contains(sinkNames, name) {
+			return false
+		}
+	}
+
+	return len(names) > 0
+}
+
+// sinks opens the document sinks of the output dir
+func (c *config) sinks() ([]parser.DocumentSink, error) {
+	var sinks []parser.DocumentSink
+	for _, name := range c.Sinks {
+		var sink parser.DocumentSink
+		var err error
+		switch name {
+		case textSink:
+			if c.NoTextFiles {
+				continue
+			}
+			sink, err = parser.NewDirectorySink(c.Out)
+		case jsonlSink:
+			sink, err = parser.NewJSONLSink(filepath.Join(c.Out, "documents.jsonl"))
+		case tarSink:
+			sink, err = parser.NewTarSink(c.Out)
+		}
+		if err != nil {
+			for _, opened := range sinks {
+				opened.Close()
+			}
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, nil
+}
+
+func compileRegexps(exprs []string) ([]*regexp.Regexp, error) {
+	var regexps []*regexp.Regexp
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		regexps = append(regexps, re)
+	}
+
+	return regexps, nil
+}
+
+func (c *config) String() string {
+	return fmt.Sprintf("Timeout %v\n Target documents %d\n Workers %d\n Max depth %d\n Out dir %s\n Entry urls %s",
+		c.Timeout, c.TargetDocs, c.Workers, c.Scope.MaxDepth, c.Out, strings.Join(c.Seeds, "\n\t"))
+}
+
+// duration is a time.Duration written as "1m30s" in the config file and flags
+type duration time.Duration
+
+func (d duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	*d = duration(parsed)
+
+	return err
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string like \"1m30s\": %w", err)
+	}
+
+	return d.Set(value)
+}
+
+// listFlag is a flag with values separated by ;
+type listFlag struct {
+	values *[]string
+}
+
+func (l listFlag) String() string {
+	if l.values == nil {
+		return ""
+	}
+
+	return strings.Join(*l.values, ";")
+}
+
+func (l listFlag) Set(value string) error {
+	*l.values = nil
+	for _, item := range strings.Split(value, ";") {
+		if item != "" {
+			*l.values = append(*l.values, item)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"parser"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFileKeepsDefaultLanguages(t *testing.T) {
+	defaults := append([]string(nil), parser.DefaultAcceptedLanguages...)
+
+	path := writeConfigFile(t, `{"languages": ["en"]}`)
+	c := defaultConfig()
+	if err := loadConfigFile(path, c); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(c.Languages, []string{"en"}) {
+		t.Errorf("languages = %v", c.Languages)
+	}
+	if !slices.Equal(parser.DefaultAcceptedLanguages, defaults) {
+		t.Errorf("default languages changed to %v", parser.DefaultAcceptedLanguages)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	path := writeConfigFile(t, `{"seeds": ["http://example.com/"], "out": "out", "timeout": "1m", "workers": 2}`)
+
+	tests := []struct {
+		name  string
+		args  []string
+		check func(c *config) bool
+	}{
+		{"file", []string{"-config", path}, func(c *config) bool {
+			return c.Workers == 2 && time.Duration(c.Timeout) == time.Minute
+		}},
+		{"flags override file", []string{"-config", path, "-workers", "3"}, func(c *config) bool {
+			return c.Workers == 3
+		}},
+		{"list flag", []string{"-config", path, "-langs", "ru;en"}, func(c *config) bool {
+			return slices.Equal(c.Languages, []string{"ru", "en"})
+		}},
+		{"sitemaps since", []string{"-config", path, "-sitemaps-since", "2024-01-02"}, func(c *config) bool {
+			return c.Sitemaps
+		}},
+		{"crawl delay by default", []string{"-config", path}, func(c *config) bool {
+			return c.robots().CrawlDelay && !c.robots().RobotsTxt
+		}},
+		{"no crawl delay", []string{"-config", path, "-crawl-delay=false"}, func(c *config) bool {
+			return !c.robots().CrawlDelay
+		}},
+		{"page filters", []string{"-config", path, "-save-exclude", "/tag/;/page/", "-content-types", "text/html"}, func(c *config) bool {
+			filters, err := c.pageFilters()
+			return err == nil && len(filters) == 2 && slices.Equal(c.PageFilters.Exclude, []string{"/tag/", "/page/"})
+		}},
+	}
+
+	for _, test := range tests {
+		c, err := parseConfig(test.args)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !test.check(c) {
+			t.Errorf("%s: unexpected config %+v", test.name, c)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	valid := func() *config {
+		c := defaultConfig()
+		c.Seeds = []string{"http://example.com/"}
+		c.Out = "out"
+		c.Timeout = duration(time.Minute)
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *config)
+		valid  bool
+	}{
+		{"defaults", func(c *config) {}, true},
+		{"no seeds", func(c *config) { c.Seeds = nil }, false},
+		{"no limit", func(c *config) { c.Timeout = 0 }, false},
+		{"target docs", func(c *config) { c.Timeout, c.TargetDocs = 0, 10 }, true},
+		{"unknown sink", func(c *config) { c.Sinks = []string{"csv"} }, false},
+		{"no sinks", func(c *config) { c.Sinks = nil }, false},
+		{"confidence", func(c *config) { c.MinLangConfidence = 2 }, false},
+		{"exclude regexp", func(c *config) { c.Scope.Exclude = []string{"("} }, false},
+		{"page filters", func(c *config) { c.PageFilters.Include = []string{"/wiki/"} }, true},
+		{"page include regexp", func(c *config) { c.PageFilters.Include = []string{"["} }, false},
+		{"replay page exclude regexp", func(c *config) { c.ReplayWARC, c.PageFilters.Exclude = "crawl.warc.gz", []string{"["} }, false},
+		{"sitemaps since", func(c *config) { c.SitemapsSince = "yesterday" }, false},
+		{"replay", func(c *config) { c.ReplayWARC, c.Seeds, c.Timeout = "crawl.warc.gz", nil, 0 }, true},
+		{"replay cache without urls", func(c *config) { c.ReplayCache = "cache" }, false},
+	}
+
+	for _, test := range tests {
+		c := valid()
+		test.change(c)
+		if err := c.validate(); (err == nil) != test.valid {
+			t.Errorf("%s: validate() = %v", test.name, err)
+		}
+	}
+}
@@ -3,120 +3,273 @@ package main
 import (
 	"context"
 	"crawler"
-	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"parser"
-	"runtime"
-	"strconv"
-	"strings"
+	"path/filepath"
+	"syscall"
 	"time"
 )
 
-type cmdArgs struct {
-	out     string
-	urls    []string
-	timeOut int
-}
+// stateDirName is the subdirectory of the output dir where the crawler keeps
+// its frontier between runs
+const stateDirName = "state"
 
-func (c cmdArgs) String() string {
-	return fmt.Sprintf("Timeout %ds\n Out dir %s\n Entry urls %s", c.timeOut, c.out, strings.Join(c.urls, "\n\t"))
-}
+// linkGraphFileName is the file in the output dir where the crawler records
+// the links between pages, it is the input of the pagerank command
+const linkGraphFileName = "links.tsv"
 
-func indexOf(arr []string, pattern string) (index int) {
-	index = -1
+// scoreLogFileName is the file in the output dir where a best-first crawl
+// records the scores of the enqueued links
+const scoreLogFileName = "scores.tsv"
 
-	for i, val := range arr {
-		if val == pattern {
-			index = i
-			break
-		}
-	}
+// trapReportFileName is the file in the output dir with the url patterns
+// which have been skipped as crawler traps
+const trapReportFileName = "traps.tsv"
 
-	return
-}
+// rejectionLogFileName is the file in the output dir with the pages the
+// parser has rejected and the reasons
+const rejectionLogFileName = "rejected.tsv"
+
+// cacheDirName is the subdirectory of the output dir with the cached responses
+const cacheDirName = "cache"
 
-func parseUrls(arg string) ([]string, error) {
-	var err error
-	urls := strings.Split(arg, ";")
+// warcDirName is the subdirectory of the output dir with the WARC files
+const warcDirName = "warc"
 
-	if len(urls) == 0 {
-		err = errors.New("provide entry urls with separated by ;")
+// newFlagSet defines the flags over the values of c, so a flag which is
+// not given keeps the value c already has
+func newFlagSet(c *config, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s -urls <url;url...> -out <dir> (-timeout <duration> | -docs <n>) [flags]\n", fs.Name())
+		fmt.Fprintf(fs.Output(), "       %s -out <dir> (-replay-warc <path> | -replay-cache <dir> -replay-urls <file>) [flags]\n\n", fs.Name())
+		fmt.Fprintln(fs.Output(), "Flags override the values of the -config file.")
+		fs.PrintDefaults()
 	}
 
-	return urls, err
-}
+	fs.StringVar(configPath, "config", *configPath, "JSON `file` with the settings, see config.example.json")
 
-func parseArgs() (*cmdArgs, error) {
-	args := os.Args[1:]
+	fs.Var(listFlag{&c.Seeds}, "urls", "entry `urls` separated by ;")
+	fs.StringVar(&c.Out, "out", c.Out, "output `dir`")
+	fs.BoolVar(&c.WipeOutput, "wipe", c.WipeOutput, "delete the output dir and the crawl state before crawling")
+	fs.Var(&c.Timeout, "timeout", "stop the crawl after this `duration`")
+	fs.Int64Var(&c.TargetDocs, "docs", c.TargetDocs, "stop the crawl after `n` accepted documents")
 
-	urlsTagIndex := indexOf(args, "-urls")
-	if urlsTagIndex == -1 {
-		return nil, errors.New("provide entry urls with: -urls option[;option;...]")
-	}
+	fs.IntVar(&c.Workers, "workers", c.Workers, "number of concurrent requests")
+	fs.IntVar(&c.ParserWorkers, "parser-workers", c.ParserWorkers, "number of pages parsed at once")
+	fs.StringVar(&c.UserAgent, "user-agent", c.UserAgent, "User-Agent header of the requests")
+	fs.Var(&c.RequestTimeout, "request-timeout", "`duration` of a single request before it fails")
+	fs.IntVar(&c.RateLimit.Parallelism, "host-parallelism", c.RateLimit.Parallelism, "concurrent requests to a host, 0 means -workers")
+	fs.Var(&c.RateLimit.Delay, "delay", "`duration` between requests to a host")
+	fs.Var(&c.RateLimit.RandomDelay, "random-delay", "maximum random `duration` added to -delay")
 
-	urlsIndex := urlsTagIndex + 1
-	if urlsIndex >= len(args) {
-		_, err := parseUrls("")
-		return nil, err
-	}
+	fs.IntVar(&c.Scope.MaxDepth, "depth", c.Scope.MaxDepth, "maximum number of links from an entry url, 0 means no limit")
+	fs.IntVar(&c.Scope.PerHost, "per-host", c.Scope.PerHost, "maximum number of pages of a host, 0 means no limit")
+	fs.BoolVar(&c.Scope.SameHost, "same-host", c.Scope.SameHost, "crawl the hosts of the entry urls only")
+	fs.Var(listFlag{&c.Scope.Domains}, "domains", "crawl these `domains` separated by ; and their subdomains only")
+	fs.Var(listFlag{&c.Scope.Include}, "include", "crawl the urls matching one of these `regexps` separated by ; only")
+	fs.Var(listFlag{&c.Scope.Exclude}, "exclude", "skip the urls matching one of these `regexps` separated by ;")
+	fs.BoolVar(&c.Sitemaps, "sitemaps", c.Sitemaps, "enqueue the urls from the sitemaps of the entry hosts")
+	fs.StringVar(&c.SitemapsSince, "sitemaps-since", c.SitemapsSince, "enqueue the sitemap urls modified since this `yyyy-mm-dd` date only, implies -sitemaps")
+	fs.BoolVar(&c.Robots.NoIndex, "noindex", c.Robots.NoIndex, "skip the pages with a noindex meta robots tag or X-Robots-Tag, -noindex=false only counts them")
+	fs.BoolVar(&c.Robots.NoFollow, "nofollow", c.Robots.NoFollow, "skip the links of the pages with a nofollow meta robots tag or X-Robots-Tag, -nofollow=false only counts them")
+	fs.BoolVar(&c.Robots.RelNoFollow, "rel-nofollow", c.Robots.RelNoFollow, "skip the links with rel=nofollow, -rel-nofollow=false only counts them")
+	fs.BoolVar(&c.Robots.RobotsTxt, "robots-txt", c.Robots.RobotsTxt, "honor the Disallow rules and the Crawl-delay of robots.txt")
+	fs.BoolVar(&c.Robots.CrawlDelay, "crawl-delay", c.Robots.CrawlDelay, "honor the Crawl-delay of robots.txt even without -robots-txt")
+	fs.IntVar(&c.Traps.MaxURLLength, "max-url-length", c.Traps.MaxURLLength, "skip the found links longer than this, 0 means no limit")
+	fs.IntVar(&c.Traps.MaxSegmentRepeats, "max-segment-repeats", c.Traps.MaxSegmentRepeats, "skip the found links with a path segment repeated more times, 0 means no limit")
+	fs.IntVar(&c.Traps.MaxParamCombinations, "max-param-combinations", c.Traps.MaxParamCombinations, "maximum combinations of query parameter names of a host, 0 means no limit")
+	fs.IntVar(&c.Traps.MaxTemplateURLs, "max-template-urls", c.Traps.MaxTemplateURLs, "maximum urls which differ only in numbers, ids and query values, 0 means no limit")
+	fs.Var(listFlag{&c.Keywords}, "keywords", "fetch the links most similar to these `keywords` separated by ; first, the scores are written to "+scoreLogFileName)
 
-	urls, err := parseUrls(args[urlsIndex])
-	if err != nil {
-		return nil, err
-	}
+	fs.IntVar(&c.DupDistance, "dup-distance", c.DupDistance, "maximum SimHash distance of near-duplicate documents")
+	fs.BoolVar(&c.NoDedup, "no-dedup", c.NoDedup, "keep near-duplicate documents")
+	fs.BoolVar(&c.DupAliases, "dup-aliases", c.DupAliases, "write the urls of near-duplicates to the index as aliases")
+	fs.Var(listFlag{&c.Languages}, "langs", "accepted `languages` separated by ;")
+	fs.Float64Var(&c.MinLangConfidence, "min-lang-confidence", c.MinLangConfidence, "minimum confidence of the detected language")
+	fs.BoolVar(&c.FullText, "full-text", c.FullText, "keep the whole text of the pages instead of their main content")
+	fs.BoolVar(&c.ImageAltText, "image-alt-text", c.ImageAltText, "keep the alt text of the images as [image: text]")
+	fs.BoolVar(&c.LinkMarkers, "link-markers", c.LinkMarkers, "mark the text of the links as [link: text]")
+	fs.Var(listFlag{&c.PageFilters.Include}, "save-include", "save the pages whose urls match one of these `regexps` separated by ; only, the links of the others are still followed")
+	fs.Var(listFlag{&c.PageFilters.Exclude}, "save-exclude", "do not save the pages whose urls match one of these `regexps` separated by ;")
+	fs.Var(listFlag{&c.PageFilters.ContentTypes}, "content-types", "save the pages with one of these `content types` separated by ; only, e.g. text/html")
+	fs.Var(listFlag{&c.Sinks}, "sinks", "`sinks` the documents are written to separated by ;: text for an N.txt file per document, jsonl for documents.jsonl, tar for a documents-<time>.tar.gz archive")
+	fs.BoolVar(&c.NoTextFiles, "no-text-files", c.NoTextFiles, "leave the text sink out, without a text file per document")
 
-	outTagIndex := indexOf(args, "-out")
-	outIndex := outTagIndex + 1
+	fs.IntVar(&c.Attempts, "attempts", c.Attempts, "attempts of a failed request, 0 means the default policy")
+	fs.BoolVar(&c.RetryFailed, "retry-failed", c.RetryFailed, "retry the requests given up by previous runs")
+	fs.BoolVar(&c.Recrawl, "recrawl", c.Recrawl, "fetch again the pages of previous runs which have changed")
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", c.MetricsAddr, "serve Prometheus metrics at http://`addr`/metrics")
+	fs.BoolVar(&c.WARC, "warc", c.WARC, "archive the raw responses in WARC files in the warc subdir of the output dir")
+	fs.IntVar(&c.WARCMaxSizeMB, "warc-max-size", c.WARCMaxSizeMB, "`megabytes` after which a new WARC file is started")
+	fs.BoolVar(&c.Cache, "cache", c.Cache, "keep the responses in the cache subdir of the output dir and take the cached pages from it")
+	fs.StringVar(&c.ReplayWARC, "replay-warc", c.ReplayWARC, "parse the responses of a WARC `file or dir` instead of crawling")
+	fs.StringVar(&c.ReplayCache, "replay-cache", c.ReplayCache, "parse the responses of a colly cache `dir` instead of crawling")
+	fs.StringVar(&c.ReplayURLs, "replay-urls", c.ReplayURLs, "`file` with the urls to take from -replay-cache, e.g. state/visited.txt of the crawl")
+	fs.Var(&c.Progress, "progress", "`interval` of the progress log, 0 disables it")
 
-	if outTagIndex == -1 || outIndex >= len(args) {
-		return nil, errors.New("provide output dir path with: -out <..>")
-	}
+	return fs
+}
 
-	tTagIndex := indexOf(args, "-t")
-	tIndex := tTagIndex + 1
+// parseConfig reads the config file given with -config, if any,
+// and then the flags over it
+func parseConfig(args []string) (*config, error) {
+	var configPath string
+	newFlagSet(defaultConfig(), &configPath).Parse(args)
 
-	if tIndex == -1 || tIndex >= len(args) {
-		return nil, errors.New("provide timeout seconds with: -t <..>")
+	c := defaultConfig()
+	if configPath != "" {
+		if err := loadConfigFile(configPath, c); err != nil {
+			return nil, err
+		}
 	}
+	newFlagSet(c, &configPath).Parse(args)
 
-	timeoutSeconds, err := strconv.Atoi(args[tIndex])
-	if err != nil {
-		return nil, err
+	if c.SitemapsSince != "" {
+		c.Sitemaps = true
 	}
 
-	return &cmdArgs{
-		out:     args[outIndex],
-		urls:    urls,
-		timeOut: timeoutSeconds,
-	}, nil
+	return c, c.validate()
 }
 
 func main() {
-	args, err := parseArgs()
+	config, err := parseConfig(os.Args[1:])
 	if err != nil {
 		log.Fatalln(err)
 	}
-	println(args.String())
+	println(config.String())
+
+	if config.WipeOutput {
+		if err := os.RemoveAll(config.Out); err != nil {
+			log.Fatalln(err)
+		}
+	}
 
-	halfWorkers := runtime.NumCPU() / 2
-	if halfWorkers == 0 {
-		halfWorkers = 1
+	// an interrupt stops the crawl the same way as the timeout, so the
+	// queue and the output files are saved. A second one kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(config.Timeout))
+		defer cancel()
+	}
+
+	dupDistance := config.DupDistance
+	if config.NoDedup {
+		dupDistance = parser.NoDuplicateDetection
+	}
+	dupPolicy := parser.SkipDuplicates
+	if config.DupAliases {
+		dupPolicy = parser.AliasDuplicates
+	}
+
+	parserOptions := []parser.Option{
+		parser.NearDuplicates(dupDistance, dupPolicy),
+		parser.AcceptedLanguages(config.Languages...),
+		parser.MinLanguageConfidence(config.MinLangConfidence),
+		parser.RejectionLog(filepath.Join(config.Out, rejectionLogFileName)),
+	}
+	if config.FullText {
+		parserOptions = append(parserOptions, parser.FullText())
 	}
+	if config.ImageAltText {
+		parserOptions = append(parserOptions, parser.ImageAltText())
+	}
+	if config.LinkMarkers {
+		parserOptions = append(parserOptions, parser.LinkMarkers())
+	}
+	pageFilters, _ := config.pageFilters()
+	parserOptions = append(parserOptions, parser.MoreFilters(pageFilters...))
+	sinks, err := config.sinks()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	parserOptions = append(parserOptions, parser.Sinks(sinks...))
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(args.timeOut)*time.Second)
-	defer cancel()
+	parserWorkers := config.ParserWorkers
+	if config.isReplay() {
+		// a single worker numbers the documents in the order of the responses,
+		// so a replay of the same responses gives the same output
+		parserWorkers = 1
+	}
 
-	parser, err := parser.New(ctx, args.out, halfWorkers)
+	parser, err := parser.New(ctx, config.Out, parserWorkers, parserOptions...)
 	if err != nil {
 		println(err)
 		return
 	}
 
-	crawler, err := crawler.New(parser, halfWorkers)
+	if config.isReplay() {
+		if err := replay(ctx, config, parser); err != nil {
+			log.Println(err)
+		}
+		parser.Wait()
+		return
+	}
+
+	// validate has checked the scope and the date
+	scope, _ := config.scope()
+	sitemapsSince, _ := config.sitemapsSince()
+
+	options := []crawler.Option{
+		crawler.StateDir(filepath.Join(config.Out, stateDirName)),
+		crawler.WithScope(scope),
+		crawler.LinkGraph(filepath.Join(config.Out, linkGraphFileName)),
+		crawler.UserAgent(config.UserAgent),
+		crawler.RequestTimeout(time.Duration(config.RequestTimeout)),
+		crawler.RateLimit(config.RateLimit.Parallelism, time.Duration(config.RateLimit.Delay), time.Duration(config.RateLimit.RandomDelay)),
+		crawler.HonorRobots(config.robots()),
+		crawler.Traps(config.traps()),
+		crawler.TrapReport(filepath.Join(config.Out, trapReportFileName)),
+	}
+	if config.TargetDocs > 0 {
+		options = append(options, crawler.TargetDocuments(config.TargetDocs))
+	}
+	if config.Attempts > 0 {
+		retryPolicy := crawler.DefaultRetryPolicy
+		retryPolicy.MaxAttempts = config.Attempts
+		options = append(options, crawler.Retries(retryPolicy))
+	}
+	if config.RetryFailed {
+		options = append(options, crawler.RequeueDeadLetters())
+	}
+	if config.Recrawl {
+		options = append(options, crawler.Recrawl())
+	}
+	if config.Sitemaps {
+		options = append(options, crawler.Sitemaps(sitemapsSince))
+	}
+	if len(config.Keywords) > 0 {
+		options = append(options,
+			crawler.BestFirst(config.Keywords...),
+			crawler.ScoreLog(filepath.Join(config.Out, scoreLogFileName)),
+		)
+	}
+	if config.MetricsAddr != "" {
+		options = append(options, crawler.MetricsAddr(config.MetricsAddr))
+	}
+	if config.WARC {
+		options = append(options, crawler.WARC(filepath.Join(config.Out, warcDirName), int64(config.WARCMaxSizeMB)<<20))
+	}
+	if config.Cache {
+		options = append(options, crawler.CacheDir(filepath.Join(config.Out, cacheDirName)))
+	}
+	if config.Progress > 0 {
+		options = append(options, crawler.Progress(time.Duration(config.Progress)))
+	}
+
+	crawler, err := crawler.New(parser, config.Workers, options...)
 	if err == nil {
-		crawler.Crawl(ctx, args.urls)
+		crawler.Crawl(ctx, config.Seeds)
 		parser.Wait()
 	} else {
 		println(err)
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crawler"
+	"crawler/warc"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// replay passes the responses stored by a previous crawl to processor
+func replay(ctx context.Context, config *config, processor crawler.HtmlBodyProccessor) error {
+	var responses iter.Seq2[crawler.StoredResponse, error]
+	if config.ReplayWARC != "" {
+		paths, err := warcPaths(config.ReplayWARC)
+		if err != nil {
+			return err
+		}
+		responses = crawler.WARCResponses(paths...)
+	} else {
+		urls, err := readUrls(config.ReplayURLs)
+		if err != nil {
+			return err
+		}
+		responses = crawler.CachedResponses(config.ReplayCache, urls)
+	}
+
+	replayer, err := crawler.New(processor, 1, crawler.HonorRobots(config.robots()))
+	if err != nil {
+		return err
+	}
+
+	return replayer.Replay(ctx, responses)
+}
+
+// warcPaths returns path if it is a file, otherwise the WARC files in it
+// in the order of their names, which is the order they were written in
+func warcPaths(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var paths []string
+	for _, pattern := range []string{"*" + warc.FileExtension, "*.warc"} {
+		matches, err := filepath.Glob(filepath.Join(path, pattern))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// readUrls reads a file with a url per line
+func readUrls(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var urls []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if u := strings.TrimSpace(scanner.Text()); u != "" {
+			urls = append(urls, u)
+		}
+	}
+
+	return urls, scanner.Err()
+}
@@ -3,12 +3,14 @@ package fileReader
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"iter"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type fileMetaData struct {
@@ -22,21 +24,43 @@ func readJsonFile(filePath string) ([]*fileMetaData, error) {
 	}
 	defer f.Close()
 
-	bytes, err := io.ReadAll(f)
-	if err != nil {
-		log.Fatalf("Failed to read file: %v", err)
-	}
-
-	// Parse the JSON data
+	// Parse the JSON data, an object per line
 	var records []*fileMetaData
-	err = json.Unmarshal(bytes, &records)
-	if err != nil {
-		log.Fatalf("Failed to parse JSON: %v", err)
+	decoder := json.NewDecoder(f)
+	for decoder.More() {
+		record := &fileMetaData{}
+		if err := decoder.Decode(record); err != nil {
+			log.Fatalf("Failed to parse JSON: %v", err)
+		}
+		records = append(records, record)
 	}
 
 	return records, nil
 }
 
+// readDeletedIds reads the ids of the documents deleted by a recrawl,
+// one per line. There is no file when nothing has been deleted.
+func readDeletedIds(filePath string) (map[string]struct{}, error) {
+	deleted := make(map[string]struct{})
+
+	f, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return deleted, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			deleted[id] = struct{}{}
+		}
+	}
+
+	return deleted, scanner.Err()
+}
+
 type fileReader struct {
 	path string
 }
@@ -75,12 +99,27 @@ func New(indexFilePath string) (*filesIterator, error) {
 		return nil, err
 	}
 
-	filePaths := make([]string, len(lines))
-
 	dirPath := filepath.Dir(indexFilePath)
-	for i, fileInfo := range lines {
+	deletedIds, err := readDeletedIds(filepath.Join(dirPath, "deleted.txt"))
+	if err != nil {
+		return nil, err
+	}
+
+	filePaths := make([]string, 0, len(lines))
+	seenIds := make(map[string]struct{}, len(lines))
+
+	for _, fileInfo := range lines {
+		// aliases of near-duplicate pages share the file of their original
+		if _, seen := seenIds[fileInfo.Id]; seen {
+			continue
+		}
+		seenIds[fileInfo.Id] = struct{}{}
+
+		if _, deleted := deletedIds[fileInfo.Id]; deleted {
+			continue
+		}
 
-		filePaths[i] = filepath.Join(dirPath, fmt.Sprintf("%s.txt", fileInfo.Id))
+		filePaths = append(filePaths, filepath.Join(dirPath, fmt.Sprintf("%s.txt", fileInfo.Id)))
 	}
 
 	return &filesIterator{
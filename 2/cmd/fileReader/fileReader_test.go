@@ -0,0 +1,48 @@
+package fileReader
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNewSkipsDeletedDocuments(t *testing.T) {
+	// deleted.txt as the parser leaves it: 2 gone, 3 gone and then saved again
+	// is rewritten without 3, it is empty once all of them are back
+	tests := []struct {
+		name    string
+		deleted string
+		want    []string
+	}{
+		{"gone", "2\n3\n", []string{"1"}},
+		{"back again", "2\n", []string{"1", "3"}},
+		{"all back", "", []string{"1", "2", "3"}},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		index := `{"id":"1","url":"http://example.com/1"}` + "\n" + `{"id":"2","url":"http://example.com/2"}` + "\n" +
+			`{"id":"3","url":"http://example.com/3"}` + "\n" + `{"id":"1","url":"http://example.com/alias"}` + "\n"
+		writeFile(t, filepath.Join(dir, "index.json"), index)
+		writeFile(t, filepath.Join(dir, "deleted.txt"), test.deleted)
+
+		files, err := New(filepath.Join(dir, "index.json"))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var want []string
+		for _, id := range test.want {
+			want = append(want, filepath.Join(dir, id+".txt"))
+		}
+		if !slices.Equal(files.filePaths, want) {
+			t.Errorf("%s: got %v, want %v", test.name, files.filePaths, want)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,3 +1,3 @@
-module main
+module indexer
 
 go 1.24.1
@@ -3,8 +3,9 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"log"
 	"os"
 	"path"
@@ -31,7 +32,7 @@ func main() {
 
 	dir := filepath.Dir(indexPath)
 
-	invertedIndex := simplifyIndex(createIndexFromFiles(dir, parseMetadata(indexPath)))
+	invertedIndex := simplifyIndex(createIndexFromFiles(dir, parseMetadata(indexPath), parseDeletedIds(dir)))
 
 	outputPath := filepath.Join(outputDir, "inverted_index.json")
 	outputData, err := json.MarshalIndent(invertedIndex, "", "  ")
@@ -71,23 +72,59 @@ func txtOutPut(dir string, index map[string][]fileId) {
 	writer.Flush()
 }
 
+// parseMetadata reads index.json, a JSON object per line
 func parseMetadata(indexPath string) (entries []*FileMeta) {
-	data, err := ioutil.ReadFile(indexPath)
+	file, err := os.Open(indexPath)
 	if err != nil {
 		log.Fatalln("Error reading index.json:", err)
 	}
+	defer file.Close()
 
-	if err := json.Unmarshal(data, &entries); err != nil {
-		log.Fatalln("Error parsing index.json:", err)
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		entry := &FileMeta{}
+		if err := decoder.Decode(entry); err != nil {
+			log.Fatalln("Error parsing index.json:", err)
+		}
+		entries = append(entries, entry)
 	}
 
 	return
 }
 
-func createIndexFromFiles(dir string, entries []*FileMeta) (invertedIndex map[string]map[fileId]void) {
+// parseDeletedIds reads deleted.txt, the ids of the documents deleted by a recrawl
+func parseDeletedIds(dir string) map[fileId]void {
+	deleted := make(map[fileId]void)
+
+	data, err := os.ReadFile(filepath.Join(dir, "deleted.txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return deleted
+	} else if err != nil {
+		log.Fatalln("Error reading deleted.txt:", err)
+	}
+
+	for _, id := range strings.Fields(string(data)) {
+		deleted[id] = void{}
+	}
+
+	return deleted
+}
+
+func createIndexFromFiles(dir string, entries []*FileMeta, deleted map[fileId]void) (invertedIndex map[string]map[fileId]void) {
 	invertedIndex = make(map[string]map[fileId]void)
+	indexedFiles := make(map[fileId]void, len(entries))
 
 	for _, entry := range entries {
+		// aliases of near-duplicate pages share the file of their original
+		if _, indexed := indexedFiles[entry.Id]; indexed {
+			continue
+		}
+		indexedFiles[entry.Id] = void{}
+
+		if _, isDeleted := deleted[entry.Id]; isDeleted {
+			continue
+		}
+
 		txtPath := filepath.Join(dir, fmt.Sprintf("%s.txt", entry.Id))
 
 		file, err := os.Open(txtPath)
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCreateIndexSkipsDeletedDocuments(t *testing.T) {
+	// deleted.txt as the parser leaves it: 2 gone, 3 gone and then saved again
+	// is rewritten without 3
+	tests := []struct {
+		name    string
+		deleted string
+		want    []fileId
+	}{
+		{"nothing deleted", "", []fileId{"1", "2", "3"}},
+		{"gone", "2\n3\n", []fileId{"1"}},
+		{"back again", "2\n", []fileId{"1", "3"}},
+	}
+
+	entries := []*FileMeta{{Id: "1", Url: "http://example.com/1"}, {Id: "2", Url: "http://example.com/2"}, {Id: "3", Url: "http://example.com/3"}}
+	for _, test := range tests {
+		dir := t.TempDir()
+		for _, entry := range entries {
+			writeFile(t, filepath.Join(dir, entry.Id+".txt"), "page "+entry.Id)
+		}
+		if test.deleted != "" {
+			writeFile(t, filepath.Join(dir, "deleted.txt"), test.deleted)
+		}
+
+		index := simplifyIndex(createIndexFromFiles(dir, entries, parseDeletedIds(dir)))
+		got := index["page"]
+		slices.Sort(got)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	writeFile(t, path, `{"id":"1","url":"http://example.com/1","lang":"ru","simhash":"00000000000000ff"}
+{"id":"2","url":"http://example.com/2","lang":"ru"}
+`)
+
+	entries := parseMetadata(path)
+	if len(entries) != 2 || *entries[0] != (FileMeta{Id: "1", Url: "http://example.com/1"}) || entries[1].Id != "2" {
+		t.Errorf("unexpected entries %v", entries)
+	}
+}
@@ -7,7 +7,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"sort"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -49,12 +49,13 @@ func searchEngine(interruptSignal <-chan os.Signal, index InvertedIndex) {
 			}
 			trimmedQuery := strings.TrimSpace(line)
 			result, err := google.Search(trimmedQuery)
-			intResult := make([]int, 0, len(result))
+			// the ids are 63 bit hashes of the page urls, they do not fit an int everywhere
+			intResult := make([]int64, 0, len(result))
 			for _, result := range result {
-				val, _ := strconv.Atoi(result)
+				val, _ := strconv.ParseInt(result, 10, 64)
 				intResult = append(intResult, val)
 			}
-			sort.Ints(intResult)
+			slices.Sort(intResult)
 
 			if err != nil {
 				fmt.Println(err)
@@ -1,3 +1,3 @@
-module main
+module vectorsearch
 
 go 1.24.1
@@ -4,8 +4,10 @@ import (
 	"bufio"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"math"
 	"os"
@@ -18,8 +20,14 @@ const IDF_CSV_PATH path = "C:\\CustomDesktop\\informations search\\4\\output\\id
 const TF_IDF_CSV_PATH path = "C:\\CustomDesktop\\informations search\\4\\output\\tf-idf.csv"
 const FILE_TO_URL_JSON_PATH path = "C:\\CustomDesktop\\informations search\\2\\output\\index.json"
 const INVERTED_INDEX_JSON_PATH path = "C:\\CustomDesktop\\informations search\\3\\output\\inverted_index.json"
+const PAGERANK_CSV_PATH path = "C:\\CustomDesktop\\informations search\\1\\output\\pagerank.csv"
 const MAX_OUTPUT int = 10
 
+// PAGERANK_WEIGHT is how much the PageRank prior boosts the similarity of
+// a document to the query, the document with the highest PageRank gets
+// a similarity 1+PAGERANK_WEIGHT times higher
+const PAGERANK_WEIGHT float64 = 0.2
+
 type fileId = string
 type url = string
 type word = string
@@ -41,7 +49,7 @@ func (sr *SearchResult) String() string {
 }
 
 func main() {
-	idf, tfIdf, indexMap, vocab := load()
+	idf, tfIdf, indexMap, vocab, pageRank := load()
 	docVectors := calculateDocVectors(tfIdf, vocab)
 
 	reader := bufio.NewReader(os.Stdin)
@@ -70,21 +78,7 @@ func main() {
 			}
 		}
 
-		var results []SearchResult
-		for fileId, docVector := range docVectors {
-			similarityScore := cosineSimilarity(queryVector, docVector)
-			if similarityScore > 0 {
-				results = append(results, SearchResult{
-					FileId: fileId,
-					Score:  similarityScore,
-					Url:    indexMap[fileId],
-				})
-			}
-		}
-
-		sort.Slice(results, func(i, j int) bool {
-			return results[i].Score > results[j].Score
-		})
+		results := rank(queryVector, docVectors, pageRank, indexMap)
 
 		var cutoff int
 		if len := len(results); len < MAX_OUTPUT {
@@ -104,6 +98,34 @@ func main() {
 	}
 }
 
+// rank returns the documents similar to the query, the most similar first.
+// The PageRank prior multiplies the similarity, so it reorders the documents
+// which match the query but never lets a popular page which does not match
+// it outrank the ones which do.
+func rank(queryVector []float64, docVectors map[fileId][]float64, pageRank map[fileId]float64, indexMap map[fileId]url) []SearchResult {
+	var results []SearchResult
+	for fileId, docVector := range docVectors {
+		similarityScore := cosineSimilarity(queryVector, docVector)
+		if similarityScore > 0 {
+			if pageRank != nil {
+				similarityScore *= 1 + PAGERANK_WEIGHT*pageRank[fileId]
+			}
+
+			results = append(results, SearchResult{
+				FileId: fileId,
+				Score:  similarityScore,
+				Url:    indexMap[fileId],
+			})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	return results
+}
+
 func cosineSimilarity(vec1, vec2 []float64) float64 {
 	var productSum, normA, normB float64
 
@@ -123,20 +145,30 @@ func cosineSimilarity(vec1, vec2 []float64) float64 {
 }
 
 func loadFileToUrlMapping() map[fileId]url {
-	file, err := os.ReadFile(FILE_TO_URL_JSON_PATH)
+	file, err := os.Open(FILE_TO_URL_JSON_PATH)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	defer file.Close()
 
+	// index.json has a JSON object per line
 	var index []DocIndex
-	err = json.Unmarshal(file, &index)
-	if err != nil {
-		log.Fatalln(err)
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var item DocIndex
+		if err := decoder.Decode(&item); err != nil {
+			log.Fatalln(err)
+		}
+		index = append(index, item)
 	}
 
 	result := make(map[string]string)
 	for _, item := range index {
-		result[item.Id] = item.Url
+		// near-duplicate pages are listed after their original document
+		// with the same id, the original url is shown in results
+		if _, exists := result[item.Id]; !exists {
+			result[item.Id] = item.Url
+		}
 	}
 	return result
 }
@@ -237,11 +269,48 @@ func loadIdf() map[word]float64 {
 	return idf
 }
 
-func load() (idf map[word]float64, tfIdf map[fileId]map[word]float64, indexMap map[fileId]url, vocab []word) {
+// loadPageRank returns the PageRank scores of documents divided by the
+// highest score, so the best document gets 1. It returns nil when there
+// is no score file, then documents are ranked by similarity only.
+func loadPageRank() map[fileId]float64 {
+	file, err := os.Open(PAGERANK_CSV_PATH)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Println("no PageRank scores, ranking by similarity only")
+		return nil
+	} else if err != nil {
+		log.Fatalln(err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	records, _ := reader.ReadAll()
+	if len(records) < 2 {
+		return nil
+	}
+
+	pageRank := make(map[fileId]float64, len(records)-1)
+	var maxScore float64
+	for _, record := range records[1:] {
+		score := parseFloat(record[1])
+		pageRank[record[0]] = score
+		maxScore = max(maxScore, score)
+	}
+
+	if maxScore > 0 {
+		for fileId, score := range pageRank {
+			pageRank[fileId] = score / maxScore
+		}
+	}
+
+	return pageRank
+}
+
+func load() (idf map[word]float64, tfIdf map[fileId]map[word]float64, indexMap map[fileId]url, vocab []word, pageRank map[fileId]float64) {
 	idf = loadIdf()
 	tfIdf = loadTfIdf()
 	indexMap = loadFileToUrlMapping()
 	vocab = loadVocabFromInverteIndex()
+	pageRank = loadPageRank()
 
 	return
 }
@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestRankPageRankPrior(t *testing.T) {
+	// the query is the first word, the relevant documents match it weakly
+	// and the hub barely mentions it among many other words
+	queryVector := []float64{1, 0, 0, 0}
+	docVectors := map[fileId][]float64{
+		"relevant": {1, 6, 0, 0},
+		"hub":      {0.05, 1, 1, 1},
+		"popular":  {1, 6, 0, 0},
+		"other":    {0, 1, 0, 0},
+	}
+	pageRank := map[fileId]float64{
+		"relevant": 0.01,
+		"hub":      1,
+		"popular":  0.5,
+		"other":    0.9,
+	}
+
+	results := rank(queryVector, docVectors, pageRank, nil)
+
+	var order []fileId
+	for _, result := range results {
+		order = append(order, result.FileId)
+	}
+	want := []fileId{"popular", "relevant", "hub"}
+	if len(order) != len(want) {
+		t.Fatalf("got %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got %v, want %v", order, want)
+		}
+	}
+}
+
+func TestRankWithoutPageRank(t *testing.T) {
+	queryVector := []float64{1, 1}
+	docVectors := map[fileId][]float64{
+		"both": {1, 1},
+		"one":  {1, 0},
+		"none": {0, 0},
+	}
+
+	results := rank(queryVector, docVectors, nil, map[fileId]url{"both": "http://example.com/"})
+
+	if len(results) != 2 || results[0].FileId != "both" || results[0].Url != "http://example.com/" || results[1].FileId != "one" {
+		t.Fatalf("unexpected results %v", results)
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b []float64
+		want float64
+	}{
+		{[]float64{1, 0}, []float64{1, 0}, 1},
+		{[]float64{1, 0}, []float64{0, 1}, 0},
+		{[]float64{0, 0}, []float64{1, 1}, 0},
+		{[]float64{3, 4}, []float64{6, 8}, 1},
+	}
+
+	for _, test := range tests {
+		if got := cosineSimilarity(test.a, test.b); got < test.want-1e-9 || got > test.want+1e-9 {
+			t.Errorf("cosineSimilarity(%v, %v) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}